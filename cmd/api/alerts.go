@@ -0,0 +1,483 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/ringbuffer"
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// Thresholds at which a health alert is escalated from a warning to critical.
+const (
+	criticalFeverTemperature = 40.5 // in Celsius
+	hypothermiaTemperature   = 37.5 // in Celsius
+	criticalHeartRate        = 100  // beats per minute
+)
+
+// The sources an alert can be raised from.
+const (
+	alertSourceCow   = "cow"
+	alertSourceDrone = "drone"
+)
+
+// alertTypes lists the types of alert the health monitor raises.
+var alertTypes = []string{"fever", "hypothermia", "high_heart_rate", "inactivity", "air_quality"}
+
+// Alert represents a health condition that needs an operator's attention
+type Alert struct {
+	Farm       string     `json:"farm"`
+	Type       string     `json:"type"`     // fever, hypothermia, high_heart_rate, inactivity, air_quality
+	Severity   string     `json:"severity"` // warning, critical
+	Source     string     `json:"source"`   // cow, drone
+	CowID      int        `json:"cow_id,omitempty"`
+	CowName    string     `json:"cow_name,omitempty"`
+	DroneID    int        `json:"drone_id,omitempty"`
+	DroneName  string     `json:"drone_name,omitempty"`
+	HerdID     *int       `json:"herd_id,omitempty"`
+	Zone       string     `json:"zone"`
+	Message    string     `json:"message"`
+	Reason     string     `json:"reason,omitempty"` // explains which rule fired, for composite rules
+	Value      float64    `json:"value"`
+	Threshold  float64    `json:"threshold"`
+	RaisedAt   time.Time  `json:"raised_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"` // only set in the alert history
+}
+
+// key identifies an alert condition independently of when it was raised, so the same
+// condition is only reported once while it persists. IDs are only unique within a farm,
+// so the key includes the farm.
+func (a Alert) key() string {
+	if a.Source == alertSourceDrone {
+		return fmt.Sprintf("%s:drone:%d:%s", a.Farm, a.DroneID, a.Type)
+	}
+	return fmt.Sprintf("%s:cow:%d:%s", a.Farm, a.CowID, a.Type)
+}
+
+// SubjectKind returns "Cow" or "Drone", for labelling the alert's subject in notifications.
+func (a Alert) SubjectKind() string {
+	if a.Source == alertSourceDrone {
+		return "Drone"
+	}
+	return "Cow"
+}
+
+// Subject names the cow or drone the alert is about, e.g. "Bessie (#1)".
+func (a Alert) Subject() string {
+	if a.Source == alertSourceDrone {
+		return fmt.Sprintf("%s (#%d)", a.DroneName, a.DroneID)
+	}
+	return fmt.Sprintf("%s (#%d)", a.CowName, a.CowID)
+}
+
+// detectCowAlerts evaluates a cow's latest health readings against the alert thresholds.
+func detectCowAlerts(cow Cow, now time.Time) []Alert {
+	var alerts []Alert
+
+	newAlert := func(alertType, severity, message string, value, threshold float64) Alert {
+		return Alert{
+			Type:      alertType,
+			Severity:  severity,
+			Source:    alertSourceCow,
+			CowID:     cow.ID,
+			CowName:   cow.Name,
+			HerdID:    cow.HerdID,
+			Zone:      cow.Location.Zone,
+			Message:   message,
+			Value:     value,
+			Threshold: threshold,
+			RaisedAt:  now,
+		}
+	}
+
+	temperature := cow.Health.Temperature
+	switch {
+	case temperature >= criticalFeverTemperature:
+		alerts = append(alerts, newAlert("fever", "critical", "Temperature is critically high", temperature, criticalFeverTemperature))
+	case temperature >= feverTemperature:
+		alerts = append(alerts, newAlert("fever", "warning", "Temperature is above normal", temperature, feverTemperature))
+	case temperature > 0 && temperature < hypothermiaTemperature:
+		alerts = append(alerts, newAlert("hypothermia", "critical", "Temperature is below normal", temperature, hypothermiaTemperature))
+	}
+
+	heartRate := cow.Health.HeartRate
+	switch {
+	case heartRate > criticalHeartRate:
+		alerts = append(alerts, newAlert("high_heart_rate", "critical", "Heart rate is critically high", float64(heartRate), criticalHeartRate))
+	case heartRate > maxNormalHeartRate:
+		alerts = append(alerts, newAlert("high_heart_rate", "warning", "Heart rate is above normal", float64(heartRate), maxNormalHeartRate))
+	}
+
+	return alerts
+}
+
+// detectActivityAnomaly flags a cow which has been resting for longer than maxResting
+// while its heart rate is elevated, which may mean it's lying down in distress. The
+// history must be in chronological order.
+func detectActivityAnomaly(cow Cow, history []CowSensorReading, maxResting time.Duration, now time.Time) (Alert, bool) {
+	if len(history) == 0 {
+		return Alert{}, false
+	}
+
+	latest := history[len(history)-1]
+	if latest.Sensors.Activity != "resting" || latest.Sensors.HeartRate <= maxNormalHeartRate {
+		return Alert{}, false
+	}
+
+	// Walk back through the history to find when the current resting period began.
+	restingSince := latest.RecordedAt
+	for i := len(history) - 1; i >= 0 && history[i].Sensors.Activity == "resting"; i-- {
+		restingSince = history[i].RecordedAt
+	}
+
+	resting := now.Sub(restingSince)
+	if resting <= maxResting {
+		return Alert{}, false
+	}
+
+	return Alert{
+		Type:     "inactivity",
+		Severity: "critical",
+		Source:   alertSourceCow,
+		CowID:    cow.ID,
+		CowName:  cow.Name,
+		HerdID:   cow.HerdID,
+		Zone:     cow.Location.Zone,
+		Message:  "Cow has been resting for an extended period with an elevated heart rate",
+		Reason: fmt.Sprintf("resting for %s (limit %s) with heart rate %d bpm (limit %d bpm)",
+			resting.Round(time.Minute), maxResting, latest.Sensors.HeartRate, maxNormalHeartRate),
+		Value:     resting.Minutes(),
+		Threshold: maxResting.Minutes(),
+		RaisedAt:  now,
+	}, true
+}
+
+// detectDroneAlerts evaluates the drone's latest air quality reading against the AQI
+// thresholds. Poor air over the pasture may mean a fire or a build-up of manure gas.
+func detectDroneAlerts(drone Drone, warningAQI, criticalAQI float64, now time.Time) []Alert {
+	newAlert := func(severity, message string, threshold float64) Alert {
+		return Alert{
+			Type:      "air_quality",
+			Severity:  severity,
+			Source:    alertSourceDrone,
+			DroneID:   drone.ID,
+			DroneName: drone.Name,
+			Zone:      drone.Location.Zone,
+			Message:   message,
+			Value:     drone.Sensors.AirQuality,
+			Threshold: threshold,
+			RaisedAt:  now,
+		}
+	}
+
+	aqi := drone.Sensors.AirQuality
+	switch {
+	case aqi > criticalAQI:
+		return []Alert{newAlert("critical", "Air quality is hazardous", criticalAQI)}
+	case aqi > warningAQI:
+		return []Alert{newAlert("warning", "Air quality is unhealthy", warningAQI)}
+	}
+
+	return nil
+}
+
+// AlertRegistry holds the alerts that are currently active, and a history of the most
+// recently resolved alerts. It's updated by the health monitor and read by the alert
+// handlers.
+type AlertRegistry struct {
+	mu       sync.RWMutex
+	active   map[string]Alert
+	resolved *ringbuffer.Buffer[Alert] // in the order they were resolved
+}
+
+// newAlertRegistry returns an empty AlertRegistry which keeps the given number of
+// resolved alerts in its history.
+func newAlertRegistry(historySize int) *AlertRegistry {
+	return &AlertRegistry{
+		active:   make(map[string]Alert),
+		resolved: ringbuffer.New[Alert](historySize),
+	}
+}
+
+// Reconcile replaces the active alerts with the given set of detected alerts, returning
+// the alerts which have been newly raised and those which have been resolved since the
+// last call. Alerts which are still active keep their original RaisedAt time, but pick up
+// the latest severity and reading. Resolved alerts are added to the history, resolved at
+// now.
+func (reg *AlertRegistry) Reconcile(detected []Alert, now time.Time) ([]Alert, []Alert) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	var raised, resolved []Alert
+	next := make(map[string]Alert, len(detected))
+
+	for _, alert := range detected {
+		key := alert.key()
+		if existing, ok := reg.active[key]; ok {
+			alert.RaisedAt = existing.RaisedAt
+		} else {
+			raised = append(raised, alert)
+		}
+		next[key] = alert
+	}
+
+	for key, alert := range reg.active {
+		if _, ok := next[key]; !ok {
+			alert.ResolvedAt = &now
+			resolved = append(resolved, alert)
+		}
+	}
+
+	// Add the resolved alerts to the history in the order they were raised, so that it
+	// doesn't depend on the order of the map.
+	sort.Slice(resolved, func(i, j int) bool {
+		if !resolved[i].RaisedAt.Equal(resolved[j].RaisedAt) {
+			return resolved[i].RaisedAt.Before(resolved[j].RaisedAt)
+		}
+		return resolved[i].key() < resolved[j].key()
+	})
+	for _, alert := range resolved {
+		reg.resolved.Push(alert)
+	}
+
+	reg.active = next
+	return raised, resolved
+}
+
+// RaisedAt returns when the alert's condition was first raised, if it's currently active.
+func (reg *AlertRegistry) RaisedAt(alert Alert) (time.Time, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	existing, ok := reg.active[alert.key()]
+	return existing.RaisedAt, ok
+}
+
+// Active returns the currently active alerts, most recently raised first.
+func (reg *AlertRegistry) Active() []Alert {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	alerts := make([]Alert, 0, len(reg.active))
+	for _, alert := range reg.active {
+		alerts = append(alerts, alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].RaisedAt.Equal(alerts[j].RaisedAt) {
+			return alerts[i].RaisedAt.After(alerts[j].RaisedAt)
+		}
+		return alerts[i].key() < alerts[j].key()
+	})
+
+	return alerts
+}
+
+// AlertHistoryFilters holds the filters accepted by the alert history endpoint. A zero
+// CowID or empty Type matches every alert, and a zero From or To leaves that end of the
+// range of raised_at times open.
+type AlertHistoryFilters struct {
+	CowID int
+	Type  string
+	From  time.Time
+	To    time.Time
+}
+
+// History returns the resolved alerts which match the filters, most recently resolved
+// first.
+func (reg *AlertRegistry) History(filters AlertHistoryFilters) []Alert {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	items := reg.resolved.Items()
+	timeRange := HistoryFilters{From: filters.From, To: filters.To}
+
+	alerts := []Alert{}
+	for i := len(items) - 1; i >= 0; i-- {
+		alert := items[i]
+		if filters.CowID != 0 && (alert.Source != alertSourceCow || alert.CowID != filters.CowID) {
+			continue
+		}
+		if filters.Type != "" && alert.Type != filters.Type {
+			continue
+		}
+		if !timeRange.Contains(alert.RaisedAt) {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+
+	return alerts
+}
+
+// PruneHistory removes the alerts resolved before the given time from the history, and
+// returns how many were removed.
+func (reg *AlertRegistry) PruneHistory(before time.Time) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	return reg.resolved.DropOldest(func(alert Alert) bool {
+		return alert.ResolvedAt.Before(before)
+	})
+}
+
+// Clone returns a copy of the registry, including its history, which can be changed
+// without affecting the original.
+func (reg *AlertRegistry) Clone() *AlertRegistry {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	return &AlertRegistry{
+		active:   maps.Clone(reg.active),
+		resolved: reg.resolved.Clone(),
+	}
+}
+
+// Restore replaces the active alerts with the given ones, e.g. from a farm snapshot. The
+// health monitor reconciles them with the farm on its next pass, so alerts which are still
+// active aren't raised and notified again. The history belongs to the farm being replaced,
+// so it's cleared.
+func (reg *AlertRegistry) Restore(alerts []Alert) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.active = make(map[string]Alert, len(alerts))
+	for _, alert := range alerts {
+		alert.ResolvedAt = nil
+		reg.active[alert.key()] = alert
+	}
+	reg.resolved = ringbuffer.New[Alert](reg.resolved.Cap())
+}
+
+// listAlertsHandler returns the alerts currently active across the farm, optionally
+// scoped to the cows in a herd
+func (app *application) listAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	herdID := app.readInt(r.URL.Query(), "herd_id", 0, v)
+	v.Check(herdID >= 0, "herd_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	alerts := app.farm(r).alerts.Active()
+	if herdID > 0 {
+		scoped := []Alert{}
+		for _, alert := range alerts {
+			if alert.HerdID != nil && *alert.HerdID == herdID {
+				scoped = append(scoped, alert)
+			}
+		}
+		alerts = scoped
+	}
+
+	env := envelope{
+		"alerts": alerts,
+		"total":  len(alerts),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "alerts", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAlertHistoryHandler returns the alerts which have been resolved, most recently
+// resolved first, optionally filtered by cow, type and the time they were raised. Alerts
+// are kept until they're overwritten by newer ones or pruned with the sensor history.
+func (app *application) listAlertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filters := AlertHistoryFilters{
+		CowID: app.readInt(qs, "cow_id", 0, v),
+		Type:  app.readString(qs, "type", ""),
+		From:  app.readTime(qs, "from", v),
+		To:    app.readTime(qs, "to", v),
+	}
+	v.Check(filters.CowID >= 0, "cow_id", "must be a positive integer")
+	if filters.Type != "" {
+		v.Check(validator.PermittedValue(filters.Type, alertTypes...), "type", "must be one of "+strings.Join(alertTypes, ", "))
+	}
+	ValidateHistoryFilters(v, HistoryFilters{From: filters.From, To: filters.To})
+	pagination := app.readPagination(qs, v)
+	ValidatePagination(v, pagination, app.config.maxPageSize)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	alerts := app.farm(r).alerts.History(filters)
+
+	env := envelope{
+		"alerts":   paginate(alerts, pagination),
+		"metadata": calculateMetadata(len(alerts), pagination).withLinks(app.requestURL(r)),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "alerts", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// AlertingCow is a cow which is currently breaching at least one alert threshold, along
+// with the alerts which apply to it.
+type AlertingCow struct {
+	Cow
+	Alerts []Alert `json:"alerts"`
+}
+
+// listAlertingCowsHandler returns only the cows which are currently alerting, each with
+// its active alerts, for the dashboard's "attention needed" panel. The alert rules are
+// evaluated against the latest readings, so a cow appears as soon as it breaches a
+// threshold rather than at the health monitor's next tick. Like the health monitor, it
+// keeps active alerts whose readings are still within the hysteresis margin, so a cow
+// doesn't drop out of this list while it's still in listAlertsHandler's.
+func (app *application) listAlertingCowsHandler(w http.ResponseWriter, r *http.Request) {
+	now := app.clock.Now()
+	farm := app.farm(r)
+	all := farm.store.Cows()
+
+	var detected []Alert
+	for _, cow := range all {
+		detected = append(detected, app.detectAlerts(farm, cow, now)...)
+	}
+	held := app.heldAlerts(farm.alerts.Active(), detected, all, farm.store.Drone())
+
+	byCow := make(map[int][]Alert)
+	for _, alert := range append(detected, held...) {
+		if alert.Source != alertSourceCow {
+			continue
+		}
+
+		// Report alerts the health monitor has already raised with their original time,
+		// so they match those returned by listAlertsHandler.
+		if raisedAt, ok := farm.alerts.RaisedAt(alert); ok {
+			alert.RaisedAt = raisedAt
+		}
+		byCow[alert.CowID] = append(byCow[alert.CowID], alert)
+	}
+
+	cows := []AlertingCow{}
+	for _, cow := range all {
+		if alerts := byCow[cow.ID]; len(alerts) > 0 {
+			cows = append(cows, AlertingCow{Cow: cow, Alerts: alerts})
+		}
+	}
+
+	env := envelope{
+		"cows":  cows,
+		"total": len(cows),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "cows", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
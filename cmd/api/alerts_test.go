@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetectActivityAnomaly(t *testing.T) {
+	const maxResting = 2 * time.Hour
+	now := testEpoch
+
+	// reading returns a reading taken ago before now.
+	reading := func(activity string, heartRate int, ago time.Duration) CowSensorReading {
+		return CowSensorReading{
+			CowID:      1,
+			Sensors:    CowSensors{Temperature: 38.6, HeartRate: heartRate, Activity: activity, BatteryLevel: 80},
+			RecordedAt: now.Add(-ago),
+		}
+	}
+
+	tests := []struct {
+		name        string
+		history     []CowSensorReading
+		wantAlert   bool
+		wantMinutes float64
+	}{
+		{
+			name:    "no history",
+			history: nil,
+		},
+		{
+			name:    "grazing",
+			history: []CowSensorReading{reading("grazing", 95, 3*time.Hour)},
+		},
+		{
+			name:    "resting with a normal heart rate",
+			history: []CowSensorReading{reading("resting", maxNormalHeartRate, 3*time.Hour)},
+		},
+		{
+			name:    "resting with an elevated heart rate, within the limit",
+			history: []CowSensorReading{reading("resting", 95, time.Hour)},
+		},
+		{
+			name:    "resting with an elevated heart rate, exactly at the limit",
+			history: []CowSensorReading{reading("resting", 95, maxResting)},
+		},
+		{
+			name:        "resting with an elevated heart rate, past the limit",
+			history:     []CowSensorReading{reading("resting", 95, 3*time.Hour)},
+			wantAlert:   true,
+			wantMinutes: 180,
+		},
+		{
+			name: "resting period measured from its first reading",
+			history: []CowSensorReading{
+				reading("grazing", 70, 5*time.Hour),
+				reading("resting", 70, 4*time.Hour),
+				reading("resting", 75, 2*time.Hour),
+				reading("resting", 95, time.Minute),
+			},
+			wantAlert:   true,
+			wantMinutes: 240,
+		},
+		{
+			name: "resting period broken by activity",
+			history: []CowSensorReading{
+				reading("resting", 70, 5*time.Hour),
+				reading("walking", 85, 90*time.Minute),
+				reading("resting", 95, time.Hour),
+			},
+		},
+		{
+			name: "no longer resting",
+			history: []CowSensorReading{
+				reading("resting", 95, 5*time.Hour),
+				reading("walking", 95, time.Minute),
+			},
+		},
+	}
+
+	cow := Cow{ID: 1, Name: "Bessie", Location: Location{Zone: "Pasture A"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert, ok := detectActivityAnomaly(cow, tt.history, maxResting, now)
+			if ok != tt.wantAlert {
+				t.Fatalf("got alert %t, want %t", ok, tt.wantAlert)
+			}
+			if !ok {
+				return
+			}
+
+			if alert.Type != "inactivity" || alert.CowID != cow.ID || alert.Zone != cow.Location.Zone {
+				t.Errorf("got a %s alert for cow %d in %q, want inactivity for cow %d in %q", alert.Type, alert.CowID, alert.Zone, cow.ID, cow.Location.Zone)
+			}
+			if alert.Value != tt.wantMinutes || alert.Threshold != maxResting.Minutes() {
+				t.Errorf("got value %g and threshold %g, want %g and %g", alert.Value, alert.Threshold, tt.wantMinutes, maxResting.Minutes())
+			}
+			if !alert.RaisedAt.Equal(now) {
+				t.Errorf("got raised at %s, want %s", alert.RaisedAt, now)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/ringbuffer"
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// AuditEntry records a single change made through the API. Before and After hold short
+// summaries of the target's state, rather than the whole record.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Farm       string    `json:"farm"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`      // create, update, delete, restore, command, import, reset
+	TargetType string    `json:"target_type"` // cow, drone, robodog, sensor_batch
+	TargetID   int       `json:"target_id,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Before     any       `json:"before,omitempty"`
+	After      any       `json:"after,omitempty"`
+}
+
+// AuditLog is an append-only log of the changes made through the API. It's held in
+// memory, so only the most recent entries are kept.
+type AuditLog struct {
+	mu      sync.RWMutex
+	entries *ringbuffer.Buffer[AuditEntry]
+	nextID  int64
+}
+
+// newAuditLog returns an empty AuditLog which keeps the given number of entries.
+func newAuditLog(size int) *AuditLog {
+	return &AuditLog{
+		entries: ringbuffer.New[AuditEntry](size),
+		nextID:  1,
+	}
+}
+
+// Append adds an entry to the log, assigning it the next ID.
+func (l *AuditLog) Append(entry AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.ID = l.nextID
+	l.nextID++
+	l.entries.Push(entry)
+}
+
+// Entries returns the farm's entries which match the actor and action, most recent first.
+// An empty actor or action matches every entry.
+func (l *AuditLog) Entries(farm, actor, action string) []AuditEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	items := l.entries.Items()
+
+	entries := []AuditEntry{}
+	for i := len(items) - 1; i >= 0; i-- {
+		if items[i].Farm != farm {
+			continue
+		}
+		if actor != "" && items[i].Actor != actor {
+			continue
+		}
+		if action != "" && items[i].Action != action {
+			continue
+		}
+		entries = append(entries, items[i])
+	}
+
+	return entries
+}
+
+// audit records a change made by the request in the audit log. Dry runs don't change
+// anything, so they aren't recorded.
+func (app *application) audit(r *http.Request, action, targetType string, targetID int, before, after any) {
+	if contextIsDryRun(r) {
+		return
+	}
+
+	app.auditLog.Append(AuditEntry{
+		Farm:       app.farm(r).ID,
+		Actor:      contextGetActor(r),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  contextGetRequestID(r),
+		Timestamp:  app.clock.Now(),
+		Before:     before,
+		After:      after,
+	})
+}
+
+// cowAuditSummary summarises a cow's state for an audit entry.
+func cowAuditSummary(cow Cow) map[string]any {
+	return map[string]any{
+		"name":             cow.Name,
+		"tag":              cow.Tag,
+		"zone":             cow.Location.Zone,
+		"health_status":    cow.Health.Status,
+		"lifecycle_status": cow.LifecycleStatus,
+		"battery_level":    cow.Sensors.BatteryLevel,
+	}
+}
+
+// deviceAuditSummary summarises a device's state for an audit entry.
+func deviceAuditSummary(status string, targetCowID *int) map[string]any {
+	summary := map[string]any{"status": status}
+	if targetCowID != nil {
+		summary["target_cow_id"] = *targetCowID
+	}
+	return summary
+}
+
+// listAuditEntriesHandler returns a page of the farm's audit log, most recent first,
+// optionally filtered by actor and action
+func (app *application) listAuditEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	actor := app.readString(qs, "actor", "")
+	action := app.readString(qs, "action", "")
+
+	v := validator.New()
+	pagination := app.readPagination(qs, v)
+	ValidatePagination(v, pagination, app.config.maxPageSize)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries := app.auditLog.Entries(app.farm(r).ID, actor, action)
+
+	env := envelope{
+		"entries":  paginate(entries, pagination),
+		"metadata": calculateMetadata(len(entries), pagination).withLinks(app.requestURL(r)),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "entries", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// startupBanner returns the properties of the single log entry written as the server
+// starts, so that which features are on in a deployment can be read from the first few
+// lines of its logs. The value of every flag follows as config.<flag>. It never includes
+// secrets: features which need them are only reported as enabled or not, and secret
+// flags are redacted.
+func (app *application) startupBanner(serverURL string, useTLS bool) map[string]string {
+	cfg := app.config
+
+	var features []string
+	feature := func(name string, enabled bool) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	feature("tls", useTLS)
+	feature("cors", len(cfg.corsTrustedOrigins) > 0 || cfg.env == "development")
+	feature("admin", cfg.adminToken != "")
+	feature("mqtt", cfg.mqttBroker != "")
+	feature("simulation", cfg.simulate && cfg.env == "development")
+	feature("alert_webhooks", len(cfg.alertWebhookURLs) > 0)
+	feature("slack", cfg.slackWebhookURL != "")
+	feature("email", cfg.smtpHost != "")
+	feature("debug_bodies", cfg.debugBodies)
+
+	logOutput := "stdout"
+	if cfg.logFile != "" {
+		logOutput = cfg.logOutput + ":" + cfg.logFile
+	}
+
+	banner := map[string]string{
+		"version":         version,
+		"environment":     cfg.env,
+		"port":            fmt.Sprintf("%d", cfg.port),
+		"address":         fmt.Sprintf("0.0.0.0:%d", cfg.port),
+		"url":             serverURL,
+		"healthcheck_url": serverURL + "/api/healthcheck",
+		"metrics_url":     serverURL + "/api/metrics",
+		"features":        strings.Join(features, ","),
+		"farms":           strings.Join(cfg.farms, ","),
+		"cors_origins":    app.corsPolicy(),
+		"log_level":       log.MinLevel().String(),
+		"log_output":      logOutput,
+		"log_sample_rate": fmt.Sprintf("%d", cfg.logSampleRate),
+	}
+	for name, value := range effectiveConfig() {
+		banner["config."+name] = value
+	}
+
+	return banner
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// BatteryStatus represents the battery level of a single cow collar or device
+type BatteryStatus struct {
+	Type         string `json:"type"` // cow, robodog, drone
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	BatteryLevel int    `json:"battery_level"` // percentage
+}
+
+// BatteryLevels returns the battery level of every cow collar and device on the farm.
+func (s *FarmStore) BatteryLevels() []BatteryStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	levels := make([]BatteryStatus, 0, len(s.cows)+2)
+	for _, cow := range s.cows {
+		if cow.Deleted() {
+			continue
+		}
+		levels = append(levels, BatteryStatus{Type: "cow", ID: cow.ID, Name: cow.Name, BatteryLevel: cow.Sensors.BatteryLevel})
+	}
+	levels = append(levels, BatteryStatus{Type: "robodog", ID: s.roboDog.ID, Name: s.roboDog.Name, BatteryLevel: s.roboDog.BatteryLevel})
+	levels = append(levels, BatteryStatus{Type: "drone", ID: s.drone.ID, Name: s.drone.Name, BatteryLevel: s.drone.BatteryLevel})
+
+	return levels
+}
+
+// warnOnLowBattery logs a warning when a sensor update takes a device's battery from at or
+// above the warning threshold to below it. Devices that are already low aren't re-reported
+// on every update.
+func (app *application) warnOnLowBattery(deviceType string, id, before, after int) {
+	threshold := app.config.batteryWarningThreshold
+	if before < threshold || after >= threshold {
+		return
+	}
+
+	log.WarnWithProperties("Battery level dropped below warning threshold", map[string]string{
+		"type":          deviceType,
+		"id":            fmt.Sprintf("%d", id),
+		"battery_level": fmt.Sprintf("%d", after),
+		"threshold":     fmt.Sprintf("%d", threshold),
+	})
+}
+
+// listLowBatteryHandler returns every cow collar and device whose battery is below the
+// warning threshold, lowest first
+func (app *application) listLowBatteryHandler(w http.ResponseWriter, r *http.Request) {
+	low := []BatteryStatus{}
+	for _, status := range app.storeFor(r).BatteryLevels() {
+		if status.BatteryLevel < app.config.batteryWarningThreshold {
+			low = append(low, status)
+		}
+	}
+
+	sort.SliceStable(low, func(i, j int) bool {
+		return low[i].BatteryLevel < low[j].BatteryLevel
+	})
+
+	env := envelope{
+		"devices":   low,
+		"threshold": app.config.batteryWarningThreshold,
+		"total":     len(low),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "devices", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
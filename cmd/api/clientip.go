@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIP middleware resolves the address of the client behind any trusted proxies and
+// stores it in the request context, so that everything downstream agrees on who made the
+// request. The X-Forwarded-For and X-Real-IP headers are only believed when the request
+// comes from one of the -trusted-proxies ranges, since anyone else could forge them.
+func (app *application) clientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = contextSetClientIP(r, resolveClientIP(r, app.trustedProxies))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// resolveClientIP returns the client's IP address. When the immediate peer is a trusted
+// proxy, X-Forwarded-For is read from the right, skipping further trusted proxies, so the
+// result is the first address which a trusted proxy vouched for. X-Real-IP is used if
+// there's no X-Forwarded-For, and RemoteAddr in every other case.
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) string {
+	remote, ok := parseIP(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !isTrustedProxy(remote, trusted) {
+		return remote.String()
+	}
+
+	if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, ok := parseIP(strings.TrimSpace(hops[i]))
+			if !ok {
+				break
+			}
+			client = hop
+			if !isTrustedProxy(hop, trusted) {
+				break
+			}
+		}
+		return client.String()
+	}
+
+	if realIP, ok := parseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ok {
+		return realIP.String()
+	}
+
+	return remote.String()
+}
+
+// parseIP parses an IP address with or without a port, as found in RemoteAddr and the
+// forwarding headers. IPv4-mapped IPv6 addresses are converted to plain IPv4.
+func parseIP(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+// isTrustedProxy reports whether addr is within one of the trusted proxy ranges.
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the -trusted-proxies CIDR ranges. A bare IP address is
+// accepted as a range containing just that address.
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
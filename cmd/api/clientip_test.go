@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{
+			name:       "direct",
+			remoteAddr: "203.0.113.7:51000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:         "forged headers from an untrusted peer",
+			remoteAddr:   "203.0.113.7:51000",
+			forwardedFor: []string{"1.2.3.4"},
+			realIP:       "5.6.7.8",
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "behind a trusted proxy",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"198.51.100.20"},
+			want:         "198.51.100.20",
+		},
+		{
+			name:         "client's own forged entry is skipped",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"1.2.3.4, 198.51.100.20"},
+			want:         "198.51.100.20",
+		},
+		{
+			name:         "chain of trusted proxies",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"198.51.100.20, 192.168.1.1, 10.1.2.3"},
+			want:         "198.51.100.20",
+		},
+		{
+			name:         "header split over several lines",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"198.51.100.20", "10.1.2.3"},
+			want:         "198.51.100.20",
+		},
+		{
+			name:         "every hop trusted",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"10.0.0.9, 10.0.0.8"},
+			want:         "10.0.0.9",
+		},
+		{
+			name:         "garbage stops the walk at the last good hop",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"198.51.100.20, not-an-ip, 10.1.2.3"},
+			want:         "10.1.2.3",
+		},
+		{
+			name:         "hop with a port",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"198.51.100.20:443"},
+			want:         "198.51.100.20",
+		},
+		{
+			name:       "X-Real-IP from a trusted proxy",
+			remoteAddr: "10.0.0.5:51000",
+			realIP:     " 198.51.100.20 ",
+			want:       "198.51.100.20",
+		},
+		{
+			name:         "X-Forwarded-For wins over X-Real-IP",
+			remoteAddr:   "10.0.0.5:51000",
+			forwardedFor: []string{"198.51.100.20"},
+			realIP:       "198.51.100.99",
+			want:         "198.51.100.20",
+		},
+		{
+			name:       "unusable X-Real-IP",
+			remoteAddr: "10.0.0.5:51000",
+			realIP:     "unknown",
+			want:       "10.0.0.5",
+		},
+		{
+			name:         "IPv6 proxy",
+			remoteAddr:   "[fd00::1]:51000",
+			forwardedFor: []string{"2001:db8::7"},
+			want:         "2001:db8::7",
+		},
+		{
+			name:       "IPv4-mapped peer",
+			remoteAddr: "[::ffff:203.0.113.7]:51000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "unparseable RemoteAddr",
+			remoteAddr: "pipe",
+			want:       "pipe",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := resolveClientIP(r, trusted); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{value: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{value: "192.168.1.1", want: "192.168.1.1/32"},
+		{value: "::ffff:192.168.1.1", want: "192.168.1.1/32"},
+		{value: "fd00::/8", want: "fd00::/8"},
+		{value: "2001:db8::1", want: "2001:db8::1/128"},
+		{value: "not-a-range", wantErr: true},
+		{value: "10.0.0.0/33", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			prefixes, err := parseTrustedProxies([]string{tt.value})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", prefixes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := prefixes[0].String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
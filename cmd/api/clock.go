@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Handlers, background workers and the store take the time from a
+// Clock rather than calling time.Now() directly, so that time-dependent behaviour such as
+// staleness, history windows and alert timestamps can be tested with a MockClock instead
+// of sleeps. Durations which are measured rather than compared, such as request latency,
+// still use the real time.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock used when serving, which tells the real time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// MockClock is a Clock which only moves when it's told to. It's safe for concurrent use.
+type MockClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewMockClock returns a MockClock which is stopped at now.
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Set stops the clock at now.
+func (c *MockClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+// Advance moves the clock on by d.
+func (c *MockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
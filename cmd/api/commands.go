@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CommandSpec describes a command which can be sent to a device, so that a control UI can
+// offer it without hardcoding the rules: the request which sends it, the parameters the
+// request takes, and the conditions under which it's carried out.
+type CommandSpec struct {
+	Command       string             `json:"command"`
+	Method        string             `json:"method"`
+	Path          string             `json:"path"`
+	Parameters    []CommandParameter `json:"parameters"`
+	Preconditions []string           `json:"preconditions"`
+}
+
+// CommandParameter describes a field of a command's request body.
+type CommandParameter struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // string, integer, number, array
+	Required bool     `json:"required"`
+	Value    any      `json:"value,omitempty"` // the only value allowed, e.g. the command's name
+	Default  any      `json:"default,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Unit     string   `json:"unit,omitempty"`
+}
+
+// commandSpecs lists the commands each type of device accepts. It's built from the same
+// specs and limits the command handlers check requests against, so it always describes
+// what they'll accept.
+func (app *application) commandSpecs() map[string][]CommandSpec {
+	specs := map[string][]CommandSpec{
+		"robodog": {dispatchCommandSpec("robodog")},
+		"drone":   {},
+	}
+
+	for _, command := range droneCommands {
+		spec := CommandSpec{
+			Command: command.name,
+			Method:  http.MethodPost,
+			Path:    "/api/drone/commands",
+			Parameters: []CommandParameter{
+				{Name: "command", Type: "string", Required: true, Value: command.name},
+			},
+			Preconditions: []string{statusPrecondition("drone", command.statuses)},
+		}
+
+		if command.altitude != altitudeForbidden {
+			altitude := CommandParameter{
+				Name:     "altitude",
+				Type:     "number",
+				Required: command.altitude == altitudeRequired,
+				Min:      ptr(minDroneAltitude),
+				Max:      ptr(maxDroneAltitude),
+				Unit:     "meters",
+			}
+			// Only takeoff's altitude is optional, and it defaults to the takeoff altitude.
+			if command.altitude == altitudeOptional {
+				altitude.Default = defaultTakeoffAltitude
+			}
+			spec.Parameters = append(spec.Parameters, altitude)
+		}
+
+		if command.flight {
+			spec.Preconditions = append(spec.Preconditions, fmt.Sprintf("the drone's last-reported wind speed must be at most %g km/h", app.config.maxWindSpeed))
+		}
+
+		specs["drone"] = append(specs["drone"], spec)
+	}
+
+	specs["drone"] = append(specs["drone"], dispatchCommandSpec("drone"), CommandSpec{
+		Command: "set_route",
+		Method:  http.MethodPost,
+		Path:    "/api/drone/route",
+		Parameters: []CommandParameter{
+			{Name: "waypoints", Type: "array", Required: true},
+		},
+		Preconditions: []string{
+			fmt.Sprintf("each waypoint must be within %g km of the farm", app.config.geofenceRadiusKm),
+			fmt.Sprintf("each waypoint's altitude must be between %g and %g meters", minDroneAltitude, maxDroneAltitude),
+			fmt.Sprintf("the route must be within the drone's range of %g km on a full battery, reduced in proportion to its battery level", maxDroneRangeKm),
+		},
+	})
+
+	return specs
+}
+
+// dispatchCommandSpec describes sending a device of the given type to a cow.
+func dispatchCommandSpec(deviceType string) CommandSpec {
+	return CommandSpec{
+		Command: "dispatch",
+		Method:  http.MethodPost,
+		Path:    "/api/dispatch",
+		Parameters: []CommandParameter{
+			{Name: "device_type", Type: "string", Required: true, Value: deviceType},
+			{Name: "device_id", Type: "integer", Required: true, Min: ptr(1.0)},
+			{Name: "target_cow_id", Type: "integer", Required: true, Min: ptr(1.0)},
+		},
+		Preconditions: []string{
+			statusPrecondition(deviceType, availableStatuses[deviceType]),
+			"the target cow must exist",
+		},
+	}
+}
+
+// statusPrecondition describes the statuses a device must be in, e.g. "the drone's
+// status must be flying or en_route".
+func statusPrecondition(deviceType string, statuses []string) string {
+	list := statuses[len(statuses)-1]
+	if len(statuses) > 1 {
+		list = strings.Join(statuses[:len(statuses)-1], ", ") + " or " + list
+	}
+	return fmt.Sprintf("the %s's status must be %s", deviceType, list)
+}
+
+// ptr returns a pointer to a copy of v.
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// listCommandsHandler returns the commands each type of device accepts, with their
+// parameters and preconditions.
+func (app *application) listCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeEnvelope(w, r, http.StatusOK, "commands", envelope{"commands": app.commandSpecs()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// envFlags maps the environment variables we read onto the flags they configure.
+var envFlags = map[string]string{
+	"PORT": "port", // set by Railway
+	"ENV":  "env",
+}
+
+// secretFlags lists the flags whose values must never be logged.
+var secretFlags = map[string]bool{
+	"slack-webhook-url": true, // the URL embeds the webhook's token
+	"smtp-password":     true,
+	"admin-token":       true,
+}
+
+// fileExcludedFlags lists the flags which only make sense on the command line.
+var fileExcludedFlags = map[string]bool{
+	"config":  true,
+	"version": true,
+}
+
+// loadConfigSources applies values from the config file at path (if any) and from the
+// environment to every flag which wasn't set on the command line. The order of
+// precedence is: command-line flag > environment variable > config file > default.
+func loadConfigSources(path string) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		for name, value := range values {
+			if fileExcludedFlags[name] || flag.Lookup(name) == nil {
+				return fmt.Errorf("config file %s: unknown setting %q", path, name)
+			}
+			if explicit[name] {
+				continue
+			}
+
+			err := flag.Set(name, value)
+			if err != nil {
+				return fmt.Errorf("config file %s: invalid value for %q: %w", path, name, err)
+			}
+		}
+	}
+
+	for envName, name := range envFlags {
+		value := os.Getenv(envName)
+		if value == "" || explicit[name] {
+			continue
+		}
+
+		// An unparseable environment variable leaves the file or default value in
+		// place, but is logged so that the misconfiguration isn't silently masked. The
+		// numeric flag types overwrite their value even when parsing fails, so the
+		// previous value has to be put back explicitly.
+		previous := flag.Lookup(name).Value.String()
+		err := flag.Set(name, value)
+		if err != nil {
+			_ = flag.Set(name, previous)
+
+			log.WarnWithProperties("Ignoring unparseable environment variable", map[string]string{
+				"variable": envName,
+				"value":    value,
+				"flag":     name,
+				"using":    previous,
+			})
+		}
+	}
+
+	return nil
+}
+
+// openLogFile opens the file at path for appending log entries, creating it if needed.
+func openLogFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("log file %s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+// readConfigFile reads a flat YAML or JSON document whose keys are flag names, returning
+// the values as strings ready to be passed to flag.Set(). The format is chosen by the
+// file extension.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format (use .yaml, .yml or .json)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("config file %s: setting %q must be a single value", path, name)
+		}
+		values[name] = fmt.Sprint(value)
+	}
+
+	return values, nil
+}
+
+// validateConfig checks the merged configuration, returning an error describing every
+// invalid setting.
+func validateConfig(cfg *appConfig) error {
+	v := validator.New()
+
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check((cfg.tlsCert == "") == (cfg.tlsKey == ""), "tls-cert", "must be set together with -tls-key")
+	v.Check(cfg.readTimeout > 0, "read-timeout", "must be greater than zero")
+	v.Check(cfg.readHeaderTimeout > 0, "read-header-timeout", "must be greater than zero")
+	v.Check(cfg.readHeaderTimeout <= cfg.readTimeout, "read-header-timeout", "must not be greater than -read-timeout")
+	v.Check(cfg.writeTimeout > 0, "write-timeout", "must be greater than zero")
+	v.Check(cfg.idleTimeout > 0, "idle-timeout", "must be greater than zero")
+	_, err := parseTrustedProxies(cfg.trustedProxies)
+	v.Check(err == nil, "trusted-proxies", "must be a list of CIDR ranges or IP addresses, e.g. 10.0.0.0/8")
+	for _, origin := range cfg.corsTrustedOrigins {
+		v.Check(isOrigin(origin), "cors-trusted-origins", "must be a list of origins, e.g. https://dashboard.mooveit.com")
+	}
+	v.Check(len(cfg.farms) > 0, "farms", "must contain at least one farm ID")
+	v.Check(validator.Unique(cfg.farms), "farms", "must not contain duplicate farm IDs")
+	for _, id := range cfg.farms {
+		v.Check(validator.Matches(id, farmIDRX), "farms", "must be a list of IDs made of lower-case letters, digits and hyphens")
+	}
+	v.Check(validator.PermittedValue(cfg.envelopeStyle, "descriptive", "data"), "envelope", "must be descriptive or data")
+	v.Check(validator.PermittedValue(cfg.jsonCase, jsonCaseSnake, jsonCaseCamel), "json-case", "must be snake or camel")
+	v.Check(cfg.adminToken == "" || len(cfg.adminToken) >= 16, "admin-token", "must be at least 16 characters long")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+	v.Check(cfg.maxCowBatch > 0, "max-cow-batch", "must be greater than zero")
+	v.Check(cfg.maxCows >= 0, "max-cows", "must not be negative")
+	v.Check(cfg.maxImportBytes > 0, "max-import-bytes", "must be greater than zero")
+	v.Check(cfg.maxBodyBytes > 0, "max-body-bytes", "must be greater than zero")
+	v.Check(cfg.defaultPageSize > 0, "default-page-size", "must be greater than zero")
+	v.Check(cfg.defaultPageSize <= cfg.maxPageSize, "default-page-size", "must not be greater than -max-page-size")
+	v.Check(len(cfg.uploadAllowedTypes) > 0, "upload-allowed-types", "must contain at least one MIME type")
+	for _, mediaType := range cfg.uploadAllowedTypes {
+		v.Check(strings.Count(mediaType, "/") == 1 && !strings.ContainsAny(mediaType, "; "), "upload-allowed-types", "must be a list of MIME types without parameters, e.g. image/png")
+	}
+	v.Check(cfg.auditLogSize > 0, "audit-log-size", "must be greater than zero")
+	v.Check(cfg.idempotencyTTL > 0, "idempotency-ttl", "must be greater than zero")
+	v.Check(cfg.maxSensorBatch > 0, "max-sensor-batch", "must be greater than zero")
+	v.Check(cfg.sensorHistorySize > 0, "sensor-history-size", "must be greater than zero")
+	v.Check(cfg.alertHistorySize > 0, "alert-history-size", "must be greater than zero")
+	// The inactivity alert looks back through the history for the start of a cow's rest, so
+	// the history must reach back further than the longest rest allowed.
+	v.Check(cfg.historyRetention == 0 || cfg.historyRetention > cfg.restingAnomalyDuration, "history-retention", "must be zero, or greater than -resting-anomaly-duration")
+	v.Check(cfg.smoothingAlpha > 0 && cfg.smoothingAlpha <= 1, "smoothing-alpha", "must be greater than 0 and at most 1")
+	v.Check(cfg.staleAfter > 0, "stale-after", "must be greater than zero")
+	v.Check(cfg.batteryWarningThreshold >= 0 && cfg.batteryWarningThreshold <= 100, "battery-warning-threshold", "must be between 0 and 100")
+	v.Check(cfg.geofenceRadiusKm > 0, "geofence-radius-km", "must be greater than zero")
+	v.Check(cfg.maxWindSpeed > 0, "max-wind-speed", "must be greater than zero")
+	v.Check(cfg.healthCheckInterval > 0, "health-check-interval", "must be greater than zero")
+	v.Check(cfg.restingAnomalyDuration > 0, "resting-anomaly-duration", "must be greater than zero")
+	v.Check(cfg.aqiWarningThreshold > 0, "aqi-warning-threshold", "must be greater than zero")
+	v.Check(cfg.aqiCriticalThreshold > cfg.aqiWarningThreshold, "aqi-critical-threshold", "must be greater than -aqi-warning-threshold")
+	v.Check(cfg.temperatureHysteresis >= 0, "temperature-hysteresis", "must not be negative")
+	v.Check(cfg.heartRateHysteresis >= 0, "heart-rate-hysteresis", "must not be negative")
+	v.Check(cfg.aqiHysteresis >= 0 && cfg.aqiHysteresis < cfg.aqiWarningThreshold, "aqi-hysteresis", "must not be negative, and must be less than -aqi-warning-threshold")
+	v.Check(cfg.simulateInterval > 0, "simulate-interval", "must be greater than zero")
+	v.Check(cfg.ingestQueueSize > 0, "ingest-queue-size", "must be greater than zero")
+	v.Check(cfg.ingestWorkers > 0, "ingest-workers", "must be greater than zero")
+	v.Check(validator.PermittedValue(cfg.logOutput, "file", "both"), "log-output", "must be file or both")
+	v.Check(cfg.logSampleRate > 0, "log-sample-rate", "must be greater than zero")
+	v.Check(cfg.slowRequestMs >= 0, "slow-request-ms", "must not be negative")
+	v.Check(!cfg.debugBodies || cfg.env == "development", "debug-bodies", "must only be set in development")
+
+	v.Check(cfg.webhookTimeout > 0, "webhook-timeout", "must be greater than zero")
+	v.Check(cfg.webhookRetries >= 0, "webhook-retries", "must not be negative")
+	for _, webhookURL := range cfg.alertWebhookURLs {
+		v.Check(isHTTPURL(webhookURL), "alert-webhook-url", "must be a list of absolute http or https URLs")
+	}
+	if cfg.slackWebhookURL != "" {
+		v.Check(isHTTPURL(cfg.slackWebhookURL), "slack-webhook-url", "must be an absolute http or https URL")
+	}
+	v.Check(cfg.notificationCooldown >= 0, "notification-cooldown", "must not be negative")
+
+	// Email notifications are optional, but if an SMTP host is given the rest of the SMTP
+	// settings must be usable.
+	if cfg.smtpHost != "" {
+		v.Check(cfg.smtpPort > 0 && cfg.smtpPort <= 65535, "smtp-port", "must be between 1 and 65535")
+		_, err := mail.ParseAddress(cfg.smtpSender)
+		v.Check(err == nil, "smtp-sender", "must be a valid email address")
+		v.Check(len(cfg.smtpRecipients) > 0, "smtp-recipients", "must be provided when -smtp-host is set")
+		for _, recipient := range cfg.smtpRecipients {
+			v.Check(validator.Matches(recipient, validator.EmailRX), "smtp-recipients", "must be a list of valid email addresses")
+		}
+	}
+
+	if cfg.mqttBroker != "" {
+		v.Check(strings.Count(cfg.mqttCowTopic, "+") == 1, "mqtt-cow-topic", "must contain exactly one + wildcard")
+		v.Check(strings.Count(cfg.mqttDroneTopic, "+") == 1, "mqtt-drone-topic", "must contain exactly one + wildcard")
+		for name, topic := range map[string]string{"mqtt-cow-topic": cfg.mqttCowTopic, "mqtt-drone-topic": cfg.mqttDroneTopic} {
+			placeholders := strings.Count(topic, mqttFarmPlaceholder)
+			v.Check(placeholders <= 1 && topicSegmentCount(topic, mqttFarmPlaceholder) == placeholders, name, "must contain "+mqttFarmPlaceholder+" at most once, as a whole topic level")
+			// Without the farm in the topic, there's no telling which farm a reading is for.
+			if len(cfg.farms) > 1 {
+				v.Check(strings.Contains(topic, mqttFarmPlaceholder), name, "must contain a "+mqttFarmPlaceholder+" topic level when more than one farm is configured")
+			}
+		}
+	}
+
+	if v.Valid() {
+		return nil
+	}
+
+	problems := make([]string, 0, len(v.Errors))
+	for name, message := range v.Errors {
+		problems = append(problems, fmt.Sprintf("-%s %s", name, message))
+	}
+	sort.Strings(problems)
+
+	return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+}
+
+// effectiveConfig returns the value of every flag, for logging at startup. Secret
+// values are redacted.
+func effectiveConfig() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if fileExcludedFlags[f.Name] {
+			return
+		}
+
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "[REDACTED]"
+		}
+		values[f.Name] = value
+	})
+
+	return values
+}
+
+// splitList splits a comma-separated flag value into its trimmed, non-empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isHTTPURL reports whether value is an absolute http or https URL.
+func isHTTPURL(value string) bool {
+	u, err := url.ParseRequestURI(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Define a custom contextKey type, with the underlying type string, so our keys can't
+// collide with keys set by other packages.
+type contextKey string
+
+const (
+	requestIDContextKey = contextKey("requestID")
+	actorContextKey     = contextKey("actor")
+	clientIPContextKey  = contextKey("clientIP")
+	farmContextKey      = contextKey("farm")
+	dryRunContextKey    = contextKey("dryRun")
+	routeContextKey     = contextKey("route")
+)
+
+// anonymousActor is the actor recorded for requests without an authenticated principal.
+const anonymousActor = "anonymous"
+
+// contextSetRequestID returns a new copy of the request with the request ID added to
+// the context.
+func contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID retrieves the request ID from the request context, or returns an
+// empty string if the request-ID middleware hasn't run.
+func contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// contextSetActor returns a new copy of the request with the name of the authenticated
+// principal added to the context.
+func contextSetActor(r *http.Request, actor string) *http.Request {
+	ctx := context.WithValue(r.Context(), actorContextKey, actor)
+	return r.WithContext(ctx)
+}
+
+// contextGetActor retrieves the name of the authenticated principal from the request
+// context, or returns anonymousActor if there isn't one.
+func contextGetActor(r *http.Request) string {
+	actor, ok := r.Context().Value(actorContextKey).(string)
+	if !ok || actor == "" {
+		return anonymousActor
+	}
+	return actor
+}
+
+// contextSetClientIP returns a new copy of the request with the resolved client IP address
+// added to the context.
+func contextSetClientIP(r *http.Request, clientIP string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, clientIP)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIP retrieves the client IP address from the request context, falling
+// back to RemoteAddr if the client IP middleware hasn't run.
+func contextGetClientIP(r *http.Request) string {
+	clientIP, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		return r.RemoteAddr
+	}
+	return clientIP
+}
+
+// contextSetFarm returns a new copy of the request with the farm it's for added to the
+// context.
+func contextSetFarm(r *http.Request, farm *Farm) *http.Request {
+	ctx := context.WithValue(r.Context(), farmContextKey, farm)
+	return r.WithContext(ctx)
+}
+
+// contextGetFarm retrieves the farm the request is for from the request context, or
+// returns nil if the farm middleware hasn't run.
+func contextGetFarm(r *http.Request) *Farm {
+	farm, _ := r.Context().Value(farmContextKey).(*Farm)
+	return farm
+}
+
+// contextSetDryRun returns a new copy of the request marked as a dry run in the context.
+func contextSetDryRun(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), dryRunContextKey, true)
+	return r.WithContext(ctx)
+}
+
+// contextIsDryRun reports whether the request is a dry run, which mustn't have any lasting
+// effects.
+func contextIsDryRun(r *http.Request) bool {
+	dryRun, _ := r.Context().Value(dryRunContextKey).(bool)
+	return dryRun
+}
+
+// contextSetRoute returns a new copy of the request with the pattern of the route which
+// serves it added to the context.
+func contextSetRoute(r *http.Request, route string) *http.Request {
+	ctx := context.WithValue(r.Context(), routeContextKey, route)
+	return r.WithContext(ctx)
+}
+
+// contextGetRoute retrieves the pattern of the route which serves the request from the
+// request context, or returns an empty string if the metrics middleware hasn't run.
+func contextGetRoute(r *http.Request) string {
+	route, _ := r.Context().Value(routeContextKey).(string)
+	return route
+}
+
+// logContextProperties returns the request-scoped properties stored in ctx by the
+// middleware, for the jsonlog Ctx helpers. It's registered with the logger in main().
+func logContextProperties(ctx context.Context) map[string]string {
+	properties := make(map[string]string)
+	if requestID, ok := ctx.Value(requestIDContextKey).(string); ok {
+		properties["request_id"] = requestID
+	}
+	if clientIP, ok := ctx.Value(clientIPContextKey).(string); ok {
+		properties["client_ip"] = clientIP
+	}
+	if route, ok := ctx.Value(routeContextKey).(string); ok {
+		properties["route"] = route
+	}
+	if actor, ok := ctx.Value(actorContextKey).(string); ok && actor != "" {
+		properties["actor"] = actor
+	}
+	if farm, ok := ctx.Value(farmContextKey).(*Farm); ok {
+		properties["farm"] = farm.ID
+	}
+	if dryRun, ok := ctx.Value(dryRunContextKey).(bool); ok && dryRun {
+		properties["dry_run"] = "true"
+	}
+	return properties
+}
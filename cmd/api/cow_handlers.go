@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// TagRX matches the ear tags printed on collars, such as COW-001.
+var TagRX = regexp.MustCompile(`^[A-Z0-9]+(-[A-Z0-9]+)*$`)
+
+// cowInput is the payload accepted when creating a cow. Health is derived from the
+// sensor readings rather than supplied by the client.
+type cowInput struct {
+	Name     string     `json:"name"`
+	Tag      string     `json:"tag"`
+	Location Location   `json:"location"`
+	Sensors  CowSensors `json:"sensors"`
+}
+
+// toCow converts the payload into a new cow, deriving its health from the sensors.
+func (input cowInput) toCow(now time.Time) Cow {
+	cow := Cow{
+		Name:            input.Name,
+		Tag:             input.Tag,
+		Location:        input.Location,
+		LifecycleStatus: lifecycleActive,
+	}
+	cow.applySensors(input.Sensors, now)
+
+	return cow
+}
+
+// ValidateCow checks the identifying, lifecycle, location, health and sensor fields of a
+// cow.
+func ValidateCow(v *validator.Validator, cow Cow) {
+	v.Check(cow.Name != "", "name", "must be provided")
+	v.Check(len(cow.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(cow.Tag != "", "tag", "must be provided")
+	v.Check(len(cow.Tag) <= 32, "tag", "must not be more than 32 bytes long")
+	v.Check(validator.Matches(cow.Tag, TagRX), "tag", "must contain only upper-case letters, digits and hyphens")
+
+	v.Check(validator.PermittedValue(cow.LifecycleStatus, lifecycleStatuses...), "lifecycle_status", "must be active, quarantined, sold or deceased")
+
+	location := v.Nested("location")
+	ValidateLocation(location, cow.Location)
+	location.Check(cow.Location.Zone != "", "zone", "must be provided")
+
+	ValidateHealth(v.Nested("health"), cow.Health)
+	ValidateCowSensors(v.Nested("sensors"), cow.Sensors)
+}
+
+// ValidateHealth checks a cow's health. The readings in it are copied from the sensors, so
+// only the status needs checking.
+func ValidateHealth(v *validator.Validator, health Health) {
+	v.Check(validator.PermittedValue(health.Status, "healthy", "sick", "injured"), "status", "must be healthy, sick or injured")
+}
+
+// DuplicateTagsError is returned when cows being inserted use tags which already belong
+// to cows in the store. Indexes holds the positions of the offending cows.
+type DuplicateTagsError struct {
+	Indexes []int
+}
+
+func (e *DuplicateTagsError) Error() string {
+	return fmt.Sprintf("%d cows have duplicate tags", len(e.Indexes))
+}
+
+// HerdLimitError is returned when adding cows would take the herd past -max-cows.
+type HerdLimitError struct {
+	Current int // cows in the herd, not counting deleted cows
+	Adding  int
+	Max     int
+}
+
+func (e *HerdLimitError) Error() string {
+	return fmt.Sprintf("the herd is limited to %d cows and already has %d, so %d more can't be added", e.Max, e.Current, e.Adding)
+}
+
+// checkHerdLimit returns a *HerdLimitError if adding more cows would take the herd past
+// maxCows. A maxCows of zero means there's no limit. The caller must hold the lock.
+func (s *FarmStore) checkHerdLimit(adding, maxCows int) error {
+	current := len(s.byTag) // one entry for each cow which hasn't been deleted
+	if maxCows > 0 && current+adding > maxCows {
+		return &HerdLimitError{Current: current, Adding: adding, Max: maxCows}
+	}
+	return nil
+}
+
+// InsertCows adds the cows to the store, assigning each a sequential ID. Either every cow
+// is inserted or none are: if any tag is already in use by a cow which hasn't been deleted
+// a *DuplicateTagsError is returned, and if the herd would grow past maxCows a
+// *HerdLimitError, and the store is left unchanged.
+func (s *FarmStore) InsertCows(cows []Cow, maxCows int) ([]Cow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var duplicates []int
+	for i, cow := range cows {
+		if s.tagIndex(cow.Tag) != -1 {
+			duplicates = append(duplicates, i)
+		}
+	}
+	if len(duplicates) > 0 {
+		return nil, &DuplicateTagsError{Indexes: duplicates}
+	}
+
+	err := s.checkHerdLimit(len(cows), maxCows)
+	if err != nil {
+		return nil, err
+	}
+
+	s.touch()
+
+	created := make([]Cow, len(cows))
+	for i, cow := range cows {
+		cow.ID = s.nextCowID()
+
+		s.appendCow(cow)
+		s.recordHistory(CowSensorReading{CowID: cow.ID, Sensors: cow.Sensors, RecordedAt: cow.LastUpdated})
+		created[i] = cow
+	}
+
+	return created, nil
+}
+
+// bulkCreateCowsHandler creates a batch of cows, for seeding a new pasture. The batch is
+// all-or-nothing: if any cow is invalid, none are created.
+func (app *application) bulkCreateCowsHandler(w http.ResponseWriter, r *http.Request) {
+	var input []cowInput
+
+	err := app.readJSONWithSchema(w, r, cowCreateSchema, &input)
+	if err != nil {
+		var schemaErr *SchemaError
+		switch {
+		case errors.As(err, &schemaErr):
+			app.failedValidationResponse(w, r, schemaErr.Fields)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input) > 0, "cows", "must contain at least one cow")
+	v.Check(len(input) <= app.config.maxCowBatch, "cows", fmt.Sprintf("must not contain more than %d cows", app.config.maxCowBatch))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	now := app.clock.Now()
+	cows := make([]Cow, len(input))
+	seenTags := make(map[string]int, len(input))
+
+	for i, item := range input {
+		cows[i] = item.toCow(now)
+
+		// Validate each cow with its errors keyed by its index in the batch.
+		cv := v.Nested(fmt.Sprintf("[%d]", i))
+		ValidateCow(cv, cows[i])
+
+		if first, ok := seenTags[item.Tag]; ok {
+			cv.AddError("tag", fmt.Sprintf("duplicates the tag of cow %d in this batch", first))
+		} else {
+			seenTags[item.Tag] = i
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.storeFor(r).InsertCows(cows, app.config.maxCows)
+	if err != nil {
+		var duplicateErr *DuplicateTagsError
+		var limitErr *HerdLimitError
+		switch {
+		case errors.As(err, &duplicateErr):
+			for _, i := range duplicateErr.Indexes {
+				v.AddError(fmt.Sprintf("[%d].tag", i), "a cow with this tag already exists")
+			}
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.As(err, &limitErr):
+			app.herdLimitResponse(w, r, limitErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, cow := range created {
+		app.audit(r, "create", "cow", cow.ID, nil, cowAuditSummary(cow))
+	}
+
+	env := envelope{
+		"cows":  created,
+		"total": len(created),
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusCreated, "cows", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
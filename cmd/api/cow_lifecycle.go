@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// DeleteCow soft-deletes the cow with the given ID by stamping it with deletedAt, and
+// returns the deleted cow. Deleted cows are kept, so that an animal which is sold and
+// later returns can be restored with its history. It returns ErrRecordNotFound if there's
+// no such cow or it has already been deleted.
+func (s *FarmStore) DeleteCow(id int, deletedAt time.Time) (Cow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.cowIndex(id)
+	if i == -1 || s.cows[i].Deleted() {
+		return Cow{}, ErrRecordNotFound
+	}
+
+	s.cows[i].DeletedAt = &deletedAt
+	delete(s.byTag, s.cows[i].Tag)
+	s.touchCow(i)
+	return s.cows[i], nil
+}
+
+// RestoreCow brings back a soft-deleted cow, returning the cow as it was before and after
+// being restored. Restoring a cow which isn't deleted is a no-op. It returns
+// ErrRecordNotFound if there's no such cow, and ErrDuplicateTag if its tag has since been
+// given to another cow.
+func (s *FarmStore) RestoreCow(id int) (Cow, Cow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.cowIndex(id)
+	if index == -1 {
+		return Cow{}, Cow{}, ErrRecordNotFound
+	}
+
+	before := s.cows[index]
+	if !before.Deleted() {
+		return before, before, nil
+	}
+
+	if s.tagIndex(before.Tag) != -1 {
+		return before, before, ErrDuplicateTag
+	}
+
+	s.cows[index].DeletedAt = nil
+	s.byTag[before.Tag] = index
+	s.touchCow(index)
+	return before, s.cows[index], nil
+}
+
+// deleteCowHandler soft-deletes a cow, e.g. when it's sold or culled. It's excluded from
+// listings from then on, unless they ask to include deleted cows.
+func (app *application) deleteCowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	before, err := app.storeFor(r).Cow(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	cow, err := app.storeFor(r).DeleteCow(int(id), app.clock.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "cow", cow.ID, cowAuditSummary(before), map[string]any{"deleted_at": cow.DeletedAt})
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "cow successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreCowHandler brings back a soft-deleted cow
+func (app *application) restoreCowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	before, cow, err := app.storeFor(r).RestoreCow(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		case errors.Is(err, ErrDuplicateTag):
+			app.conflictResponse(w, r, errCodeDuplicateTag, "another cow is now using this cow's tag")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if before.Deleted() {
+		app.audit(r, "restore", "cow", cow.ID, map[string]any{"deleted_at": before.DeletedAt}, cowAuditSummary(cow))
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "cow", envelope{"cow": cow}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The lifecycle statuses of a cow.
+const (
+	lifecycleActive      = "active"
+	lifecycleQuarantined = "quarantined"
+	lifecycleSold        = "sold"
+	lifecycleDeceased    = "deceased"
+)
+
+// lifecycleStatuses lists every lifecycle status, for validation.
+var lifecycleStatuses = []string{lifecycleActive, lifecycleQuarantined, lifecycleSold, lifecycleDeceased}
+
+// lifecycleTransitions lists the statuses a cow can move to from each status. A sold or
+// deceased cow has left the herd for good, so there's no way back from either.
+var lifecycleTransitions = map[string][]string{
+	lifecycleActive:      {lifecycleQuarantined, lifecycleSold, lifecycleDeceased},
+	lifecycleQuarantined: {lifecycleActive, lifecycleSold, lifecycleDeceased},
+	lifecycleSold:        {},
+	lifecycleDeceased:    {},
+}
+
+// LifecycleTransitionError is returned when a cow can't move from its lifecycle status to
+// the one requested.
+type LifecycleTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *LifecycleTransitionError) Error() string {
+	return fmt.Sprintf("a %s cow can't become %s", e.From, e.To)
+}
+
+// SetCowLifecycle moves the cow with the given ID to a new lifecycle status, returning the
+// cow as it was before and after. Setting the status it already has is a no-op. It returns
+// ErrRecordNotFound if there's no such cow or it has been deleted, and a
+// *LifecycleTransitionError if the cow can't move to the status.
+func (s *FarmStore) SetCowLifecycle(id int, status string) (Cow, Cow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.cowIndex(id)
+	if i == -1 || s.cows[i].Deleted() {
+		return Cow{}, Cow{}, ErrRecordNotFound
+	}
+
+	before := s.cows[i]
+	if before.LifecycleStatus == status {
+		return before, before, nil
+	}
+	if !slices.Contains(lifecycleTransitions[before.LifecycleStatus], status) {
+		return before, before, &LifecycleTransitionError{From: before.LifecycleStatus, To: status}
+	}
+
+	s.cows[i].LifecycleStatus = status
+	s.touchCow(i)
+	return before, s.withDerivedHealth(s.cows[i]), nil
+}
+
+// updateCowLifecycleHandler moves a cow through its lifecycle, e.g. into quarantine or to
+// sold, refusing transitions which make no sense, like selling a deceased cow.
+func (app *application) updateCowLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.Status, lifecycleStatuses...), "status", "must be active, quarantined, sold or deceased")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before, cow, err := app.storeFor(r).SetCowLifecycle(int(id), input.Status)
+	if err != nil {
+		var transitionErr *LifecycleTransitionError
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		case errors.As(err, &transitionErr):
+			app.conflictResponse(w, r, errCodeLifecycleTransition, transitionErr.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if before.LifecycleStatus != cow.LifecycleStatus {
+		app.audit(r, "update", "cow_lifecycle", cow.ID, map[string]any{"lifecycle_status": before.LifecycleStatus}, map[string]any{"lifecycle_status": cow.LifecycleStatus})
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "cow", envelope{"cow": cow}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// maxDebugBodyBytes is how much of each request body is logged by the debugBodies
+// middleware. Anything past it is still passed on to the handler, just not logged.
+const maxDebugBodyBytes = 4096
+
+// debugBodies middleware logs the bodies of mutating requests at INFO level, for debugging
+// device payloads. It's only installed in development with -debug-bodies set. Only
+// uncompressed textual bodies are logged, so nothing binary ends up in the logs.
+func (app *application) debugBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		properties := map[string]string{
+			"method":       r.Method,
+			"url":          r.URL.String(),
+			"content_type": r.Header.Get("Content-Type"),
+		}
+
+		if !isTextualBody(r) {
+			properties["body"] = "(not logged)"
+			log.InfoCtx(r.Context(), "Request body", properties)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Read no more than we're going to log, then put what was read back in front of
+		// the rest of the body, so the handler still sees all of it and its own size limit
+		// still applies.
+		prefix, err := io.ReadAll(io.LimitReader(r.Body, maxDebugBodyBytes+1))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+
+		if len(prefix) > maxDebugBodyBytes {
+			prefix = prefix[:maxDebugBodyBytes]
+			properties["truncated"] = "true"
+		}
+		properties["body"] = string(prefix)
+
+		log.InfoCtx(r.Context(), "Request body", properties)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readCloser pairs a reader with the Close method of the request body it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// isTextualBody reports whether the request body is uncompressed JSON, a form or text,
+// and so is safe to write to the logs.
+func isTextualBody(r *http.Request) bool {
+	encoding := strings.TrimSpace(r.Header.Get("Content-Encoding"))
+	if encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+
+	// Devices don't always bother to set a Content-Type on their JSON.
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/x-www-form-urlencoded"
+}
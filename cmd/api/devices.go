@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// Device is a common view of the farm's robots, regardless of their type
+type Device struct {
+	Type         string   `json:"type"` // robodog, drone
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Status       string   `json:"status"`
+	Location     Location `json:"location"`
+	BatteryLevel int      `json:"battery_level"` // percentage
+}
+
+// availableStatuses are the statuses, by device type, in which a device can be sent
+// somewhere.
+var availableStatuses = map[string][]string{
+	"robodog": {"idle", "active"},
+	"drone":   {"landed", "flying"},
+}
+
+// Available reports whether the device can be sent somewhere. A robo-dog is available
+// when it's idle or active, and a drone when it's landed or flying.
+func (d Device) Available() bool {
+	return slices.Contains(availableStatuses[d.Type], d.Status)
+}
+
+// Devices returns a common view of every robot on the farm.
+func (s *FarmStore) Devices() []Device {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return []Device{
+		{
+			Type:         "robodog",
+			ID:           s.roboDog.ID,
+			Name:         s.roboDog.Name,
+			Status:       s.roboDog.Status,
+			Location:     s.roboDog.Location,
+			BatteryLevel: s.roboDog.BatteryLevel,
+		},
+		{
+			Type:         "drone",
+			ID:           s.drone.ID,
+			Name:         s.drone.Name,
+			Status:       s.drone.Status,
+			Location:     s.drone.Location,
+			BatteryLevel: s.drone.BatteryLevel,
+		},
+	}
+}
+
+// DeviceHealth is an at-a-glance view of a cow collar or robot, for the ops UI's status
+// grid. Cows report their health status and robots their operating status.
+type DeviceHealth struct {
+	Type         string `json:"type"` // cow, robodog, drone
+	ID           int    `json:"id"`
+	BatteryLevel int    `json:"battery_level"` // percentage
+	Status       string `json:"status,omitempty"`
+	HealthStatus string `json:"health_status,omitempty"`
+	Stale        bool   `json:"stale"`
+}
+
+// DevicesHealth returns an at-a-glance view of every cow collar and robot on the farm,
+// optionally scoped to a zone. Deleted cows are left out.
+func (s *FarmStore) DevicesHealth(zone string, now time.Time) []DeviceHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := make([]DeviceHealth, 0, len(s.cows)+2)
+	for _, cow := range s.cows {
+		if cow.Deleted() || (zone != "" && cow.Location.Zone != zone) {
+			continue
+		}
+		stale, _ := s.freshness(cow.LastUpdated, now)
+		devices = append(devices, DeviceHealth{
+			Type:         "cow",
+			ID:           cow.ID,
+			BatteryLevel: cow.Sensors.BatteryLevel,
+			HealthStatus: cow.Health.Status,
+			Stale:        stale,
+		})
+	}
+	if zone == "" || s.roboDog.Location.Zone == zone {
+		stale, _ := s.freshness(s.roboDog.LastUpdated, now)
+		devices = append(devices, DeviceHealth{
+			Type:         "robodog",
+			ID:           s.roboDog.ID,
+			BatteryLevel: s.roboDog.BatteryLevel,
+			Status:       s.roboDog.Status,
+			Stale:        stale,
+		})
+	}
+	if zone == "" || s.drone.Location.Zone == zone {
+		stale, _ := s.freshness(s.drone.LastUpdated, now)
+		devices = append(devices, DeviceHealth{
+			Type:         "drone",
+			ID:           s.drone.ID,
+			BatteryLevel: s.drone.BatteryLevel,
+			Status:       s.drone.Status,
+			Stale:        stale,
+		})
+	}
+
+	return devices
+}
+
+// listDevicesHealthHandler returns a compact status for every cow collar and robot, as a
+// lightweight poll target for the ops UI's status grid. It can be filtered by zone.
+func (app *application) listDevicesHealthHandler(w http.ResponseWriter, r *http.Request) {
+	zone := app.readString(r.URL.Query(), "zone", "")
+
+	devices := app.storeFor(r).DevicesHealth(zone, app.clock.Now())
+
+	env := envelope{
+		"devices": devices,
+		"total":   len(devices),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "devices", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listDevicesHandler returns the farm's robo-dogs and drones in a common shape, for the
+// ops UI's device inventory. They can be filtered by type, status and zone, and sorted by
+// battery level with ?sort=battery_level, or ?sort=-battery_level for highest first.
+func (app *application) listDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	deviceType := app.readString(qs, "type", "")
+	status := app.readString(qs, "status", "")
+	zone := app.readString(qs, "zone", "")
+	sortBy := app.readString(qs, "sort", "")
+
+	v := validator.New()
+	v.Check(deviceType == "" || validator.PermittedValue(deviceType, "robodog", "drone"), "type", "must be robodog or drone")
+	v.Check(sortBy == "" || validator.PermittedValue(sortBy, "battery_level", "-battery_level"), "sort", "must be battery_level or -battery_level")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	devices := []Device{}
+	for _, device := range app.storeFor(r).Devices() {
+		if deviceType != "" && device.Type != deviceType {
+			continue
+		}
+		if status != "" && device.Status != status {
+			continue
+		}
+		if zone != "" && device.Location.Zone != zone {
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	switch sortBy {
+	case "battery_level":
+		sort.SliceStable(devices, func(i, j int) bool { return devices[i].BatteryLevel < devices[j].BatteryLevel })
+	case "-battery_level":
+		sort.SliceStable(devices, func(i, j int) bool { return devices[i].BatteryLevel > devices[j].BatteryLevel })
+	}
+
+	env := envelope{
+		"devices": devices,
+		"total":   len(devices),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "devices", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getNearestDeviceHandler returns the available device closest to a cow, so an operator
+// can dispatch it when an alert fires
+func (app *application) getNearestDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	cow, err := app.storeFor(r).Cow(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var nearest *Device
+	var nearestDistance float64
+	for _, device := range app.storeFor(r).Devices() {
+		if !device.Available() {
+			continue
+		}
+
+		distance := cow.Location.DistanceKm(device.Location)
+		if nearest == nil || distance < nearestDistance {
+			device := device
+			nearest = &device
+			nearestDistance = distance
+		}
+	}
+
+	// No device being available isn't an error, so respond with a null device and an
+	// explanation rather than a 404.
+	env := envelope{"cow_id": cow.ID, "device": nearest}
+	if nearest == nil {
+		env["message"] = "No device is currently available"
+	} else {
+		env["distance_km"] = nearestDistance
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "device", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// ErrDeviceUnavailable is returned when a device can't be dispatched in its current status.
+var ErrDeviceUnavailable = errors.New("device unavailable")
+
+// Dispatch represents a device being sent to a cow
+type Dispatch struct {
+	DeviceType   string    `json:"device_type"`
+	DeviceID     int       `json:"device_id"`
+	TargetCowID  int       `json:"target_cow_id"`
+	DispatchedAt time.Time `json:"dispatched_at"`
+}
+
+// DispatchDevice sets the given device en route to a cow, returning the device as it was
+// before and after being dispatched. It returns ErrRecordNotFound if there's no such
+// device, and ErrDeviceUnavailable (along with the device) if the device isn't in a status
+// from which it can be dispatched.
+func (s *FarmStore) DispatchDevice(deviceType string, deviceID, cowID int) (Device, Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var status *string
+	var target **int
+	switch {
+	case deviceType == "robodog" && s.roboDog.ID == deviceID:
+		status, target = &s.roboDog.Status, &s.roboDog.TargetCowID
+	case deviceType == "drone" && s.drone.ID == deviceID:
+		status, target = &s.drone.Status, &s.drone.TargetCowID
+	default:
+		return Device{}, Device{}, ErrRecordNotFound
+	}
+
+	before := Device{Type: deviceType, ID: deviceID, Status: *status}
+	if !before.Available() {
+		return before, before, ErrDeviceUnavailable
+	}
+
+	*status = "en_route"
+	*target = &cowID
+	s.touch()
+
+	after := before
+	after.Status = *status
+
+	return before, after, nil
+}
+
+// createDispatchHandler sends an available device to a cow
+func (app *application) createDispatchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		DeviceType  string `json:"device_type"`
+		DeviceID    int    `json:"device_id"`
+		TargetCowID int    `json:"target_cow_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.DeviceType, "robodog", "drone"), "device_type", "must be robodog or drone")
+	v.Check(input.DeviceID > 0, "device_id", "must be a positive integer")
+	v.Check(input.TargetCowID > 0, "target_cow_id", "must be a positive integer")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.storeFor(r).Cow(input.TargetCowID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			v.AddError("target_cow_id", "cow not found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	before, device, err := app.storeFor(r).DispatchDevice(input.DeviceType, input.DeviceID, input.TargetCowID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			v.AddError("device_id", "device not found")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, ErrDeviceUnavailable):
+			app.conflictResponse(w, r, errCodeDeviceUnavailable, fmt.Sprintf("the %s can't be dispatched while its status is %q", device.Type, device.Status))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "command", device.Type, device.ID,
+		deviceAuditSummary(before.Status, nil), deviceAuditSummary(device.Status, &input.TargetCowID))
+
+	dispatch := Dispatch{
+		DeviceType:   device.Type,
+		DeviceID:     device.ID,
+		TargetCowID:  input.TargetCowID,
+		DispatchedAt: app.clock.Now(),
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusCreated, "dispatch", envelope{"dispatch": dispatch}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// defaultTakeoffAltitude is the altitude the drone climbs to on takeoff when none is given.
+const defaultTakeoffAltitude = 50.0 // meters
+
+// The ways a drone command can treat its altitude parameter.
+const (
+	altitudeOptional  = "optional"
+	altitudeRequired  = "required"
+	altitudeForbidden = "forbidden"
+)
+
+// droneCommandSpec describes a drone command: the statuses the drone must be in for it to
+// be carried out, whether it takes an altitude, and whether it's a flight command, which
+// sends the drone up or keeps it in the air and so is refused when the wind is too strong
+// to fly safely. The specs drive the validation and carrying out of commands, and the
+// list of commands in GET /api/commands, so they can't disagree.
+type droneCommandSpec struct {
+	name     string
+	statuses []string
+	altitude string
+	flight   bool
+}
+
+// droneCommands are the commands the drone accepts.
+var droneCommands = []droneCommandSpec{
+	{name: "takeoff", statuses: []string{"landed"}, altitude: altitudeOptional, flight: true},
+	{name: "land", statuses: []string{"flying", "en_route"}, altitude: altitudeForbidden},
+	{name: "set_altitude", statuses: []string{"flying", "en_route"}, altitude: altitudeRequired, flight: true},
+}
+
+// droneCommandSpecFor returns the spec of the named drone command, or false if there's no
+// such command.
+func droneCommandSpecFor(name string) (droneCommandSpec, bool) {
+	for _, spec := range droneCommands {
+		if spec.name == name {
+			return spec, true
+		}
+	}
+	return droneCommandSpec{}, false
+}
+
+// DroneCommand is a flight command sent to the drone. Altitude is required for
+// set_altitude and optional for takeoff.
+type DroneCommand struct {
+	Command  string   `json:"command"` // takeoff, land, set_altitude
+	Altitude *float64 `json:"altitude,omitempty"`
+}
+
+// ValidateDroneCommand checks that the command is known and has the parameters it needs.
+func ValidateDroneCommand(v *validator.Validator, cmd DroneCommand) {
+	spec, ok := droneCommandSpecFor(cmd.Command)
+	v.Check(ok, "command", "must be takeoff, land or set_altitude")
+
+	switch spec.altitude {
+	case altitudeRequired:
+		v.Check(cmd.Altitude != nil, "altitude", "must be provided")
+	case altitudeForbidden:
+		v.Check(cmd.Altitude == nil, "altitude", "must not be provided when landing")
+	}
+
+	if cmd.Altitude != nil {
+		v.Check(*cmd.Altitude >= minDroneAltitude && *cmd.Altitude <= maxDroneAltitude, "altitude", fmt.Sprintf("must be between %g and %g meters", minDroneAltitude, maxDroneAltitude))
+	}
+}
+
+// WindSpeedError is returned when a flight command is refused because the drone's
+// last-reported wind speed is above the safe maximum.
+type WindSpeedError struct {
+	WindSpeed    float64 // km/h
+	MaxWindSpeed float64 // km/h
+}
+
+func (e *WindSpeedError) Error() string {
+	return fmt.Sprintf("wind speed of %g km/h exceeds the safe maximum of %g km/h", e.WindSpeed, e.MaxWindSpeed)
+}
+
+// ApplyDroneCommand carries out a flight command, returning the drone as it was before
+// and after. It returns ErrDeviceUnavailable (along with the drone) if the command can't
+// be carried out in the drone's current status, and a *WindSpeedError if it's a flight
+// command and the last-reported wind speed is above maxWindSpeed.
+func (s *FarmStore) ApplyDroneCommand(cmd DroneCommand, maxWindSpeed float64, now time.Time) (Drone, Drone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.drone
+	spec, _ := droneCommandSpecFor(cmd.Command)
+
+	if !slices.Contains(spec.statuses, s.drone.Status) {
+		return before, before, ErrDeviceUnavailable
+	}
+
+	if spec.flight && s.drone.Sensors.WindSpeed > maxWindSpeed {
+		return before, before, &WindSpeedError{WindSpeed: s.drone.Sensors.WindSpeed, MaxWindSpeed: maxWindSpeed}
+	}
+
+	switch cmd.Command {
+	case "takeoff":
+		s.drone.Status = "flying"
+		s.drone.Altitude = defaultTakeoffAltitude
+		if cmd.Altitude != nil {
+			s.drone.Altitude = *cmd.Altitude
+		}
+	case "set_altitude":
+		s.drone.Altitude = *cmd.Altitude
+	case "land":
+		s.drone.Status = "landed"
+		s.drone.Altitude = 0
+	}
+
+	s.drone.LastUpdated = now
+	s.recordDroneHistory()
+	s.touch()
+
+	return before, s.drone, nil
+}
+
+// createDroneCommandHandler sends a flight command to the drone
+func (app *application) createDroneCommandHandler(w http.ResponseWriter, r *http.Request) {
+	var input DroneCommand
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	ValidateDroneCommand(v, input)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before, drone, err := app.storeFor(r).ApplyDroneCommand(input, app.config.maxWindSpeed, app.clock.Now())
+	if err != nil {
+		var windErr *WindSpeedError
+		switch {
+		case errors.As(err, &windErr):
+			app.unsafeWindResponse(w, r, input.Command, windErr.WindSpeed, windErr.MaxWindSpeed)
+		case errors.Is(err, ErrDeviceUnavailable):
+			app.conflictResponse(w, r, errCodeDeviceUnavailable, fmt.Sprintf("the drone can't %s while its status is %q", input.Command, drone.Status))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "command", "drone", drone.ID, droneCommandAuditSummary(before), droneCommandAuditSummary(drone))
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "drone", envelope{"drone": drone}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// droneCommandAuditSummary summarises the parts of the drone a command changes.
+func droneCommandAuditSummary(drone Drone) map[string]any {
+	return map[string]any{
+		"status":   drone.Status,
+		"altitude": drone.Altitude,
+	}
+}
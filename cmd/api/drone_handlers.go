@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// Drone flight limits used when validating patrol routes.
+const (
+	minDroneAltitude = 10.0  // meters
+	maxDroneAltitude = 200.0 // meters
+	maxDroneRangeKm  = 30.0  // range on a full battery
+)
+
+// Waypoint represents a single point on a drone patrol route
+type Waypoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"` // meters
+}
+
+// DroneRoute represents an ordered patrol route assigned to the drone
+type DroneRoute struct {
+	Waypoints       []Waypoint `json:"waypoints"`
+	TotalDistanceKm float64    `json:"total_distance_km"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// routeDistanceKm returns the total distance of flying the waypoints in order.
+func routeDistanceKm(waypoints []Waypoint) float64 {
+	var total float64
+	for i := 1; i < len(waypoints); i++ {
+		total += haversineKm(waypoints[i-1].Latitude, waypoints[i-1].Longitude, waypoints[i].Latitude, waypoints[i].Longitude)
+	}
+
+	return total
+}
+
+// droneRangeKm estimates how far the drone can fly on its remaining battery.
+func droneRangeKm(batteryLevel int) float64 {
+	return maxDroneRangeKm * float64(batteryLevel) / 100
+}
+
+// ValidateWaypoints checks that a route is non-empty and that every waypoint is inside the
+// geofence and within the drone's altitude limits.
+func ValidateWaypoints(v *validator.Validator, waypoints []Waypoint, geofenceRadiusKm float64) {
+	v.Check(len(waypoints) > 0, "waypoints", "must contain at least one waypoint")
+
+	for i, wp := range waypoints {
+		key := fmt.Sprintf("waypoints[%d]", i)
+		wv := v.Nested(key)
+		wv.Check(wp.Latitude >= -90 && wp.Latitude <= 90, "latitude", "must be between -90 and 90")
+		wv.Check(wp.Longitude >= -180 && wp.Longitude <= 180, "longitude", "must be between -180 and 180")
+		v.Check(withinGeofence(wp.Latitude, wp.Longitude, geofenceRadiusKm), key, fmt.Sprintf("must be within %g km of the farm", geofenceRadiusKm))
+		wv.Check(wp.Altitude >= minDroneAltitude && wp.Altitude <= maxDroneAltitude, "altitude", fmt.Sprintf("must be between %g and %g meters", minDroneAltitude, maxDroneAltitude))
+	}
+}
+
+// SetDroneRoute assigns a patrol route to the drone.
+func (s *FarmStore) SetDroneRoute(route DroneRoute) Drone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drone.Route = &route
+	s.touch()
+	return s.drone
+}
+
+// createDroneRouteHandler validates and stores a patrol route for the drone
+func (app *application) createDroneRouteHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Waypoints []Waypoint `json:"waypoints"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	ValidateWaypoints(v, input.Waypoints, app.config.geofenceRadiusKm)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	route := DroneRoute{
+		Waypoints:       input.Waypoints,
+		TotalDistanceKm: routeDistanceKm(input.Waypoints),
+		CreatedAt:       app.clock.Now(),
+	}
+
+	// Reject routes the drone couldn't complete on its current charge.
+	maxRange := droneRangeKm(app.storeFor(r).Drone().BatteryLevel)
+	if route.TotalDistanceKm > maxRange {
+		v.AddError("waypoints", fmt.Sprintf("total distance of %.2f km exceeds the drone's estimated range of %.2f km", route.TotalDistanceKm, maxRange))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before := app.storeFor(r).Drone()
+	after := app.storeFor(r).SetDroneRoute(route)
+	app.audit(r, "update", "drone", after.ID, routeAuditSummary(before.Route), routeAuditSummary(after.Route))
+
+	err = app.writeEnvelope(w, r, http.StatusCreated, "route", envelope{"route": route}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getDroneRouteHandler returns the drone's current patrol route
+func (app *application) getDroneRouteHandler(w http.ResponseWriter, r *http.Request) {
+	route := app.storeFor(r).Drone().Route
+	if route == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "route", envelope{"route": route}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// routeAuditSummary summarises a drone route for an audit entry.
+func routeAuditSummary(route *DroneRoute) map[string]any {
+	if route == nil {
+		return map[string]any{"route": nil}
+	}
+
+	return map[string]any{
+		"waypoints":         len(route.Waypoints),
+		"total_distance_km": route.TotalDistanceKm,
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// maxDroneHistoryLimit is the most drone telemetry readings returned by a single request.
+const maxDroneHistoryLimit = 1000
+
+// recordDroneHistory appends the drone's current telemetry to its history. It's called
+// whenever the telemetry changes, so the history is in chronological order. The caller
+// must hold the write lock.
+func (s *FarmStore) recordDroneHistory() {
+	s.droneHistory.Push(DroneTelemetry{
+		Location:     s.drone.Location,
+		Altitude:     s.drone.Altitude,
+		Sensors:      s.drone.Sensors,
+		BatteryLevel: s.drone.BatteryLevel,
+		RecordedAt:   s.drone.LastUpdated,
+	})
+}
+
+// DroneHistory returns the drone's recorded telemetry, oldest first.
+func (s *FarmStore) DroneHistory() []DroneTelemetry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.droneHistory.Items()
+}
+
+// getDroneHistoryHandler returns the drone's recorded telemetry, oldest first, optionally
+// limited to a time range. Only the most recent limit readings in the range are returned,
+// e.g. to reconstruct the wind and air quality seen on the latest flight.
+func (app *application) getDroneHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filters := HistoryFilters{
+		From: app.readTime(qs, "from", v),
+		To:   app.readTime(qs, "to", v),
+	}
+	ValidateHistoryFilters(v, filters)
+	limit := app.readInt(qs, "limit", 100, v)
+	v.Check(limit > 0 && limit <= maxDroneHistoryLimit, "limit", "must be between 1 and 1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Initialise the slice so that an empty range is returned as [] rather than null.
+	readings := []DroneTelemetry{}
+	for _, reading := range app.storeFor(r).DroneHistory() {
+		if filters.Contains(reading.RecordedAt) {
+			readings = append(readings, reading)
+		}
+	}
+	if len(readings) > limit {
+		readings = readings[len(readings)-limit:]
+	}
+
+	env := envelope{
+		"history": readings,
+		"total":   len(readings),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "history", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// dryRun middleware lets clients try out a create, update or delete without changing
+// anything, by adding ?dry_run=true or an X-Dry-Run: true header. The request is handled
+// as usual, validation included, but against a copy of its farm which is thrown away
+// afterwards, so the response shows what would have happened. Dry runs aren't audited or
+// cached for idempotency, and their responses carry "dry_run": true and an X-Dry-Run
+// header.
+func (app *application) dryRun(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+		dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+		if header := r.Header.Get("X-Dry-Run"); header != "" {
+			b, err := strconv.ParseBool(header)
+			v.Check(err == nil, "X-Dry-Run", "must be true or false")
+			dryRun = dryRun || b
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		if !dryRun {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		farm := app.farm(r)
+		r = contextSetFarm(r, &Farm{
+			ID:     farm.ID,
+			store:  farm.store.Clone(),
+			alerts: farm.alerts.Clone(),
+		})
+		r = contextSetDryRun(r)
+
+		w.Header().Set("X-Dry-Run", "true")
+		next.ServeHTTP(w, r)
+	})
+}
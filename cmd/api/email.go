@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+
+	"mooveit-backend.mooveit.com/internal/mailer"
+)
+
+// emailNotifier emails alerts to a list of recipients.
+type emailNotifier struct {
+	mailer     mailer.Mailer
+	recipients []string
+}
+
+// Notify emails the alert using the alert.tmpl template, giving up once ctx is done.
+// Sending is synchronous, so it should be called from a background goroutine.
+func (n *emailNotifier) Notify(ctx context.Context, alert Alert) error {
+	return n.mailer.SendContext(ctx, n.recipients, "alert.tmpl", alert)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// Machine-readable error codes included in every error response, so that clients can
+// switch on the code rather than string-matching the message.
+const (
+	errCodeInternal            = "INTERNAL_ERROR"
+	errCodeNotFound            = "NOT_FOUND"
+	errCodeCowNotFound         = "COW_NOT_FOUND"
+	errCodeHerdNotFound        = "HERD_NOT_FOUND"
+	errCodeFarmNotFound        = "FARM_NOT_FOUND"
+	errCodeMethodNotAllowed    = "METHOD_NOT_ALLOWED"
+	errCodeBadRequest          = "BAD_REQUEST"
+	errCodeMalformedJSON       = "MALFORMED_JSON"
+	errCodeValidationFailed    = "VALIDATION_FAILED"
+	errCodeDeviceUnavailable   = "DEVICE_UNAVAILABLE"
+	errCodeDuplicateTag        = "DUPLICATE_TAG"
+	errCodeUnsafeWind          = "UNSAFE_WIND_SPEED"
+	errCodeBodyTooLarge        = "BODY_TOO_LARGE"
+	errCodeUnsupportedMedia    = "UNSUPPORTED_MEDIA_TYPE"
+	errCodeIdempotencyConflict = "IDEMPOTENCY_CONFLICT"
+	errCodeInvalidToken        = "INVALID_AUTHENTICATION_TOKEN"
+	errCodeAuthRequired        = "AUTHENTICATION_REQUIRED"
+	errCodeMaintenance         = "MAINTENANCE"
+	errCodeHerdLimit           = "HERD_LIMIT_REACHED"
+	errCodeLifecycleTransition = "INVALID_LIFECYCLE_TRANSITION"
+)
+
+// APIError is the body of the "error" envelope returned for every failed request
+type APIError struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`  // per-field validation errors
+	Details   map[string]any    `json:"details,omitempty"` // values which explain the error, e.g. the reading which broke a limit
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// errorResponse sends a JSON-formatted error to the client with the given status code,
+// tagging it with the request ID if the request has one.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, apiErr APIError) {
+	apiErr.RequestID = contextGetRequestID(r)
+	env := envelope{"error": apiErr}
+
+	err := app.writeJSON(w, r, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// serverErrorResponse sends a JSON-formatted error message to the client with the given
+// status code, and logs the error using our custom logger at the ERROR level.
+func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	log.ErrorCtx(r.Context(), err, map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+	})
+
+	env := envelope{"error": APIError{
+		Code:      errCodeInternal,
+		Message:   "The server encountered a problem and could not process your request",
+		RequestID: contextGetRequestID(r),
+	}}
+
+	// Write the response using the writeJSON() helper. If this happens to return an
+	// error then log it, and exit. We don't want to send a response after this point
+	// as we will already have sent the HTTP status code to the client.
+	err = app.writeJSON(w, r, http.StatusInternalServerError, env, nil)
+	if err != nil {
+		log.Error("%s", err)
+	}
+}
+
+// notFoundResponse sends a JSON-formatted 404 Not Found response to the client
+func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusNotFound, APIError{
+		Code:    errCodeNotFound,
+		Message: "The requested resource could not be found",
+	})
+}
+
+// cowNotFoundResponse sends a JSON-formatted 404 Not Found response to the client when
+// the requested cow doesn't exist
+func (app *application) cowNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusNotFound, APIError{
+		Code:    errCodeCowNotFound,
+		Message: "The requested cow could not be found",
+	})
+}
+
+// herdNotFoundResponse sends a JSON-formatted 404 Not Found response to the client when
+// the requested herd doesn't exist
+func (app *application) herdNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusNotFound, APIError{
+		Code:    errCodeHerdNotFound,
+		Message: "The requested herd could not be found",
+	})
+}
+
+// farmNotFoundResponse sends a JSON-formatted 404 Not Found response to the client when
+// the X-Farm-ID header names a farm which isn't hosted here
+func (app *application) farmNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusNotFound, APIError{
+		Code:    errCodeFarmNotFound,
+		Message: "The requested farm could not be found",
+	})
+}
+
+// methodNotAllowedResponse sends a JSON-formatted 405 Method Not Allowed response to the
+// client
+func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, APIError{
+		Code:    errCodeMethodNotAllowed,
+		Message: "The " + r.Method + " method is not supported for this resource",
+	})
+}
+
+// badRequestResponse sends a JSON-formatted 400 Bad Request response to the client,
+// using the error message as the response message. Errors from readJSON() are given the
+// MALFORMED_JSON code, or a 413 with BODY_TOO_LARGE if the body exceeded the limit.
+func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := classifyBadRequest(err)
+	app.errorResponse(w, r, status, APIError{
+		Code:    code,
+		Message: err.Error(),
+	})
+}
+
+// malformedJSONMessages are the prefixes of the readJSON() errors which mean the body
+// couldn't be parsed into the expected JSON, as opposed to failing validation.
+var malformedJSONMessages = []string{
+	"body contains badly-formed JSON",
+	"body contains incorrect JSON type",
+	"body contains unknown key",
+	"body contains malformed gzip data",
+	"body must not be empty",
+	"body must only contain a single JSON value",
+}
+
+// classifyBadRequest maps the errors returned by readJSON() to the status and code of the
+// response, so that clients can tell a body which didn't parse from one which was too
+// large. Any other error is a plain 400 BAD_REQUEST.
+func classifyBadRequest(err error) (int, string) {
+	message := err.Error()
+
+	if strings.HasPrefix(message, "body must not be larger than") {
+		return http.StatusRequestEntityTooLarge, errCodeBodyTooLarge
+	}
+
+	for _, prefix := range malformedJSONMessages {
+		if strings.HasPrefix(message, prefix) {
+			return http.StatusBadRequest, errCodeMalformedJSON
+		}
+	}
+
+	return http.StatusBadRequest, errCodeBadRequest
+}
+
+// payloadTooLargeResponse sends a JSON-formatted 413 Payload Too Large response to the
+// client when the request body exceeds limit bytes.
+func (app *application) payloadTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, APIError{
+		Code:    errCodeBodyTooLarge,
+		Message: fmt.Sprintf("body must not be larger than %d bytes", limit),
+	})
+}
+
+// unsupportedMediaTypeResponse sends a JSON-formatted 415 Unsupported Media Type response
+// to the client when the request body isn't in a format the endpoint accepts.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, expected string) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, APIError{
+		Code:    errCodeUnsupportedMedia,
+		Message: fmt.Sprintf("Content-Type must be %s", expected),
+	})
+}
+
+// conflictResponse sends a JSON-formatted 409 Conflict response to the client, for requests
+// which are valid but can't be carried out in the resource's current state.
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, code, message string) {
+	app.errorResponse(w, r, http.StatusConflict, APIError{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// unsafeWindResponse sends a JSON-formatted 409 Conflict response to the client when a
+// drone flight command is refused because the wind is too strong, including the measured
+// and maximum wind speeds.
+func (app *application) unsafeWindResponse(w http.ResponseWriter, r *http.Request, command string, windSpeed, maxWindSpeed float64) {
+	app.errorResponse(w, r, http.StatusConflict, APIError{
+		Code:    errCodeUnsafeWind,
+		Message: fmt.Sprintf("the drone can't %s: wind speed of %g km/h exceeds the safe maximum of %g km/h", command, windSpeed, maxWindSpeed),
+		Details: map[string]any{
+			"wind_speed":     windSpeed,
+			"max_wind_speed": maxWindSpeed,
+		},
+	})
+}
+
+// herdLimitResponse sends a JSON-formatted 507 Insufficient Storage response to the client
+// when creating or importing cows would take the herd past -max-cows.
+func (app *application) herdLimitResponse(w http.ResponseWriter, r *http.Request, limitErr *HerdLimitError) {
+	app.errorResponse(w, r, http.StatusInsufficientStorage, APIError{
+		Code:    errCodeHerdLimit,
+		Message: limitErr.Error(),
+		Details: map[string]any{
+			"current_cows": limitErr.Current,
+			"adding":       limitErr.Adding,
+			"max_cows":     limitErr.Max,
+		},
+	})
+}
+
+// invalidAuthenticationTokenResponse sends a JSON-formatted 401 Unauthorized response to
+// the client when the credentials in its Authorization header aren't valid.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	app.errorResponse(w, r, http.StatusUnauthorized, APIError{
+		Code:    errCodeInvalidToken,
+		Message: "invalid or missing authentication token",
+	})
+}
+
+// authenticationRequiredResponse sends a JSON-formatted 401 Unauthorized response to the
+// client when the endpoint is restricted to admins.
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	app.errorResponse(w, r, http.StatusUnauthorized, APIError{
+		Code:    errCodeAuthRequired,
+		Message: "you must be authenticated as an admin to access this resource",
+	})
+}
+
+// maintenanceResponse sends a JSON-formatted 503 Service Unavailable response to the
+// client while maintenance mode is enabled, telling it to retry after retryAfter seconds.
+func (app *application) maintenanceResponse(w http.ResponseWriter, r *http.Request, retryAfter int64) {
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+
+	app.errorResponse(w, r, http.StatusServiceUnavailable, APIError{
+		Code:    errCodeMaintenance,
+		Message: "The server is down for maintenance, please try again later",
+	})
+}
+
+// failedValidationResponse sends a JSON-formatted 422 Unprocessable Entity response to
+// the client. The errors parameter has the type map[string]string, which is exactly the
+// same as the errors map contained in our Validator type.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, APIError{
+		Code:    errCodeValidationFailed,
+		Message: "One or more fields are invalid",
+		Fields:  errors,
+	})
+}
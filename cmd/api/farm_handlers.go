@@ -1,19 +1,44 @@
 package main
 
 import (
+	"errors"
+	"expvar"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"mooveit-backend.mooveit.com/internal/validator"
 )
 
 // Cow represents a cow with sensor data
 type Cow struct {
-	ID          int        `json:"id"`
-	Name        string     `json:"name"`
-	Tag         string     `json:"tag"`
-	Location    Location   `json:"location"`
-	Health      Health     `json:"health"`
-	Sensors     CowSensors `json:"sensors"`
-	LastUpdated time.Time  `json:"last_updated"`
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	Tag      string     `json:"tag"`
+	Location Location   `json:"location"`
+	Health   Health     `json:"health"`
+	Sensors  CowSensors `json:"sensors"`
+	HerdID   *int       `json:"herd_id,omitempty"`
+	// LifecycleStatus is where the cow is in its life on the farm: active, quarantined,
+	// sold or deceased. Only active cows count towards the herd statistics by default.
+	LifecycleStatus string     `json:"lifecycle_status"`
+	LastUpdated     time.Time  `json:"last_updated"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"` // set when the cow has been sold or culled
+	// Stale and SecondsSinceUpdate are derived from LastUpdated when the cow is read.
+	Stale              bool  `json:"stale"`
+	SecondsSinceUpdate int64 `json:"seconds_since_update"`
+
+	// modifiedAt is when anything about the cow last changed, for its Last-Modified
+	// header. Unlike LastUpdated, which is when its latest reading was taken, it also
+	// moves when the cow is renamed, moved between herds or through its lifecycle, or
+	// restored. It's set by the store.
+	modifiedAt time.Time
+}
+
+// Deleted reports whether the cow has been soft-deleted.
+func (c Cow) Deleted() bool {
+	return c.DeletedAt != nil
 }
 
 // Location represents GPS coordinates
@@ -25,10 +50,15 @@ type Location struct {
 
 // Health represents health status
 type Health struct {
-	Status      string  `json:"status"`      // healthy, sick, injured
-	Temperature float64 `json:"temperature"` // in Celsius
-	HeartRate   int     `json:"heart_rate"`  // beats per minute
-	Activity    string  `json:"activity"`    // grazing, resting, moving
+	Status      string  `json:"status"`          // healthy, sick, injured
+	Temperature float64 `json:"temperature"`     // in Celsius
+	HeartRate   int     `json:"heart_rate"`      // beats per minute
+	Activity    string  `json:"activity"`        // grazing, resting, moving
+	Trend       string  `json:"trend,omitempty"` // improving, stable, worsening, unknown; derived from the sensor history
+	// TemperatureSmoothed and HeartRateSmoothed are exponential moving averages of the
+	// readings, which aren't thrown by a single noisy reading.
+	TemperatureSmoothed float64 `json:"temperature_smoothed,omitempty"`
+	HeartRateSmoothed   float64 `json:"heart_rate_smoothed,omitempty"`
 }
 
 // CowSensors represents sensor data from cow
@@ -43,11 +73,15 @@ type CowSensors struct {
 type RoboDog struct {
 	ID           int            `json:"id"`
 	Name         string         `json:"name"`
-	Status       string         `json:"status"` // active, idle, charging, maintenance
+	Status       string         `json:"status"` // active, idle, charging, maintenance, en_route
 	Location     Location       `json:"location"`
 	Sensors      RoboDogSensors `json:"sensors"`
 	BatteryLevel int            `json:"battery_level"` // percentage
+	TargetCowID  *int           `json:"target_cow_id,omitempty"`
 	LastUpdated  time.Time      `json:"last_updated"`
+	// Stale and SecondsSinceUpdate are derived from LastUpdated when the robo-dog is read.
+	Stale              bool  `json:"stale"`
+	SecondsSinceUpdate int64 `json:"seconds_since_update"`
 }
 
 // RoboDogSensors represents sensor data from robo-dog
@@ -63,12 +97,17 @@ type RoboDogSensors struct {
 type Drone struct {
 	ID           int          `json:"id"`
 	Name         string       `json:"name"`
-	Status       string       `json:"status"` // flying, landed, charging, maintenance
+	Status       string       `json:"status"` // flying, landed, charging, maintenance, en_route
 	Location     Location     `json:"location"`
 	Altitude     float64      `json:"altitude"` // meters
 	Sensors      DroneSensors `json:"sensors"`
 	BatteryLevel int          `json:"battery_level"` // percentage
+	Route        *DroneRoute  `json:"route,omitempty"`
+	TargetCowID  *int         `json:"target_cow_id,omitempty"`
 	LastUpdated  time.Time    `json:"last_updated"`
+	// Stale and SecondsSinceUpdate are derived from LastUpdated when the drone is read.
+	Stale              bool  `json:"stale"`
+	SecondsSinceUpdate int64 `json:"seconds_since_update"`
 }
 
 // DroneSensors represents sensor data from drone
@@ -83,15 +122,25 @@ type DroneSensors struct {
 
 // FarmState represents the overall state of the farm
 type FarmState struct {
-	TotalCows     int       `json:"total_cows"`
-	HealthyCows   int       `json:"healthy_cows"`
-	SickCows      int       `json:"sick_cows"`
-	RoboDogStatus string    `json:"robodog_status"`
-	DroneStatus   string    `json:"drone_status"`
-	LastUpdated   time.Time `json:"last_updated"`
+	TotalCows     int                  `json:"total_cows"`
+	HealthyCows   int                  `json:"healthy_cows"`
+	SickCows      int                  `json:"sick_cows"`
+	RoboDogStatus string               `json:"robodog_status"`
+	DroneStatus   string               `json:"drone_status"`
+	Zones         map[string]ZoneState `json:"zones"` // keyed by zone name
+	LastUpdated   time.Time            `json:"last_updated"`
+}
+
+// ZoneState represents the state of a single zone (pasture) of the farm
+type ZoneState struct {
+	TotalCows          int      `json:"total_cows"`
+	HealthyCows        int      `json:"healthy_cows"`
+	SickCows           int      `json:"sick_cows"`
+	AverageTemperature float64  `json:"average_temperature"` // in Celsius
+	Devices            []string `json:"devices"`             // types of the devices currently in the zone
 }
 
-// Mock data storage
+// Mock data used to seed the FarmStore
 var mockCows = []Cow{
 	{
 		ID:   1,
@@ -114,7 +163,8 @@ var mockCows = []Cow{
 			Activity:     "grazing",
 			BatteryLevel: 85,
 		},
-		LastUpdated: time.Now(),
+		LifecycleStatus: lifecycleActive,
+		LastUpdated:     time.Now(),
 	},
 	{
 		ID:   2,
@@ -137,7 +187,8 @@ var mockCows = []Cow{
 			Activity:     "resting",
 			BatteryLevel: 92,
 		},
-		LastUpdated: time.Now(),
+		LifecycleStatus: lifecycleActive,
+		LastUpdated:     time.Now(),
 	},
 	{
 		ID:   3,
@@ -160,7 +211,8 @@ var mockCows = []Cow{
 			Activity:     "resting",
 			BatteryLevel: 78,
 		},
-		LastUpdated: time.Now(),
+		LifecycleStatus: lifecycleActive,
+		LastUpdated:     time.Now(),
 	},
 	{
 		ID:   4,
@@ -183,7 +235,8 @@ var mockCows = []Cow{
 			Activity:     "moving",
 			BatteryLevel: 88,
 		},
-		LastUpdated: time.Now(),
+		LifecycleStatus: lifecycleActive,
+		LastUpdated:     time.Now(),
 	},
 	{
 		ID:   5,
@@ -206,7 +259,8 @@ var mockCows = []Cow{
 			Activity:     "grazing",
 			BatteryLevel: 90,
 		},
-		LastUpdated: time.Now(),
+		LifecycleStatus: lifecycleActive,
+		LastUpdated:     time.Now(),
 	},
 }
 
@@ -252,14 +306,68 @@ var mockDrone = Drone{
 	LastUpdated:  time.Now(),
 }
 
-// listCowsHandler returns a list of all cows with their sensor data
+// CowListMetadata is the pagination metadata of the cow list, along with the
+// updated_since cutoff when one was given.
+type CowListMetadata struct {
+	Metadata
+	UpdatedSince *time.Time `json:"updated_since,omitempty"`
+}
+
+// listCowsHandler returns a page of the cows matching the query string filters, with
+// their sensor data. The total is the number of cows matching the filters, across every
+// page.
 func (app *application) listCowsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	filters := app.readCowFilters(r.URL.Query(), v)
+	ValidateCowFilters(v, filters)
+	pagination := app.readPagination(r.URL.Query(), v)
+	ValidatePagination(v, pagination, app.config.maxPageSize)
+	fields := app.readFields(r.URL.Query(), cowFields, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	source := app.storeFor(r).Cows()
+	if filters.IncludeDeleted {
+		source = app.storeFor(r).AllCows()
+	}
+
+	matched := []Cow{}
+	for _, cow := range source {
+		if filters.Matches(cow) {
+			matched = append(matched, cow)
+		}
+	}
+	cows := paginate(matched, pagination)
+
+	metadata := CowListMetadata{Metadata: calculateMetadata(len(matched), pagination).withLinks(app.requestURL(r))}
+	if !filters.UpdatedSince.IsZero() {
+		metadata.UpdatedSince = &filters.UpdatedSince
+	}
+
 	env := envelope{
-		"cows":  mockCows,
-		"total": len(mockCows),
+		"cows":     cows,
+		"total":    len(matched),
+		"metadata": metadata,
+	}
+
+	// Cut each cow down to the requested fields, if the client asked for only some.
+	if fields != nil {
+		selected := make([]map[string]any, len(cows))
+		for i, cow := range cows {
+			var err error
+			selected[i], err = selectFields(cow, fields)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		env["cows"] = selected
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeEnvelope(w, r, http.StatusOK, "cows", env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -273,25 +381,79 @@ func (app *application) getCowHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, cow := range mockCows {
-		if cow.ID == int(id) {
-			env := envelope{"cow": cow}
-			err := app.writeJSON(w, http.StatusOK, env, nil)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
-			}
+	v := validator.New()
+	fields := app.readFields(r.URL.Query(), cowFields, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cow, err := app.storeFor(r).Cow(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if app.notModified(w, r, cow.modifiedAt) {
+		return
+	}
+
+	env := envelope{"cow": cow}
+
+	// Cut the cow down to the requested fields, if the client asked for only some.
+	if fields != nil {
+		env["cow"], err = selectFields(cow, fields)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
 			return
 		}
 	}
+	err = app.writeEnvelope(w, r, http.StatusOK, "cow", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getCowByTagHandler looks a cow up by its ear tag, as reported by the field staff's collar
+// scanners. Tags are matched regardless of case, since scanners don't agree on it.
+func (app *application) getCowByTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("tag"))
+
+	v := validator.New()
+	v.Check(len(tag) <= 32, "tag", "must not be more than 32 bytes long")
+	v.Check(validator.Matches(tag, TagRX), "tag", "must contain only letters, digits and hyphens")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	app.notFoundResponse(w, r)
+	cow, err := app.storeFor(r).CowByTag(tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "cow", envelope{"cow": cow}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 // getRoboDogHandler returns the robo-dog state and sensor data
 func (app *application) getRoboDogHandler(w http.ResponseWriter, r *http.Request) {
-	env := envelope{"robodog": mockRoboDog}
+	env := envelope{"robodog": app.storeFor(r).RoboDog()}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeEnvelope(w, r, http.StatusOK, "robodog", env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -299,39 +461,152 @@ func (app *application) getRoboDogHandler(w http.ResponseWriter, r *http.Request
 
 // getDroneHandler returns the drone state and sensor data
 func (app *application) getDroneHandler(w http.ResponseWriter, r *http.Request) {
-	env := envelope{"drone": mockDrone}
+	env := envelope{"drone": app.storeFor(r).Drone()}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeEnvelope(w, r, http.StatusOK, "drone", env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// getFarmStateHandler returns the overall farm state
+// getFarmStateHandler returns the overall farm state. Cows which are quarantined, sold or
+// deceased are left out unless ?include_inactive=true.
 func (app *application) getFarmStateHandler(w http.ResponseWriter, r *http.Request) {
-	healthyCount := 0
-	sickCount := 0
-	for _, cow := range mockCows {
-		if cow.Health.Status == "healthy" {
-			healthyCount++
-		} else if cow.Health.Status == "sick" {
-			sickCount++
-		}
+	v := validator.New()
+	includeInactive := app.readBool(r.URL.Query(), "include_inactive", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	farmState := FarmState{
-		TotalCows:     len(mockCows),
-		HealthyCows:   healthyCount,
-		SickCows:      sickCount,
-		RoboDogStatus: mockRoboDog.Status,
-		DroneStatus:   mockDrone.Status,
-		LastUpdated:   time.Now(),
+	farmState := app.storeFor(r).FarmState(includeInactive)
+
+	if app.notModified(w, r, farmState.LastUpdated) {
+		return
 	}
 
 	env := envelope{"farm_state": farmState}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeEnvelope(w, r, http.StatusOK, "farm_state", env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// farmStateCache publishes the number of farm state cache hits and misses in the expvar
+// handler, so we can check the cache is effective.
+var farmStateCache = expvar.NewMap("farm_state_cache")
+
+// FarmState summarises the farm as a whole and zone by zone. As with CowStats, only
+// active cows are counted unless includeInactive is set. The summary of the active cows,
+// which is what the dashboard polls for, is cached until the next change to the farm, so
+// a busy dashboard doesn't re-scan the herd on every request.
+func (s *FarmStore) FarmState(includeInactive bool) FarmState {
+	if includeInactive {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		return s.computeFarmState(true)
+	}
+
+	s.mu.RLock()
+	cached := s.farmState
+	s.mu.RUnlock()
+
+	if cached != nil {
+		farmStateCache.Add("hits", 1)
+		return cached.clone()
+	}
+
+	// Check again under the write lock, in case another request has filled the cache in
+	// the meantime. Reading the state doesn't change the farm, so it
+	// doesn't call s.touch().
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.farmState == nil {
+		farmStateCache.Add("misses", 1)
+		state := s.computeFarmState(false)
+		s.farmState = &state
+	} else {
+		farmStateCache.Add("hits", 1)
+	}
+
+	return s.farmState.clone()
+}
+
+// clone returns a copy of the farm state which doesn't share its zones with the original.
+func (fs FarmState) clone() FarmState {
+	zones := make(map[string]ZoneState, len(fs.Zones))
+	for name, zone := range fs.Zones {
+		zone.Devices = append([]string{}, zone.Devices...)
+		zones[name] = zone
+	}
+	fs.Zones = zones
+
+	return fs
+}
+
+// computeFarmState summarises the farm in a single pass, so the totals and the zones are
+// always consistent with each other. Cows which aren't active are left out unless
+// includeInactive is set. The caller must hold the lock.
+func (s *FarmStore) computeFarmState(includeInactive bool) FarmState {
+	state := FarmState{
+		RoboDogStatus: s.roboDog.Status,
+		DroneStatus:   s.drone.Status,
+		Zones:         make(map[string]ZoneState),
+		LastUpdated:   s.modifiedAt,
+	}
+
+	temperatureSums := make(map[string]float64)
+	for _, cow := range s.cows {
+		if cow.Deleted() || (!includeInactive && cow.LifecycleStatus != lifecycleActive) {
+			continue
+		}
+
+		zone := state.Zones[cow.Location.Zone]
+		zone.TotalCows++
+		state.TotalCows++
+
+		switch cow.Health.Status {
+		case "healthy":
+			zone.HealthyCows++
+			state.HealthyCows++
+		case "sick":
+			zone.SickCows++
+			state.SickCows++
+		}
+
+		temperatureSums[cow.Location.Zone] += cow.Health.Temperature
+		state.Zones[cow.Location.Zone] = zone
+	}
+
+	for name, zone := range state.Zones {
+		zone.AverageTemperature = temperatureSums[name] / float64(zone.TotalCows)
+		zone.Devices = []string{}
+		state.Zones[name] = zone
+	}
+
+	// A device may be in a zone which has no cows in it at the moment.
+	devices := []struct {
+		deviceType string
+		zone       string
+	}{
+		{"robodog", s.roboDog.Location.Zone},
+		{"drone", s.drone.Location.Zone},
+	}
+	for _, device := range devices {
+		if device.zone == "" {
+			continue
+		}
+
+		zone, ok := state.Zones[device.zone]
+		if !ok {
+			zone.Devices = []string{}
+		}
+		zone.Devices = append(zone.Devices, device.deviceType)
+		state.Zones[device.zone] = zone
+	}
+
+	return state
+}
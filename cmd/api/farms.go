@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+// defaultFarmID is the farm served when -farms isn't set.
+const defaultFarmID = "default"
+
+// farmIDRX matches the IDs accepted by -farms: lower-case letters, digits and hyphens, so
+// that they're safe to use in headers, logs and metric labels.
+var farmIDRX = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Farm is one of the farms hosted by the backend. Each farm has its own cows, herds,
+// devices and alerts, so nothing is shared between farms.
+type Farm struct {
+	ID     string
+	store  *FarmStore
+	alerts *AlertRegistry
+}
+
+// FarmRegistry holds the farms hosted by the backend, keyed by ID. The set of farms is
+// fixed by -farms at startup, but the map is still guarded by a lock so that farms can
+// safely be looked up from any goroutine.
+type FarmRegistry struct {
+	mu        sync.RWMutex
+	farms     map[string]*Farm
+	ids       []string // in the order they were configured
+	defaultID string
+}
+
+// newFarmRegistry returns a registry holding a farm for each of the given IDs, each
+// seeded with the mock farm data and keeping alertHistorySize resolved alerts. The first
+// ID is the default farm, which serves requests that don't say which farm they're for.
+func newFarmRegistry(ids []string, newStore func() *FarmStore, alertHistorySize int) *FarmRegistry {
+	reg := &FarmRegistry{
+		farms:     make(map[string]*Farm, len(ids)),
+		ids:       append([]string(nil), ids...),
+		defaultID: ids[0],
+	}
+	for _, id := range ids {
+		reg.farms[id] = &Farm{
+			ID:     id,
+			store:  newStore(),
+			alerts: newAlertRegistry(alertHistorySize),
+		}
+	}
+
+	return reg
+}
+
+// Get returns the farm with the given ID, if there is one.
+func (reg *FarmRegistry) Get(id string) (*Farm, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	farm, ok := reg.farms[id]
+	return farm, ok
+}
+
+// Default returns the default farm.
+func (reg *FarmRegistry) Default() *Farm {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	return reg.farms[reg.defaultID]
+}
+
+// All returns every farm, in the order they were configured.
+func (reg *FarmRegistry) All() []*Farm {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	farms := make([]*Farm, len(reg.ids))
+	for i, id := range reg.ids {
+		farms[i] = reg.farms[id]
+	}
+	return farms
+}
+
+// scopeFarm middleware selects the farm a request is for from its X-Farm-ID header, and
+// adds it to the request context. Requests without the header are for the default farm,
+// and requests for a farm which isn't hosted here get a 404 Not Found response.
+func (app *application) scopeFarm(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-Farm-ID")
+
+		farm := app.farms.Default()
+		if id := r.Header.Get("X-Farm-ID"); id != "" {
+			var ok bool
+			farm, ok = app.farms.Get(id)
+			if !ok {
+				app.farmNotFoundResponse(w, r)
+				return
+			}
+		}
+
+		r = contextSetFarm(r, farm)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// farm returns the farm the request is for, falling back to the default farm if the
+// scopeFarm middleware hasn't run.
+func (app *application) farm(r *http.Request) *Farm {
+	if farm := contextGetFarm(r); farm != nil {
+		return farm
+	}
+	return app.farms.Default()
+}
+
+// storeFor returns the store of the farm the request is for.
+func (app *application) storeFor(r *http.Request) *FarmStore {
+	return app.farm(r).store
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// cowFields are the fields which can be selected from a cow with the ?fields= parameter.
+// Nested fields are addressed with a dot, and selecting an object selects all of it.
+var cowFields = []string{
+	"id", "name", "tag",
+	"location", "location.latitude", "location.longitude", "location.zone",
+	"health", "health.status", "health.temperature", "health.heart_rate", "health.activity", "health.trend",
+	"health.temperature_smoothed", "health.heart_rate_smoothed",
+	"sensors", "sensors.temperature", "sensors.heart_rate", "sensors.activity", "sensors.battery_level",
+	"herd_id", "lifecycle_status", "last_updated", "deleted_at", "stale", "seconds_since_update",
+}
+
+// readFields reads the comma-separated ?fields= parameter, checking each field against
+// the permitted set. Fields may be given in camelCase, for clients which get camelCase
+// responses. It returns nil if the parameter isn't set, meaning every field.
+func (app *application) readFields(qs url.Values, permitted []string, v *validator.Validator) []string {
+	fields := app.readCSV(qs, "fields", nil)
+
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+		for _, field := range permitted {
+			if snakeToCamel(field) == fields[i] {
+				fields[i] = field
+				break
+			}
+		}
+		if !slices.Contains(permitted, fields[i]) {
+			v.AddError("fields", fmt.Sprintf("contains unknown field %q", fields[i]))
+		}
+	}
+
+	return fields
+}
+
+// selectFields returns the JSON representation of data cut down to the given fields, for
+// clients on slow connections which only need a few of them. Fields which are omitted
+// from data's JSON, such as an unset herd_id, are left out.
+func selectFields(data any, fields []string) (map[string]any, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var full map[string]any
+	err = json.Unmarshal(js, &full)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]any)
+	for _, field := range fields {
+		copyField(full, selected, strings.Split(field, "."))
+	}
+
+	return selected, nil
+}
+
+// copyField copies the value at path from src into dst, creating the intermediate objects
+// as needed.
+func copyField(src, dst map[string]any, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+
+	nested, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+
+	child, ok := dst[path[0]].(map[string]any)
+	if !ok {
+		child = make(map[string]any)
+		dst[path[0]] = child
+	}
+
+	copyField(nested, child, path[1:])
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// CowFilters holds the query string filters accepted by the cow list endpoint
+type CowFilters struct {
+	Status         string // health status; empty matches every cow
+	Zone           string // empty matches every cow
+	BatteryMin     int
+	BatteryMax     int
+	IncludeDeleted bool
+	// UpdatedSince limits the list to cows updated at or after it, so that polling
+	// clients can fetch only what changed. The zero time means no limit.
+	UpdatedSince time.Time
+}
+
+// readCowFilters reads the cow list filters from the query string, recording any problems
+// in the provided Validator instance.
+func (app *application) readCowFilters(qs url.Values, v *validator.Validator) CowFilters {
+	return CowFilters{
+		Status:         app.readString(qs, "status", ""),
+		Zone:           app.readString(qs, "zone", ""),
+		BatteryMin:     app.readInt(qs, "battery_min", 0, v),
+		BatteryMax:     app.readInt(qs, "battery_max", 100, v),
+		IncludeDeleted: app.readBool(qs, "include_deleted", false, v),
+		UpdatedSince:   app.readTime(qs, "updated_since", v),
+	}
+}
+
+// ValidateCowFilters checks that the filter values are within range and consistent.
+func ValidateCowFilters(v *validator.Validator, f CowFilters) {
+	if f.Status != "" {
+		v.Check(validator.PermittedValue(f.Status, "healthy", "sick", "injured"), "status", "must be healthy, sick or injured")
+	}
+	v.Check(f.BatteryMin >= 0 && f.BatteryMin <= 100, "battery_min", "must be between 0 and 100")
+	v.Check(f.BatteryMax >= 0 && f.BatteryMax <= 100, "battery_max", "must be between 0 and 100")
+	v.Check(f.BatteryMin <= f.BatteryMax, "battery_min", "must not be greater than battery_max")
+}
+
+// Matches reports whether a cow satisfies every filter.
+func (f CowFilters) Matches(cow Cow) bool {
+	if f.Status != "" && cow.Health.Status != f.Status {
+		return false
+	}
+	if f.Zone != "" && cow.Location.Zone != f.Zone {
+		return false
+	}
+	if !f.UpdatedSince.IsZero() && cow.LastUpdated.Before(f.UpdatedSince) {
+		return false
+	}
+
+	battery := cow.Sensors.BatteryLevel
+	return battery >= f.BatteryMin && battery <= f.BatteryMax
+}
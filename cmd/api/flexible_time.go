@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// unixMillisCutoff separates Unix timestamps in seconds from those in milliseconds. In
+// seconds it's in the year 5138, and in milliseconds it's in March 1973, long before any
+// of our devices were built.
+const unixMillisCutoff = 100_000_000_000
+
+// FlexibleTime is a timestamp sent by a device, whose firmware may report it as an RFC
+// 3339 string, or as a number of seconds or milliseconds since the Unix epoch, either as a
+// JSON number or a string. A value which isn't in any of those formats doesn't fail the
+// decoding of the whole payload; it decodes to the zero time and is reported by Valid(),
+// so that it can be rejected like any other invalid field.
+type FlexibleTime struct {
+	time.Time
+	invalid bool
+}
+
+// UnmarshalJSON decodes a timestamp in any of the supported formats. A JSON null leaves
+// the time zero.
+func (t *FlexibleTime) UnmarshalJSON(data []byte) error {
+	*t = FlexibleTime{}
+
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	value := string(data)
+	if data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.invalid = true
+			return nil
+		}
+
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err == nil {
+			t.Time = parsed
+			return nil
+		}
+		value = s
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || unix < 0 {
+		t.invalid = true
+		return nil
+	}
+
+	if unix >= unixMillisCutoff {
+		t.Time = time.UnixMilli(unix).UTC()
+	} else {
+		t.Time = time.Unix(unix, 0).UTC()
+	}
+
+	return nil
+}
+
+// Valid reports whether the timestamp was in one of the supported formats, or absent.
+func (t FlexibleTime) Valid() bool {
+	return !t.invalid
+}
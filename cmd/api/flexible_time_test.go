@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFlexibleTimeUnmarshalJSON(t *testing.T) {
+	want := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		json      string
+		want      time.Time
+		wantValid bool
+	}{
+		{"RFC 3339", `"2024-03-01T12:30:00Z"`, want, true},
+		{"RFC 3339 with an offset", `"2024-03-01T14:30:00+02:00"`, want, true},
+		{"RFC 3339 with fractional seconds", `"2024-03-01T12:30:00.250Z"`, want.Add(250 * time.Millisecond), true},
+		{"seconds", `1709296200`, want, true},
+		{"seconds as a string", `"1709296200"`, want, true},
+		{"milliseconds", `1709296200250`, want.Add(250 * time.Millisecond), true},
+		{"milliseconds as a string", `"1709296200250"`, want.Add(250 * time.Millisecond), true},
+		{"last value in seconds", `99999999999`, time.Unix(99_999_999_999, 0), true},
+		{"first value in milliseconds", `100000000000`, time.UnixMilli(100_000_000_000), true},
+		{"epoch", `0`, time.Unix(0, 0), true},
+		{"null", `null`, time.Time{}, true},
+		{"negative", `-1`, time.Time{}, false},
+		{"fractional", `1709296200.5`, time.Time{}, false},
+		{"not a date", `"yesterday"`, time.Time{}, false},
+		{"empty string", `""`, time.Time{}, false},
+		{"RFC 3339 without a zone", `"2024-03-01T12:30:00"`, time.Time{}, false},
+		{"boolean", `true`, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input struct {
+				RecordedAt FlexibleTime `json:"recorded_at"`
+			}
+			if err := json.Unmarshal([]byte(`{"recorded_at":`+tt.json+`}`), &input); err != nil {
+				t.Fatalf("an invalid timestamp failed the whole payload: %v", err)
+			}
+
+			if got := input.RecordedAt.Valid(); got != tt.wantValid {
+				t.Errorf("got valid %t, want %t", got, tt.wantValid)
+			}
+			if got := input.RecordedAt.Time; !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlexibleTimeReused(t *testing.T) {
+	// Decoding into a value which already holds a timestamp replaces it entirely.
+	var ts FlexibleTime
+	if err := json.Unmarshal([]byte(`"yesterday"`), &ts); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`1709296200`), &ts); err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Valid() {
+		t.Error("a valid timestamp is still marked invalid")
+	}
+
+	if err := json.Unmarshal([]byte(`null`), &ts); err != nil {
+		t.Fatal(err)
+	}
+	if !ts.IsZero() {
+		t.Errorf("got %s after decoding null, want the zero time", ts.Time)
+	}
+}
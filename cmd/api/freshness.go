@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// StaleEntity is a cow or device which has stopped reporting, as listed by /api/stale.
+type StaleEntity struct {
+	Type               string    `json:"type"` // cow, robodog, drone
+	ID                 int       `json:"id"`
+	Name               string    `json:"name"`
+	LastUpdated        time.Time `json:"last_updated"`
+	SecondsSinceUpdate int64     `json:"seconds_since_update"`
+}
+
+// freshness returns how many whole seconds have passed between lastUpdated and now, and
+// whether that's longer than the stale threshold. The caller must hold the read lock.
+func (s *FarmStore) freshness(lastUpdated, now time.Time) (bool, int64) {
+	since := now.Sub(lastUpdated)
+	return since > s.staleAfter, int64(since / time.Second)
+}
+
+// StaleEntities returns every cow and device which hasn't been updated within the stale
+// threshold, the longest silent first. Deleted cows aren't expected to report, so they're
+// left out.
+func (s *FarmStore) StaleEntities(now time.Time) []StaleEntity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stale := []StaleEntity{}
+	add := func(entityType string, id int, name string, lastUpdated time.Time) {
+		if isStale, seconds := s.freshness(lastUpdated, now); isStale {
+			stale = append(stale, StaleEntity{
+				Type:               entityType,
+				ID:                 id,
+				Name:               name,
+				LastUpdated:        lastUpdated,
+				SecondsSinceUpdate: seconds,
+			})
+		}
+	}
+
+	for _, cow := range s.cows {
+		if !cow.Deleted() {
+			add("cow", cow.ID, cow.Name, cow.LastUpdated)
+		}
+	}
+	add("robodog", s.roboDog.ID, s.roboDog.Name, s.roboDog.LastUpdated)
+	add("drone", s.drone.ID, s.drone.Name, s.drone.LastUpdated)
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].LastUpdated.Before(stale[j].LastUpdated)
+	})
+
+	return stale
+}
+
+// listStaleHandler returns the cows and devices which have stopped reporting, so that
+// dead collars and stranded robots can be found quickly.
+func (app *application) listStaleHandler(w http.ResponseWriter, r *http.Request) {
+	stale := app.storeFor(r).StaleEntities(app.clock.Now())
+
+	env := envelope{
+		"stale":       stale,
+		"total":       len(stale),
+		"stale_after": app.config.staleAfter.String(),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "stale", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
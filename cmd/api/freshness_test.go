@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaleEntities(t *testing.T) {
+	// Start the clock at the real time, as the mock farm data was last updated just before.
+	clock := NewMockClock(time.Now())
+	s := newFarmStore(10, 0.3, 10*time.Minute, clock)
+
+	if stale := s.StaleEntities(clock.Now()); len(stale) != 0 {
+		t.Fatalf("got %d stale entities straight after seeding, want none", len(stale))
+	}
+
+	clock.Advance(10*time.Minute - time.Second)
+	if stale := s.StaleEntities(clock.Now()); len(stale) != 0 {
+		t.Fatalf("got %d stale entities just inside the threshold, want none", len(stale))
+	}
+
+	clock.Advance(2 * time.Second)
+	_, _, err := s.UpdateCowSensors(1, CowSensors{Temperature: 38.6, HeartRate: 65, Activity: "grazing", BatteryLevel: 80}, clock.Now())
+	if err != nil {
+		t.Fatalf("updating cow 1: %v", err)
+	}
+
+	stale := s.StaleEntities(clock.Now())
+	cows := len(s.Cows())
+	if want := cows - 1 + 2; len(stale) != want {
+		t.Fatalf("got %d stale entities, want every cow but cow 1, and both devices: %d", len(stale), want)
+	}
+	for _, entity := range stale {
+		if entity.Type == "cow" && entity.ID == 1 {
+			t.Errorf("cow 1 is stale straight after an update")
+		}
+		if entity.SecondsSinceUpdate < 601 {
+			t.Errorf("%s %d: got %d seconds since its update, want at least 601", entity.Type, entity.ID, entity.SecondsSinceUpdate)
+		}
+	}
+
+	cow, err := s.Cow(1)
+	if err != nil {
+		t.Fatalf("reading cow 1: %v", err)
+	}
+	if cow.Stale || cow.SecondsSinceUpdate != 0 {
+		t.Errorf("cow 1: got stale %t, %d seconds since its update; want fresh, 0", cow.Stale, cow.SecondsSinceUpdate)
+	}
+
+	clock.Advance(10*time.Minute + time.Second)
+	cow, err = s.Cow(1)
+	if err != nil {
+		t.Fatalf("reading cow 1: %v", err)
+	}
+	if !cow.Stale || cow.SecondsSinceUpdate != 601 {
+		t.Errorf("cow 1: got stale %t, %d seconds since its update; want stale, 601", cow.Stale, cow.SecondsSinceUpdate)
+	}
+}
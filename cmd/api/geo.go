@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// earthRadiusKm is the mean radius of the Earth, used for great-circle distances.
+const earthRadiusKm = 6371.0
+
+// farmCenter is the reference point of the farm. The geofence is a circle around it.
+var farmCenter = Location{
+	Latitude:  40.7128,
+	Longitude: -74.0060,
+}
+
+// haversineKm returns the great-circle distance in kilometres between two coordinates.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRadians := func(degrees float64) float64 {
+		return degrees * math.Pi / 180
+	}
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// DistanceKm returns the great-circle distance in kilometres to another location.
+func (l Location) DistanceKm(other Location) float64 {
+	return haversineKm(l.Latitude, l.Longitude, other.Latitude, other.Longitude)
+}
+
+// ValidateLocation checks that a location's coordinates are in range. Whether it needs a
+// zone is up to the caller, as devices in the air don't have one.
+func ValidateLocation(v *validator.Validator, location Location) {
+	v.Check(location.Latitude >= -90 && location.Latitude <= 90, "latitude", "must be between -90 and 90")
+	v.Check(location.Longitude >= -180 && location.Longitude <= 180, "longitude", "must be between -180 and 180")
+}
+
+// withinGeofence reports whether a coordinate lies within radiusKm of the farm center.
+func withinGeofence(latitude, longitude, radiusKm float64) bool {
+	return haversineKm(farmCenter.Latitude, farmCenter.Longitude, latitude, longitude) <= radiusKm
+}
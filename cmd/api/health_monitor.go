@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// notifyTimeout bounds how long sending a single alert to every notifier may take,
+// retries included, so that an unresponsive channel can't hold a background goroutine
+// (and graceful shutdown) indefinitely.
+const notifyTimeout = 30 * time.Second
+
+// monitorHealth evaluates every cow on every farm against the alert thresholds each
+// health-check interval and keeps the farms' alert registries up to date. It returns as soon as ctx is
+// cancelled, so it should be launched with app.background() to be waited on at shutdown.
+func (app *application) monitorHealth(ctx context.Context) {
+	log.InfoWithProperties("Health monitor started", map[string]string{
+		"interval": app.config.healthCheckInterval.String(),
+	})
+
+	ticker := time.NewTicker(app.config.healthCheckInterval)
+	defer ticker.Stop()
+
+	// Evaluate the herd straight away rather than leaving the registry empty until the
+	// first tick.
+	app.evaluateHerdHealth()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Health monitor stopped")
+			return
+		case <-ticker.C:
+			app.evaluateHerdHealth()
+		}
+	}
+}
+
+// evaluateHerdHealth runs the alert rules over each farm's herd and drone, and updates the
+// herd metrics with the cows of every farm.
+func (app *application) evaluateHerdHealth() {
+	now := app.clock.Now()
+
+	var cows []Cow
+	for _, farm := range app.farms.All() {
+		cows = append(cows, app.evaluateFarmHealth(farm, now)...)
+	}
+	app.prom.observeHerd(cows)
+}
+
+// evaluateFarmHealth runs the alert rules over a farm's herd and the drone's readings, and
+// logs any alerts which have been raised or resolved since the previous evaluation. It
+// returns the cows it evaluated.
+func (app *application) evaluateFarmHealth(farm *Farm, now time.Time) []Cow {
+	cows := farm.store.Cows()
+
+	var detected []Alert
+	for _, cow := range cows {
+		detected = append(detected, app.detectAlerts(farm, cow, now)...)
+	}
+	drone := farm.store.Drone()
+	for _, alert := range detectDroneAlerts(drone, app.config.aqiWarningThreshold, app.config.aqiCriticalThreshold, now) {
+		alert.Farm = farm.ID
+		detected = append(detected, alert)
+	}
+	detected = append(detected, app.heldAlerts(farm.alerts.Active(), detected, cows, drone)...)
+
+	raised, resolved := farm.alerts.Reconcile(detected, now)
+
+	for _, alert := range raised {
+		log.WarnWithProperties("Alert raised", alertLogProperties(alert))
+
+		if alert.Severity == "critical" {
+			app.notify(alert)
+		}
+	}
+	for _, alert := range resolved {
+		log.InfoWithProperties("Alert resolved", alertLogProperties(alert))
+	}
+
+	return cows
+}
+
+// detectAlerts runs every alert rule over the cow's latest readings and sensor history,
+// returning the alerts for the given farm. It's the single definition of whether a cow is
+// alerting, shared by the health monitor and the handlers.
+func (app *application) detectAlerts(farm *Farm, cow Cow, now time.Time) []Alert {
+	alerts := detectCowAlerts(cow, now)
+
+	history, err := farm.store.CowHistory(cow.ID)
+	if err == nil {
+		if alert, ok := detectActivityAnomaly(cow, history, app.config.restingAnomalyDuration, now); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	for i := range alerts {
+		alerts[i].Farm = farm.ID
+	}
+	return alerts
+}
+
+// notify sends an alert to the configured notifiers in the background, so that slow or
+// failing channels don't hold up alert detection. Repeat notifications for the same cow or
+// drone and alert type within the notification cooldown are suppressed.
+func (app *application) notify(alert Alert) {
+	if app.notifier == nil || !app.notifyThrottle.Allow(alert, app.clock.Now()) {
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+
+		err := app.notifier.Notify(ctx, alert)
+		if err != nil {
+			log.ErrorWithProperties(err, alertLogProperties(alert))
+		}
+	})
+}
+
+// alertLogProperties returns the properties used when logging an alert.
+func alertLogProperties(alert Alert) map[string]string {
+	properties := map[string]string{
+		"farm":     alert.Farm,
+		"type":     alert.Type,
+		"severity": alert.Severity,
+		"source":   alert.Source,
+		"value":    fmt.Sprintf("%g", alert.Value),
+	}
+	if alert.Source == alertSourceDrone {
+		properties["drone_id"] = fmt.Sprintf("%d", alert.DroneID)
+		properties["drone_name"] = alert.DroneName
+	} else {
+		properties["cow_id"] = fmt.Sprintf("%d", alert.CowID)
+		properties["cow_name"] = alert.CowName
+	}
+
+	return properties
+}
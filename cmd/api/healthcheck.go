@@ -13,7 +13,7 @@ func (app *application) healthcheckHandler(writer http.ResponseWriter, request *
 		},
 	}
 
-	err := app.writeJSON(writer, http.StatusOK, env, nil)
+	err := app.writeJSON(writer, request, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
@@ -2,16 +2,26 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"image"
+	_ "image/jpeg" // register the decoders used by processImageData
+	_ "image/png"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	log "mooveit-backend.mooveit.com/internal/jsonlog"
@@ -43,18 +53,55 @@ func (app *application) readIDParam(request *http.Request) (int64, error) {
 	return id, nil
 }
 
-func (app *application) writeJSON(writer http.ResponseWriter, status int, data any, headers http.Header) error {
-	// Encode the data to JSON, returning the error if there was one.
-	// Or use the json.MarshalIndent() function so that whitespace is added to the encoded
-	// JSON. json.MarshalIndent(data, "", "\t") - here we use no line prefix ("") and tab indents ("\t") for each element.
+func (app *application) writeJSON(writer http.ResponseWriter, request *http.Request, status int, data any, headers http.Header) error {
+	// Mark the response to a dry run, so that it can't be mistaken for a real change.
+	if env, ok := data.(envelope); ok && contextIsDryRun(request) {
+		marked := maps.Clone(env)
+		marked["dry_run"] = true
+		data = marked
+	}
+
+	// Encode the data to JSON, returning the error if there was one. Keys are snake_case
+	// like our struct tags, unless the client wants camelCase, in which case they're
+	// rewritten after encoding.
 	js, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
+	if app.wantsCamelCase(request) {
+		js, err = camelCaseJSON(js)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Responses are compact by default to keep them small, but if the client asks for
+	// ?pretty=true we use the json.Indent() function so that whitespace is added to the
+	// encoded JSON. Here we use no line prefix ("") and tab indents ("\t") for each
+	// element.
+	if app.wantsPrettyJSON(request) {
+		var indented bytes.Buffer
+		err = json.Indent(&indented, js, "", "\t")
+		if err != nil {
+			return err
+		}
+		js = indented.Bytes()
+	}
 
 	// Append a newline to make it easier to view in terminal applications.
 	js = append(js, '\n')
 
+	// Tag successful reads with a strong ETag derived from the body, so that pollers
+	// can tell whether a resource has changed, e.g. with a cheap HEAD request. A client
+	// whose If-None-Match already names it gets 304 Not Modified instead of the body.
+	unchanged := false
+	if status == http.StatusOK && (request.Method == http.MethodGet || request.Method == http.MethodHead) {
+		sum := sha256.Sum256(js)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		writer.Header().Set("ETag", etag)
+		unchanged = etagMatches(request.Header.Get("If-None-Match"), etag)
+	}
+
 	// At this point, we know that we won't encounter any more errors before writing the
 	// response, so it's safe to add any headers that we want to include. We loop
 	// through the header map and add each header to the http.ResponseWriter header map.
@@ -64,45 +111,119 @@ func (app *application) writeJSON(writer http.ResponseWriter, status int, data a
 		writer.Header()[key] = value
 	}
 
+	if unchanged {
+		writer.Header().Add("Vary", "Accept")
+		writer.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
 	// Set the "Content-Type: application/json" header on the response. If you forget to
 	// this, Go will default to sending a "Content-Type: text/plain; charset=utf-8"
 	// header instead.
 	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Add("Vary", "Accept")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(js)))
 	writer.WriteHeader(status)
-	writer.Write(js)
+
+	// A HEAD response carries the same headers as the GET response, but no body.
+	if request.Method != http.MethodHead {
+		writer.Write(js)
+	}
 
 	return nil
 }
 
-// serverErrorResponse sends a JSON-formatted error message to the client with the given
-// status code, and logs the error using our custom logger at the ERROR level.
-func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	log.ErrorWithProperties(err, map[string]string{
-		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
-
-	message := "The server encountered a problem and could not process your request"
-	env := envelope{"error": message}
-
-	// Write the response using the writeJSON() helper. If this happens to return an
-	// error then log it, and exit. We don't want to send a response after this point
-	// as we will already have sent the HTTP status code to the client.
-	err = app.writeJSON(w, http.StatusInternalServerError, env, nil)
-	if err != nil {
-		log.Error("%s", err)
+// writeEnvelope writes a response whose primary resource is held under key in env, e.g.
+// "cows" or "farm_state". With -envelope=data the resource is moved under a uniform "data"
+// key instead, which makes generic client code easier to write; any other top-level
+// fields, such as totals and pagination metadata, are left as they are.
+func (app *application) writeEnvelope(w http.ResponseWriter, r *http.Request, status int, key string, env envelope, headers http.Header) error {
+	if app.config.envelopeStyle == "data" {
+		uniform := make(envelope, len(env))
+		for k, v := range env {
+			if k == key {
+				k = "data"
+			}
+			uniform[k] = v
+		}
+		env = uniform
 	}
+
+	return app.writeJSON(w, r, status, env, headers)
 }
 
-// notFoundResponse sends a JSON-formatted 404 Not Found response to the client
-func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "The requested resource could not be found"
-	env := envelope{"error": message}
+// envelopeKey returns the key a response's primary resource is held under, for responses
+// which are written without writeEnvelope: key itself, or "data" with -envelope=data.
+func (app *application) envelopeKey(key string) string {
+	if app.config.envelopeStyle == "data" {
+		return "data"
+	}
+	return key
+}
 
-	err := app.writeJSON(w, http.StatusNotFound, env, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+// notModified sets the Last-Modified header of a GET or HEAD response to lastModified. If
+// the client's cached copy, as dated by its If-Modified-Since header, is still current, it
+// responds with 304 Not Modified and returns true, and the caller has nothing more to do.
+// As in RFC 9110, If-Modified-Since is ignored when the request carries If-None-Match:
+// that's checked against the response's ETag by writeJSON instead.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// HTTP dates only have whole seconds, so compare at that precision.
+	lastModified = lastModified.Truncate(time.Second)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether the If-None-Match header value ifNoneMatch names etag,
+// either directly, as a weak tag or with "*". As RFC 9110 requires for If-None-Match, tags
+// are compared weakly, so W/"x" matches "x".
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
 	}
+	return false
+}
+
+// requestURL returns the absolute URL of the request as the client made it, for building
+// links to other pages of a resource. The host is taken from the Host header, and the
+// scheme from the connection, or from X-Forwarded-Proto when a trusted proxy sent it.
+func (app *application) requestURL(r *http.Request) *url.URL {
+	u := *r.URL
+	u.Host = r.Host
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	} else if peer, ok := parseIP(r.RemoteAddr); ok && isTrustedProxy(peer, app.trustedProxies) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			u.Scheme = proto
+		}
+	}
+
+	return &u
+}
+
+// wantsPrettyJSON reports whether the client asked for an indented response with the
+// ?pretty=true query string parameter, which is handy when debugging with curl.
+func (app *application) wantsPrettyJSON(r *http.Request) bool {
+	pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	return err == nil && pretty
 }
 
 // For a public-facing API, the error messages themselves aren't ideal.
@@ -115,9 +236,35 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request)
 //	   "error": "invalid character '}' looking for beginning of object key string"
 //	}
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, destination any) error {
-	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	return app.readJSONWithLimit(w, r, destination, app.config.maxBodyBytes)
+}
+
+// readJSONWithLimit is readJSON with a per-route limit on the size of the request body,
+// for endpoints whose payloads are much larger or smaller than the -max-body-bytes default.
+func (app *application) readJSONWithLimit(w http.ResponseWriter, r *http.Request, destination any, maxBytes int64) error {
+	// Devices on metered connections may gzip their uploads. Wrap the body in a
+	// gzip.Reader so that the decoder sees the decompressed JSON.
+	body := r.Body
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("body must not be empty")
+			}
+			return errors.New("body contains malformed gzip data")
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return fmt.Errorf("body has unsupported Content-Encoding %q", r.Header.Get("Content-Encoding"))
+	}
+
+	// Use http.MaxBytesReader() to limit the size of the request body. For gzipped
+	// bodies the limit applies to the decompressed stream, so a small zip bomb can't
+	// expand into an unbounded amount of memory.
+	r.Body = http.MaxBytesReader(w, body, maxBytes)
 
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
 	// before decoding. This means that if the JSON from the client now includes any
@@ -133,6 +280,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, destina
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
 		var maxBytesError *http.MaxBytesError
+		var corruptInputError flate.CorruptInputError
 
 		switch {
 		// Use the errors.As() function to check whether the error has the type
@@ -141,6 +289,11 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, destina
 		case errors.As(err, &syntaxError):
 			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
 
+		// A gzipped body which fails to decompress part-way through returns an error
+		// from the gzip or flate packages rather than the decoder.
+		case errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum), errors.As(err, &corruptInputError):
+			return errors.New("body contains malformed gzip data")
+
 		// In some circumstances Decode() may also return an io.ErrUnexpectedEOF error
 		// for syntax errors in the JSON. So we check for this using errors.Is() and
 		// return a generic error message. There is an open issue regarding this at
@@ -176,7 +329,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, destina
 
 		// Use the errors.As() function to check whether the error has the type
 		// *http.MaxBytesError. If it does, then it means the request body exceeded our
-		// size limit of 1MB and we return a clear error message.
+		// size limit and we return a clear error message.
 		case errors.As(err, &maxBytesError):
 			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
 
@@ -209,7 +362,22 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, destina
 	return nil
 }
 
-func processImageData(data any) error {
+// MediaTypeError is returned by processImageData when the decoded image's actual content
+// type, as sniffed from its bytes, isn't one of the accepted types. Handlers should
+// respond with unsupportedMediaTypeResponse().
+type MediaTypeError struct {
+	Detected string
+	Allowed  []string
+}
+
+func (e *MediaTypeError) Error() string {
+	return fmt.Sprintf("image content is %s, not %s", e.Detected, strings.Join(e.Allowed, " or "))
+}
+
+// processImageData decodes the base64 "image" field of data and checks that it's an image
+// of one of the allowed MIME types (set with -upload-allowed-types). The type is sniffed
+// from the content itself, so a client can't get other data through by mislabelling it.
+func processImageData(data any, allowedTypes []string) error {
 	// Type assert the data to access the image field
 	imageData, ok := data.(map[string]interface{})
 	if !ok {
@@ -228,6 +396,14 @@ func processImageData(data any) error {
 		return fmt.Errorf("error decoding base64 image: %v", err)
 	}
 
+	// Reject anything which isn't an accepted image type before trying to decode it.
+	// DetectContentType() only looks at the first 512 bytes, and never fails: unknown
+	// content is reported as application/octet-stream.
+	contentType, _, _ := strings.Cut(http.DetectContentType(imgData), ";")
+	if !validator.PermittedValue(contentType, allowedTypes...) {
+		return &MediaTypeError{Detected: contentType, Allowed: allowedTypes}
+	}
+
 	// You can now process the image data as needed
 	// For example, you might want to validate the image format, resize it, etc.
 	// Here we'll just check if it's a valid image
@@ -307,14 +483,14 @@ func (app *application) background(fn func()) {
 
 	// Launch a background goroutine.
 	go func() {
-		// Run a deferred function which uses recover() to catch any panic, and log an
-		// error message instead of terminating the application.
+		// Run a deferred function which uses recover() to catch any panic, and hand it to
+		// the panic handler instead of terminating the application.
 		defer func() {
 			// Use defer to decrement the WaitGroup counter before the goroutine returns.
 			defer app.wg.Done()
 
 			if err := recover(); err != nil {
-				log.Error("%s", err)
+				app.backgroundPanic(err, debug.Stack())
 			}
 		}()
 
@@ -322,3 +498,89 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// backgroundPanicCount publishes the number of panics recovered in background goroutines in
+// the expvar handler.
+var backgroundPanicCount = expvar.NewInt("background_panics")
+
+// backgroundPanic passes a panic recovered in a background goroutine, along with the stack
+// of the goroutine which panicked, to app.panicHandler, or logs it if there's no handler.
+func (app *application) backgroundPanic(recovered any, stack []byte) {
+	if app.panicHandler != nil {
+		app.panicHandler(recovered, stack)
+		return
+	}
+	logPanic(recovered, stack)
+}
+
+// logPanic is the default background panic handler, which logs the panic at ERROR level.
+// The log entry's trace is the stack of the goroutine which panicked, so the stack isn't
+// logged a second time.
+func logPanic(recovered any, stack []byte) {
+	log.Error("%s", recovered)
+}
+
+// countBackgroundPanic is the background panic handler the server uses. It logs the panic
+// as logPanic does, and counts it in the expvar and Prometheus metrics so that a failing
+// background task can be alerted on.
+func (app *application) countBackgroundPanic(recovered any, stack []byte) {
+	logPanic(recovered, stack)
+	backgroundPanicCount.Add(1)
+	app.prom.backgroundPanics.Inc()
+}
+
+// The readTime() helper reads an RFC 3339 timestamp from the query string. If no matching
+// key could be found it returns the zero time. If the value couldn't be parsed, then we
+// record an error message in the provided Validator instance.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	str := qs.Get(key)
+	if str == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp, e.g. 2024-01-15T10:30:00Z")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// The readDuration() helper reads a duration such as "5m" or "1h" from the query string,
+// using time.ParseDuration(). If no matching key could be found it returns the provided
+// default value. If the value couldn't be parsed, then we record an error message in the
+// provided Validator instance.
+func (app *application) readDuration(qs url.Values, key string, defaultValue time.Duration, v *validator.Validator) time.Duration {
+	str := qs.Get(key)
+	if str == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		v.AddError(key, "must be a duration, e.g. 5m or 1h")
+		return defaultValue
+	}
+
+	return d
+}
+
+// The readBool() helper reads a boolean value such as "true" or "false" from the query
+// string. If no matching key could be found it returns the provided default value. If
+// the value couldn't be parsed, then we record an error message in the provided
+// Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	str := qs.Get(key)
+	if str == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+
+	return b
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"absent", ``, false},
+		{"same", `"abc"`, true},
+		{"different", `"abd"`, false},
+		{"weak", `W/"abc"`, true},
+		{"in a list", `"x", "abc"`, true},
+		{"not in a list", `"x", "y"`, false},
+		{"any", `*`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.ifNoneMatch, `"abc"`); got != tt.want {
+				t.Errorf("etagMatches(%q) = %t, want %t", tt.ifNoneMatch, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFarmStateConditionalGet(t *testing.T) {
+	app := newTestApplication(t)
+	store, clock := newTestStore(t)
+	app.farms = newFarmRegistry([]string{defaultFarmID}, func() *FarmStore { return store }, 10)
+
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/api/farm/state", nil)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		rr := httptest.NewRecorder()
+		app.getFarmStateHandler(rr, r)
+		return rr
+	}
+
+	first := get(nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", first.Code, http.StatusOK)
+	}
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q, want both set", etag, lastModified)
+	}
+
+	// Browsers and proxies send both validators once they've seen both.
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"matching ETag and date", map[string]string{"If-None-Match": etag, "If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"matching ETag only", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"other ETag and matching date", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}, http.StatusOK},
+		{"matching date only", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := get(tt.headers)
+			if rr.Code != tt.want {
+				t.Fatalf("got status %d, want %d", rr.Code, tt.want)
+			}
+			if tt.want == http.StatusNotModified {
+				if rr.Body.Len() != 0 {
+					t.Errorf("got a body of %d bytes with 304", rr.Body.Len())
+				}
+				if _, ok := tt.headers["If-None-Match"]; ok && rr.Header().Get("ETag") != etag {
+					t.Errorf("got ETag %q with 304, want %q", rr.Header().Get("ETag"), etag)
+				}
+			}
+		})
+	}
+
+	// Once the farm changes, neither validator matches any more.
+	clock.Advance(time.Minute)
+	if _, _, err := store.UpdateCowSensors(1, CowSensors{Temperature: 40.5, HeartRate: 90, Activity: "resting", BatteryLevel: 80}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if rr := get(map[string]string{"If-None-Match": etag, "If-Modified-Since": lastModified}); rr.Code != http.StatusOK {
+		t.Errorf("got status %d after a change, want %d", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// Herd is a group of cows which are managed together, such as a breeding group or the
+// cows sharing a pasture. A cow belongs to at most one herd, and every member must be in
+// the herd's zone.
+type Herd struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Zone   string `json:"zone"`
+	CowIDs []int  `json:"cow_ids"`
+}
+
+// herdInput is the payload accepted when creating or updating a herd. The fields are
+// pointers so that an update can tell a field which was left out from one set to its
+// zero value.
+type herdInput struct {
+	Name   *string `json:"name"`
+	Zone   *string `json:"zone"`
+	CowIDs *[]int  `json:"cow_ids"`
+}
+
+// ValidateHerd checks the fields of a herd. Whether its members exist and are free to
+// join is checked by the store.
+func ValidateHerd(v *validator.Validator, herd Herd) {
+	v.Check(herd.Name != "", "name", "must be provided")
+	v.Check(len(herd.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(herd.Zone != "", "zone", "must be provided")
+
+	seen := make(map[int]bool, len(herd.CowIDs))
+	for i, id := range herd.CowIDs {
+		key := fmt.Sprintf("cow_ids[%d]", i)
+		v.Check(id > 0, key, "must be a positive integer")
+		v.Check(!seen[id], key, "must not be listed more than once")
+		seen[id] = true
+	}
+}
+
+// HerdMembersError is returned when a herd's members can't join it. Fields maps each
+// offending position in CowIDs, keyed like "cow_ids[2]", to the reason.
+type HerdMembersError struct {
+	Fields map[string]string
+}
+
+func (e *HerdMembersError) Error() string {
+	return fmt.Sprintf("%d cows can't join the herd", len(e.Fields))
+}
+
+// copyHerd returns a copy of the herd which doesn't share its member slice.
+func copyHerd(herd Herd) Herd {
+	herd.CowIDs = slices.Clone(herd.CowIDs)
+	if herd.CowIDs == nil {
+		herd.CowIDs = []int{}
+	}
+	return herd
+}
+
+// Herds returns a copy of every herd, ordered by ID.
+func (s *FarmStore) Herds() []Herd {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	herds := make([]Herd, len(s.herds))
+	for i, herd := range s.herds {
+		herds[i] = copyHerd(herd)
+	}
+
+	return herds
+}
+
+// Herd returns the herd with the given ID, or ErrRecordNotFound if there's no such herd.
+func (s *FarmStore) Herd(id int) (Herd, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.herdIndex(id)
+	if i == -1 {
+		return Herd{}, ErrRecordNotFound
+	}
+
+	return copyHerd(s.herds[i]), nil
+}
+
+// InsertHerd adds the herd to the store, assigning it the next sequential ID and making
+// its cows members. It returns a *HerdMembersError if any of the cows can't join.
+func (s *FarmStore) InsertHerd(herd Herd) (Herd, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkHerdMembers(herd)
+	if err != nil {
+		return Herd{}, err
+	}
+
+	herd.ID = s.nextHerdID()
+
+	herd = copyHerd(herd)
+	s.herds = append(s.herds, herd)
+	s.setHerdMembers(herd.ID, nil, herd.CowIDs)
+	s.touch()
+
+	return copyHerd(herd), nil
+}
+
+// UpdateHerd replaces the herd with the same ID, returning the herd as it was before and
+// after the update. Cows dropped from the herd are no longer members of any herd. It
+// returns ErrRecordNotFound if there's no such herd, and a *HerdMembersError if any of
+// the cows can't join.
+func (s *FarmStore) UpdateHerd(herd Herd) (Herd, Herd, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.herdIndex(herd.ID)
+	if i == -1 {
+		return Herd{}, Herd{}, ErrRecordNotFound
+	}
+
+	err := s.checkHerdMembers(herd)
+	if err != nil {
+		return Herd{}, Herd{}, err
+	}
+
+	before := copyHerd(s.herds[i])
+	s.herds[i] = copyHerd(herd)
+	s.setHerdMembers(herd.ID, before.CowIDs, herd.CowIDs)
+	s.touch()
+
+	return before, copyHerd(s.herds[i]), nil
+}
+
+// DeleteHerd removes the herd with the given ID, returning the deleted herd. Its cows are
+// kept, but no longer belong to a herd. It returns ErrRecordNotFound if there's no such
+// herd.
+func (s *FarmStore) DeleteHerd(id int) (Herd, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.herdIndex(id)
+	if i == -1 {
+		return Herd{}, ErrRecordNotFound
+	}
+
+	herd := s.herds[i]
+	s.herds = slices.Delete(s.herds, i, i+1)
+	s.setHerdMembers(id, herd.CowIDs, nil)
+	s.touch()
+
+	return copyHerd(herd), nil
+}
+
+// HerdCows returns the herd with the given ID along with a copy of its members which
+// haven't been deleted, or ErrRecordNotFound if there's no such herd.
+func (s *FarmStore) HerdCows(id int) (Herd, []Cow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.herdIndex(id)
+	if i == -1 {
+		return Herd{}, nil, ErrRecordNotFound
+	}
+
+	cows := []Cow{}
+	for _, cow := range s.cows {
+		if cow.HerdID != nil && *cow.HerdID == id && !cow.Deleted() {
+			cows = append(cows, s.withDerivedHealth(cow))
+		}
+	}
+
+	return copyHerd(s.herds[i]), cows, nil
+}
+
+// herdIndex returns the position of the herd with the given ID in s.herds, or -1 if
+// there's no such herd. The caller must hold the lock.
+func (s *FarmStore) herdIndex(id int) int {
+	return slices.IndexFunc(s.herds, func(herd Herd) bool {
+		return herd.ID == id
+	})
+}
+
+// herdZone returns the zone of the herd with the given ID, or false if herdID is nil or
+// there's no such herd. The caller must hold the lock.
+func (s *FarmStore) herdZone(herdID *int) (string, bool) {
+	if herdID == nil {
+		return "", false
+	}
+	i := s.herdIndex(*herdID)
+	if i == -1 {
+		return "", false
+	}
+	return s.herds[i].Zone, true
+}
+
+// checkHerdMembers checks that each of the herd's cows exists, is in the herd's zone and
+// doesn't already belong to another herd. The caller must hold the lock.
+func (s *FarmStore) checkHerdMembers(herd Herd) error {
+	fields := make(map[string]string)
+
+	for i, id := range herd.CowIDs {
+		key := fmt.Sprintf("cow_ids[%d]", i)
+
+		// A deleted cow keeps its membership in case it's restored, so it may stay in
+		// its herd but can't join a new one.
+		j := s.cowIndex(id)
+		inHerd := j != -1 && s.cows[j].HerdID != nil && *s.cows[j].HerdID == herd.ID
+		switch {
+		case j == -1 || (s.cows[j].Deleted() && !inHerd):
+			fields[key] = fmt.Sprintf("cow %d does not exist", id)
+		case s.cows[j].Deleted():
+		case s.cows[j].HerdID != nil && !inHerd:
+			fields[key] = fmt.Sprintf("cow %d already belongs to herd %d", id, *s.cows[j].HerdID)
+		case s.cows[j].Location.Zone != herd.Zone:
+			fields[key] = fmt.Sprintf("cow %d is in zone %q, not %q", id, s.cows[j].Location.Zone, herd.Zone)
+		}
+	}
+
+	if len(fields) > 0 {
+		return &HerdMembersError{Fields: fields}
+	}
+	return nil
+}
+
+// setHerdMembers moves the herd's membership from the cows in previous to those in
+// current, touching each cow whose membership changes. The caller must hold the write
+// lock.
+func (s *FarmStore) setHerdMembers(herdID int, previous, current []int) {
+	for i := range s.cows {
+		cow := &s.cows[i]
+		wasMember := cow.HerdID != nil && *cow.HerdID == herdID
+		switch {
+		case slices.Contains(current, cow.ID):
+			id := herdID
+			cow.HerdID = &id
+			if !wasMember {
+				s.touchCow(i)
+			}
+		case slices.Contains(previous, cow.ID):
+			cow.HerdID = nil
+			s.touchCow(i)
+		}
+	}
+}
+
+// herdAuditSummary returns the fields of a herd recorded in the audit log.
+func herdAuditSummary(herd Herd) map[string]any {
+	return map[string]any{
+		"name":    herd.Name,
+		"zone":    herd.Zone,
+		"cow_ids": herd.CowIDs,
+	}
+}
+
+// herdWriteErrorResponse sends the response for an error from inserting or updating a
+// herd.
+func (app *application) herdWriteErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var membersErr *HerdMembersError
+	switch {
+	case errors.As(err, &membersErr):
+		app.failedValidationResponse(w, r, membersErr.Fields)
+	case errors.Is(err, ErrRecordNotFound):
+		app.herdNotFoundResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listHerdsHandler returns every herd
+func (app *application) listHerdsHandler(w http.ResponseWriter, r *http.Request) {
+	herds := app.storeFor(r).Herds()
+
+	env := envelope{
+		"herds": herds,
+		"total": len(herds),
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "herds", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createHerdHandler creates a herd from a name, zone and optional list of member cows
+func (app *application) createHerdHandler(w http.ResponseWriter, r *http.Request) {
+	var input herdInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var herd Herd
+	if input.Name != nil {
+		herd.Name = *input.Name
+	}
+	if input.Zone != nil {
+		herd.Zone = *input.Zone
+	}
+	if input.CowIDs != nil {
+		herd.CowIDs = *input.CowIDs
+	}
+
+	v := validator.New()
+	if ValidateHerd(v, herd); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	herd, err = app.storeFor(r).InsertHerd(herd)
+	if err != nil {
+		app.herdWriteErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "create", "herd", herd.ID, nil, herdAuditSummary(herd))
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/herds/%d", herd.ID))
+
+	err = app.writeEnvelope(w, r, http.StatusCreated, "herd", envelope{"herd": herd}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getHerdHandler returns a specific herd by ID, with aggregate statistics for its members.
+// As for the herd statistics, only active cows are included unless ?include_inactive=true.
+func (app *application) getHerdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	includeInactive := app.readBool(r.URL.Query(), "include_inactive", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	herd, cows, err := app.storeFor(r).HerdCows(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.herdNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	statsCows := cows
+	if !includeInactive {
+		statsCows = activeCows(cows)
+	}
+
+	env := envelope{
+		"herd":  herd,
+		"stats": herdStats(statsCows),
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "herd", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateHerdHandler partially updates a herd. Fields left out of the body are unchanged,
+// and cow_ids replaces the herd's whole membership.
+func (app *application) updateHerdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	herd, err := app.storeFor(r).Herd(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.herdNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input herdInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		herd.Name = *input.Name
+	}
+	if input.Zone != nil {
+		herd.Zone = *input.Zone
+	}
+	if input.CowIDs != nil {
+		herd.CowIDs = *input.CowIDs
+	}
+
+	v := validator.New()
+	if ValidateHerd(v, herd); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before, herd, err := app.storeFor(r).UpdateHerd(herd)
+	if err != nil {
+		app.herdWriteErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, "update", "herd", herd.ID, herdAuditSummary(before), herdAuditSummary(herd))
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "herd", envelope{"herd": herd}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteHerdHandler deletes a herd. Its cows are kept, but no longer belong to a herd.
+func (app *application) deleteHerdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	herd, err := app.storeFor(r).DeleteHerd(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.herdNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, "delete", "herd", herd.ID, herdAuditSummary(herd), nil)
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "herd successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listHerdCowsHandler returns the cows in a herd, with aggregate statistics for the active
+// ones, or for all of them with ?include_inactive=true
+func (app *application) listHerdCowsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	includeInactive := app.readBool(r.URL.Query(), "include_inactive", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	herd, cows, err := app.storeFor(r).HerdCows(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.herdNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	statsCows := cows
+	if !includeInactive {
+		statsCows = activeCows(cows)
+	}
+
+	env := envelope{
+		"herd_id": herd.ID,
+		"cows":    cows,
+		"total":   len(cows),
+		"stats":   herdStats(statsCows),
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "cows", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// HistoryFilters holds the query string filters accepted by the sensor history endpoint.
+// A zero From or To leaves that end of the range open, and a zero Interval returns the raw
+// readings rather than aggregating them.
+type HistoryFilters struct {
+	From     time.Time
+	To       time.Time
+	Interval time.Duration
+}
+
+// HistoryBucket aggregates the sensor readings which fall in a fixed window of time.
+type HistoryBucket struct {
+	Start              time.Time `json:"start"`
+	End                time.Time `json:"end"`
+	Readings           int       `json:"readings"`
+	AverageTemperature float64   `json:"average_temperature"`
+	AverageHeartRate   float64   `json:"average_heart_rate"`
+	MinBatteryLevel    int       `json:"min_battery_level"`
+}
+
+// readHistoryFilters reads the history filters from the query string, recording any
+// problems in the provided Validator instance.
+func (app *application) readHistoryFilters(qs url.Values, v *validator.Validator) HistoryFilters {
+	return HistoryFilters{
+		From:     app.readTime(qs, "from", v),
+		To:       app.readTime(qs, "to", v),
+		Interval: app.readDuration(qs, "interval", 0, v),
+	}
+}
+
+// ValidateHistoryFilters checks that the time range is consistent.
+func ValidateHistoryFilters(v *validator.Validator, f HistoryFilters) {
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(f.From.Before(f.To), "from", "must be before to")
+	}
+	v.Check(f.Interval >= 0, "interval", "must not be negative")
+	v.Check(f.Interval == 0 || f.Interval >= time.Second, "interval", "must be at least 1s")
+}
+
+// Matches reports whether a reading falls within the time range. Both ends are inclusive.
+func (f HistoryFilters) Matches(reading CowSensorReading) bool {
+	return f.Contains(reading.RecordedAt)
+}
+
+// Contains reports whether t falls within the time range. Both ends are inclusive.
+func (f HistoryFilters) Contains(t time.Time) bool {
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && t.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// downsample buckets chronologically ordered readings into fixed windows of the given
+// interval, aligned with time.Truncate() so that bucket boundaries are stable between
+// requests. Windows without any readings are omitted.
+func downsample(readings []CowSensorReading, interval time.Duration) []HistoryBucket {
+	buckets := []HistoryBucket{}
+
+	var temperatureSum, heartRateSum float64
+	flush := func() {
+		last := &buckets[len(buckets)-1]
+		count := float64(last.Readings)
+		last.AverageTemperature = temperatureSum / count
+		last.AverageHeartRate = heartRateSum / count
+		temperatureSum, heartRateSum = 0, 0
+	}
+
+	for _, reading := range readings {
+		start := reading.RecordedAt.Truncate(interval)
+
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Start.Equal(start) {
+			if len(buckets) > 0 {
+				flush()
+			}
+			buckets = append(buckets, HistoryBucket{
+				Start:           start,
+				End:             start.Add(interval),
+				MinBatteryLevel: reading.Sensors.BatteryLevel,
+			})
+		}
+
+		bucket := &buckets[len(buckets)-1]
+		bucket.Readings++
+		temperatureSum += reading.Sensors.Temperature
+		heartRateSum += float64(reading.Sensors.HeartRate)
+		if reading.Sensors.BatteryLevel < bucket.MinBatteryLevel {
+			bucket.MinBatteryLevel = reading.Sensors.BatteryLevel
+		}
+	}
+
+	if len(buckets) > 0 {
+		flush()
+	}
+
+	return buckets
+}
+
+// getCowHistoryHandler returns a cow's recorded sensor readings, oldest first, optionally
+// limited to a time range and aggregated into fixed intervals
+func (app *application) getCowHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	filters := app.readHistoryFilters(r.URL.Query(), v)
+	ValidateHistoryFilters(v, filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	history, err := app.storeFor(r).CowHistory(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Initialise the slice so that an empty range is returned as [] rather than null.
+	readings := []CowSensorReading{}
+	for _, reading := range history {
+		if filters.Matches(reading) {
+			readings = append(readings, reading)
+		}
+	}
+
+	key := "history"
+	env := envelope{
+		"cow_id":  id,
+		"history": readings,
+		"total":   len(readings),
+	}
+	if filters.Interval > 0 {
+		buckets := downsample(readings, filters.Interval)
+		key = "buckets"
+		env = envelope{
+			"cow_id":   id,
+			"interval": filters.Interval.String(),
+			"buckets":  buckets,
+			"total":    len(buckets),
+		}
+	}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, key, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
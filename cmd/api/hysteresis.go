@@ -0,0 +1,81 @@
+package main
+
+// alertHysteresis holds how far a reading must move back past an alert's threshold before
+// the alert clears. Without it, a reading which hovers around a threshold would raise and
+// resolve the same alert on every health check.
+type alertHysteresis struct {
+	temperature float64 // in Celsius
+	heartRate   float64 // beats per minute
+	airQuality  float64 // AQI
+}
+
+// holds reports whether an active alert should stay active at the given reading, even
+// though the reading no longer breaches the alert's threshold. Alerts which aren't
+// raised on a single reading, such as inactivity, clear straight away.
+func (h alertHysteresis) holds(alert Alert, reading float64) bool {
+	switch alert.Type {
+	case "fever":
+		return reading > alert.Threshold-h.temperature
+	case "hypothermia":
+		return reading > 0 && reading < alert.Threshold+h.temperature
+	case "high_heart_rate":
+		return reading > alert.Threshold-h.heartRate
+	case "air_quality":
+		return reading > alert.Threshold-h.airQuality
+	}
+	return false
+}
+
+// alertReading returns the current reading an alert was raised on, from the farm's cows
+// and drone, or false if its cow or drone is gone or the alert isn't based on a reading.
+func alertReading(alert Alert, cows map[int]Cow, drone Drone) (float64, bool) {
+	if alert.Source == alertSourceDrone {
+		if alert.Type != "air_quality" || alert.DroneID != drone.ID {
+			return 0, false
+		}
+		return drone.Sensors.AirQuality, true
+	}
+
+	cow, ok := cows[alert.CowID]
+	if !ok {
+		return 0, false
+	}
+
+	switch alert.Type {
+	case "fever", "hypothermia":
+		return cow.Health.Temperature, true
+	case "high_heart_rate":
+		return float64(cow.Health.HeartRate), true
+	}
+	return 0, false
+}
+
+// heldAlerts returns the active alerts which are no longer detected, but whose readings
+// are still within the hysteresis margin of their thresholds, updated with the current
+// reading. They stay active alongside the detected alerts.
+func (app *application) heldAlerts(active, detected []Alert, cows []Cow, drone Drone) []Alert {
+	byID := make(map[int]Cow, len(cows))
+	for _, cow := range cows {
+		byID[cow.ID] = cow
+	}
+
+	stillDetected := make(map[string]bool, len(detected))
+	for _, alert := range detected {
+		stillDetected[alert.key()] = true
+	}
+
+	var held []Alert
+	for _, alert := range active {
+		if stillDetected[alert.key()] {
+			continue
+		}
+
+		reading, ok := alertReading(alert, byID, drone)
+		if ok && app.hysteresis.holds(alert, reading) {
+			alert.Value = reading
+			held = append(held, alert)
+		}
+	}
+
+	return held
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestAlertHysteresisHolds(t *testing.T) {
+	h := alertHysteresis{temperature: 0.3, heartRate: 5, airQuality: 10}
+
+	tests := []struct {
+		name      string
+		alertType string
+		threshold float64
+		reading   float64
+		want      bool
+	}{
+		{"fever within the margin", "fever", 39.5, 39.3, true},
+		{"fever at the margin", "fever", 39.5, 39.2, false},
+		{"fever recovered", "fever", 39.5, 38.6, false},
+		{"hypothermia within the margin", "hypothermia", 37.5, 37.7, true},
+		{"hypothermia at the margin", "hypothermia", 37.5, 37.8, false},
+		{"hypothermia recovered", "hypothermia", 37.5, 38.6, false},
+		{"hypothermia with no reading", "hypothermia", 37.5, 0, false},
+		{"high heart rate within the margin", "high_heart_rate", 90, 87, true},
+		{"high heart rate at the margin", "high_heart_rate", 90, 85, false},
+		{"high heart rate recovered", "high_heart_rate", 90, 70, false},
+		{"air quality within the margin", "air_quality", 150, 145, true},
+		{"air quality at the margin", "air_quality", 150, 140, false},
+		{"air quality recovered", "air_quality", 150, 60, false},
+		{"inactivity", "inactivity", 120, 119, false},
+		{"low battery", "low_battery", 20, 21, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := Alert{Type: tt.alertType, Threshold: tt.threshold}
+			if got := h.holds(alert, tt.reading); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	// With no margin, an alert clears as soon as its reading stops breaching the threshold.
+	if (alertHysteresis{}).holds(Alert{Type: "fever", Threshold: 39.5}, 39.4) {
+		t.Error("an alert was held with no hysteresis margin")
+	}
+}
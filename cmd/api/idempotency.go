@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// idempotencyCleanupInterval is how often expired idempotency keys are swept from the
+// store.
+const idempotencyCleanupInterval = time.Minute
+
+// maxIdempotencyKeyLength caps the length of the Idempotency-Key header, so clients can't
+// use the store to hold arbitrary amounts of data.
+const maxIdempotencyKeyLength = 255
+
+// idempotentResponse is a response cached against an idempotency key, along with the
+// fingerprint of the request which produced it.
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	completed   bool // false while the original request is still being handled
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore holds the responses to requests made with an Idempotency-Key header,
+// so that a device retrying a request gets the original response rather than repeating
+// its side effects.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	responses map[string]*idempotentResponse
+}
+
+// newIdempotencyStore returns an idempotencyStore which remembers keys for ttl.
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:       ttl,
+		responses: make(map[string]*idempotentResponse),
+	}
+}
+
+var (
+	// errIdempotencyKeyReused is returned when a key is reused for a different request.
+	errIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+	// errIdempotencyKeyInFlight is returned when a key is reused before the original
+	// request has finished.
+	errIdempotencyKeyInFlight = errors.New("idempotency key in use by a request in progress")
+)
+
+// Begin claims key for a request with the given fingerprint. If the key has already been
+// used for the same request and its response is complete, the cached response is returned
+// for replaying. Otherwise the key is reserved, and the caller must call Complete or
+// Release once the request has been handled.
+func (s *idempotencyStore) Begin(key string, fingerprint [sha256.Size]byte, now time.Time) (*idempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached, ok := s.responses[key]
+	if ok && now.Before(cached.expires) {
+		switch {
+		case cached.fingerprint != fingerprint:
+			return nil, errIdempotencyKeyReused
+		case !cached.completed:
+			return nil, errIdempotencyKeyInFlight
+		default:
+			return cached, nil
+		}
+	}
+
+	s.responses[key] = &idempotentResponse{
+		fingerprint: fingerprint,
+		expires:     now.Add(s.ttl),
+	}
+	return nil, nil
+}
+
+// Complete records the response to the request which reserved key.
+func (s *idempotencyStore) Complete(key string, status int, header http.Header, body []byte, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cached, ok := s.responses[key]
+	if !ok {
+		return
+	}
+
+	cached.completed = true
+	cached.status = status
+	cached.header = header
+	cached.body = body
+	cached.expires = now.Add(s.ttl)
+}
+
+// Release forgets key without recording a response, so that the request can be retried.
+func (s *idempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.responses, key)
+}
+
+// Prune removes the keys which have expired at now, returning how many were removed.
+func (s *idempotencyStore) Prune(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for key, cached := range s.responses {
+		if !now.Before(cached.expires) {
+			delete(s.responses, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// requestFingerprint identifies a request by its method, path, query string and body, so
+// that a retry can be told apart from a different request reusing the same key. A request
+// URI can't contain a newline, so the body can't be mistaken for part of it.
+func requestFingerprint(r *http.Request, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+
+	var fingerprint [sha256.Size]byte
+	copy(fingerprint[:], h.Sum(nil))
+	return fingerprint
+}
+
+// pruneIdempotencyKeys periodically removes expired idempotency keys until ctx is
+// cancelled.
+func (app *application) pruneIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed := app.idempotency.Prune(now)
+			if removed > 0 {
+				log.InfoWithProperties("Pruned expired idempotency keys", map[string]string{
+					"removed": strconv.Itoa(removed),
+				})
+			}
+		}
+	}
+}
+
+// recordingResponseWriter wraps an http.ResponseWriter to keep a copy of the status code
+// and body written by the handler.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingResponseWriter) WriteHeader(statusCode int) {
+	if rw.status == 0 {
+		rw.status = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController can
+// reach methods like Flush() through the wrapper.
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// idempotent middleware makes POST and PATCH requests safe to retry. When a request
+// carries an Idempotency-Key header its response is cached for -idempotency-ttl, and a
+// repeat of the same request with the same key gets the cached response instead of being
+// handled again. Reusing a key for a different request is rejected with 409 Conflict.
+// Server errors aren't cached, so a request which failed can be retried for real, and
+// nor are dry runs.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || contextIsDryRun(r) || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must not be more than 255 bytes long"))
+			return
+		}
+
+		// Keys are only unique within a farm, so a response is never replayed to a
+		// request for another farm.
+		key = app.farm(r).ID + ":" + key
+
+		// Read the body so it can be fingerprinted, then replace it for the handler. The
+		// limit is the largest any endpoint accepts, and the handlers apply their own.
+		limit := max(app.config.maxBodyBytes, app.config.maxImportBytes)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.payloadTooLargeResponse(w, r, maxBytesError.Limit)
+				return
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		cached, err := app.idempotency.Begin(key, requestFingerprint(r, body), app.clock.Now())
+		if err != nil {
+			message := "The Idempotency-Key has already been used for a different request"
+			if errors.Is(err, errIdempotencyKeyInFlight) {
+				message = "A request with this Idempotency-Key is still being processed"
+			}
+			app.conflictResponse(w, r, errCodeIdempotencyConflict, message)
+			return
+		}
+
+		if cached != nil {
+			for name, values := range cached.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		// Release the key if the handler panics, so the request can be retried, then let
+		// the panic carry on up to recoverPanic.
+		completed := false
+		defer func() {
+			if !completed {
+				app.idempotency.Release(key)
+			}
+		}()
+
+		rw := &recordingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		if rw.status < http.StatusInternalServerError {
+			header := w.Header().Clone()
+			header.Del("X-Request-ID")
+			app.idempotency.Complete(key, rw.status, header, rw.body.Bytes(), app.clock.Now())
+			completed = true
+		}
+	})
+}
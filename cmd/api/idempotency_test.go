@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	fingerprint := func(method, target, body string) [sha256.Size]byte {
+		return requestFingerprint(httptest.NewRequest(method, target, nil), []byte(body))
+	}
+	original := fingerprint(http.MethodPost, "/api/cows?dry_run=false", `{"name":"Bessie"}`)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		same   bool
+	}{
+		{"retry", http.MethodPost, "/api/cows?dry_run=false", `{"name":"Bessie"}`, true},
+		{"retry with the host in the request line", http.MethodPost, "http://example.com/api/cows?dry_run=false", `{"name":"Bessie"}`, true},
+		{"different method", http.MethodPatch, "/api/cows?dry_run=false", `{"name":"Bessie"}`, false},
+		{"different path", http.MethodPost, "/api/herds?dry_run=false", `{"name":"Bessie"}`, false},
+		{"different query string", http.MethodPost, "/api/cows?dry_run=true", `{"name":"Bessie"}`, false},
+		{"no query string", http.MethodPost, "/api/cows", `{"name":"Bessie"}`, false},
+		{"different body", http.MethodPost, "/api/cows?dry_run=false", `{"name":"Daisy"}`, false},
+		{"no body", http.MethodPost, "/api/cows?dry_run=false", ``, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fingerprint(tt.method, tt.target, tt.body) == original
+			if got != tt.same {
+				t.Errorf("got same fingerprint %t, want %t", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	const ttl = time.Hour
+	now := testEpoch
+	first := [sha256.Size]byte{1}
+	second := [sha256.Size]byte{2}
+
+	s := newIdempotencyStore(ttl)
+
+	steps := []struct {
+		name        string
+		fingerprint [sha256.Size]byte
+		at          time.Time
+		before      func() // run before Begin
+		wantReplay  bool
+		wantErr     error
+	}{
+		{name: "new key", fingerprint: first, at: now},
+		{name: "retried while in flight", fingerprint: first, at: now, wantErr: errIdempotencyKeyInFlight},
+		{name: "reused while in flight", fingerprint: second, at: now, wantErr: errIdempotencyKeyReused},
+		{
+			name:        "retried once complete",
+			fingerprint: first,
+			at:          now.Add(time.Minute),
+			before: func() {
+				s.Complete("key", http.StatusCreated, http.Header{"Location": {"/api/cows/6"}}, []byte(`{}`), now)
+			},
+			wantReplay: true,
+		},
+		{name: "reused once complete", fingerprint: second, at: now.Add(time.Minute), wantErr: errIdempotencyKeyReused},
+		{name: "reused once expired", fingerprint: second, at: now.Add(ttl)},
+		{name: "retried once released", fingerprint: second, at: now.Add(ttl), before: func() { s.Release("key") }},
+	}
+
+	for _, step := range steps {
+		if step.before != nil {
+			step.before()
+		}
+
+		cached, err := s.Begin("key", step.fingerprint, step.at)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: got error %v, want %v", step.name, err, step.wantErr)
+		}
+		if got := cached != nil; got != step.wantReplay {
+			t.Fatalf("%s: got a cached response %t, want %t", step.name, got, step.wantReplay)
+		}
+		if cached != nil && (cached.status != http.StatusCreated || cached.header.Get("Location") != "/api/cows/6") {
+			t.Errorf("%s: got cached status %d and Location %q", step.name, cached.status, cached.header.Get("Location"))
+		}
+	}
+
+	// The last reservation expires a ttl after it was made.
+	if removed := s.Prune(now.Add(2*ttl - time.Second)); removed != 0 {
+		t.Errorf("pruned %d keys before they expired", removed)
+	}
+	if removed := s.Prune(now.Add(2 * ttl)); removed != 1 {
+		t.Errorf("pruned %d keys once they expired, want 1", removed)
+	}
+}
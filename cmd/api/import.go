@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// csvCowColumns lists the columns a cow import must have, in the order they're documented.
+// Each maps onto the validation key of the cow field it populates, so that parse errors
+// and validation errors are reported under the same names.
+var csvCowColumns = []struct {
+	name string
+	key  string
+}{
+	{"name", "name"},
+	{"tag", "tag"},
+	{"latitude", "location.latitude"},
+	{"longitude", "location.longitude"},
+	{"zone", "location.zone"},
+	{"temperature", "sensors.temperature"},
+	{"heart_rate", "sensors.heart_rate"},
+	{"activity", "sensors.activity"},
+	{"battery_level", "sensors.battery_level"},
+}
+
+// ImportRow reports the outcome of importing a single CSV row. Line is the row's line
+// number in the file, counting the header as line 1.
+type ImportRow struct {
+	Line   int               `json:"line"`
+	Tag    string            `json:"tag,omitempty"`
+	ID     int               `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// ImportReport summarises a cow import.
+type ImportReport struct {
+	Inserted []ImportRow `json:"inserted"`
+	Updated  []ImportRow `json:"updated"`
+	Failed   []ImportRow `json:"failed"`
+}
+
+// CowUpsert is the outcome of upserting a single cow. Before is the cow as it was before
+// being updated, and is empty if the cow was inserted. Errors is set, keyed like the
+// validation errors, if the cow was rejected and left as it was.
+type CowUpsert struct {
+	Before   Cow
+	Cow      Cow
+	Inserted bool
+	Errors   map[string]string
+}
+
+// UpsertCows inserts each cow whose tag isn't already in use, assigning it the next
+// sequential ID, and updates the name, location and sensors of each cow whose tag is.
+// Deleted cows are ignored, so importing a deleted cow's tag creates a new cow. An update
+// which would move a member of a herd out of the herd's zone is rejected, and reported in
+// its result's Errors, while the other cows are still upserted. If the inserts would grow
+// the herd past maxCows, a *HerdLimitError is returned and nothing is changed. The cows'
+// tags must be unique.
+func (s *FarmStore) UpsertCows(cows []Cow, maxCows int) ([]CowUpsert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	adding := 0
+	for _, cow := range cows {
+		if s.tagIndex(cow.Tag) == -1 {
+			adding++
+		}
+	}
+	err := s.checkHerdLimit(adding, maxCows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each cow touches the farm as it's applied, so an import with no cows, e.g. because
+	// every row of the CSV failed, leaves the farm's last update as it was.
+	results := make([]CowUpsert, len(cows))
+	for i, cow := range cows {
+		if j := s.tagIndex(cow.Tag); j != -1 {
+			existing := &s.cows[j]
+			if zone, ok := s.herdZone(existing.HerdID); ok && cow.Location.Zone != zone {
+				results[i] = CowUpsert{Before: *existing, Cow: *existing, Errors: map[string]string{
+					"location.zone": fmt.Sprintf("must be %q while the cow belongs to herd %d", zone, *existing.HerdID),
+				}}
+				continue
+			}
+
+			before := *existing
+			existing.Name = cow.Name
+			existing.Location = cow.Location
+			existing.applySensors(cow.Sensors, cow.LastUpdated)
+			s.recordHistory(CowSensorReading{CowID: existing.ID, Sensors: existing.Sensors, RecordedAt: existing.LastUpdated})
+			s.touchCow(j)
+
+			results[i] = CowUpsert{Before: before, Cow: *existing}
+			continue
+		}
+
+		cow.ID = s.nextCowID()
+
+		s.appendCow(cow)
+		s.recordHistory(CowSensorReading{CowID: cow.ID, Sensors: cow.Sensors, RecordedAt: cow.LastUpdated})
+		s.touch()
+		results[i] = CowUpsert{Cow: cow, Inserted: true}
+	}
+
+	return results, nil
+}
+
+// readCSVHeader maps each required column onto its position in the header row.
+func readCSVHeader(header []string) (map[string]int, error) {
+	positions := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, ok := positions[column]; ok {
+			return nil, fmt.Errorf("header contains duplicate column %q", column)
+		}
+		positions[column] = i
+	}
+
+	var missing []string
+	for _, column := range csvCowColumns {
+		if _, ok := positions[column.name]; !ok {
+			missing = append(missing, column.name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("header is missing columns: %s", strings.Join(missing, ", "))
+	}
+
+	return positions, nil
+}
+
+// parseCSVCow converts a CSV record into a cow, recording any values which can't be
+// parsed in the provided Validator instance.
+func parseCSVCow(record []string, positions map[string]int, now time.Time, v *validator.Validator) Cow {
+	field := func(name string) string {
+		return strings.TrimSpace(record[positions[name]])
+	}
+
+	parseFloat := func(name, key string) float64 {
+		f, err := strconv.ParseFloat(field(name), 64)
+		if err != nil {
+			v.AddError(key, "must be a number")
+		}
+		return f
+	}
+
+	parseInt := func(name, key string) int {
+		i, err := strconv.Atoi(field(name))
+		if err != nil {
+			v.AddError(key, "must be an integer value")
+		}
+		return i
+	}
+
+	input := cowInput{
+		Name: field("name"),
+		Tag:  field("tag"),
+		Location: Location{
+			Latitude:  parseFloat("latitude", "location.latitude"),
+			Longitude: parseFloat("longitude", "location.longitude"),
+			Zone:      field("zone"),
+		},
+		Sensors: CowSensors{
+			Temperature:  parseFloat("temperature", "sensors.temperature"),
+			HeartRate:    parseInt("heart_rate", "sensors.heart_rate"),
+			Activity:     field("activity"),
+			BatteryLevel: parseInt("battery_level", "sensors.battery_level"),
+		},
+	}
+
+	return input.toCow(now)
+}
+
+// importCowsHandler imports cows from a CSV file with a header row, such as a farmer's
+// existing spreadsheet. Rows are upserted by tag. Each row is validated on its own, so a
+// bad row is reported without failing the rest of the file, but a file which can't be
+// parsed as CSV is rejected outright.
+func (app *application) importCowsHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/csv" {
+		app.unsupportedMediaTypeResponse(w, r, "text/csv")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.maxImportBytes)
+
+	reader := csv.NewReader(r.Body)
+
+	// readErr converts an error from the CSV reader into the appropriate response.
+	readErr := func(err error) {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.payloadTooLargeResponse(w, r, maxBytesError.Limit)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("body must contain a CSV header row")
+		}
+		readErr(err)
+		return
+	}
+
+	positions, err := readCSVHeader(header)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	report := ImportReport{
+		Inserted: []ImportRow{},
+		Updated:  []ImportRow{},
+		Failed:   []ImportRow{},
+	}
+
+	// Parse and validate the whole file before touching the store, so that nothing is
+	// imported from a file which turns out to be malformed part way through.
+	now := app.clock.Now()
+	var cows []Cow
+	var lines []int
+	seenTags := make(map[string]int)
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		// A row with the wrong number of fields is reported like any other bad row, but
+		// any other parse error means we can't trust the rest of the file.
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			report.Failed = append(report.Failed, ImportRow{
+				Line:   parseErr.StartLine,
+				Errors: map[string]string{"row": fmt.Sprintf("must have %d fields, got %d", len(header), len(record))},
+			})
+			continue
+		}
+		if err != nil {
+			readErr(err)
+			return
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		v := validator.New()
+		cow := parseCSVCow(record, positions, now, v)
+		ValidateCow(v, cow)
+
+		if first, ok := seenTags[cow.Tag]; ok {
+			v.AddError("tag", fmt.Sprintf("duplicates the tag on line %d", first))
+		} else if cow.Tag != "" {
+			seenTags[cow.Tag] = line
+		}
+
+		if !v.Valid() {
+			report.Failed = append(report.Failed, ImportRow{Line: line, Tag: cow.Tag, Errors: v.Errors})
+			continue
+		}
+
+		cows = append(cows, cow)
+		lines = append(lines, line)
+	}
+
+	results, err := app.storeFor(r).UpsertCows(cows, app.config.maxCows)
+	if err != nil {
+		var limitErr *HerdLimitError
+		switch {
+		case errors.As(err, &limitErr):
+			app.herdLimitResponse(w, r, limitErr)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for i, result := range results {
+		row := ImportRow{Line: lines[i], Tag: result.Cow.Tag, ID: result.Cow.ID}
+		if result.Errors != nil {
+			report.Failed = append(report.Failed, ImportRow{Line: lines[i], Tag: result.Cow.Tag, Errors: result.Errors})
+			continue
+		}
+		if result.Inserted {
+			report.Inserted = append(report.Inserted, row)
+			app.audit(r, "create", "cow", result.Cow.ID, nil, cowAuditSummary(result.Cow))
+		} else {
+			report.Updated = append(report.Updated, row)
+			app.audit(r, "update", "cow", result.Cow.ID, cowAuditSummary(result.Before), cowAuditSummary(result.Cow))
+		}
+	}
+
+	// Rows rejected by the store come after those which failed validation, so put them
+	// back in the order they're in the file.
+	sort.Slice(report.Failed, func(i, j int) bool {
+		return report.Failed[i].Line < report.Failed[j].Line
+	})
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "report", envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
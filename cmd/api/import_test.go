@@ -0,0 +1,139 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// csvHeader is a header row with every column, in the documented order.
+var csvHeader = []string{"name", "tag", "latitude", "longitude", "zone", "temperature", "heart_rate", "activity", "battery_level"}
+
+func TestReadCSVHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		want    map[string]int // positions of the columns checked, if the header is valid
+		wantErr string
+	}{
+		{
+			name:   "documented order",
+			header: csvHeader,
+			want:   map[string]int{"name": 0, "tag": 1, "battery_level": 8},
+		},
+		{
+			name:   "any order, case and padding",
+			header: []string{" Battery_Level", "TAG", "name ", "latitude", "longitude", "zone", "temperature", "heart_rate", "activity"},
+			want:   map[string]int{"battery_level": 0, "tag": 1, "name": 2},
+		},
+		{
+			name:   "extra columns",
+			header: append([]string{"notes"}, csvHeader...),
+			want:   map[string]int{"notes": 0, "name": 1},
+		},
+		{
+			name:    "duplicate column",
+			header:  append(append([]string{}, csvHeader...), "Tag"),
+			wantErr: `header contains duplicate column "tag"`,
+		},
+		{
+			name:    "missing columns",
+			header:  []string{"name", "tag", "latitude", "longitude", "zone", "temperature", "activity"},
+			wantErr: "header is missing columns: heart_rate, battery_level",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positions, err := readCSVHeader(tt.header)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for column, want := range tt.want {
+				if got, ok := positions[column]; !ok || got != want {
+					t.Errorf("column %q: got position %d (found %t), want %d", column, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCSVCow(t *testing.T) {
+	positions, err := readCSVHeader(csvHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		record     string
+		wantErrors map[string]string
+		check      func(t *testing.T, cow Cow)
+	}{
+		{
+			name:   "valid",
+			record: "Bessie,COW-001,40.7128,-74.006,Pasture A,38.5,65,grazing,90",
+			check: func(t *testing.T, cow Cow) {
+				if cow.Name != "Bessie" || cow.Tag != "COW-001" || cow.Location.Zone != "Pasture A" {
+					t.Errorf("got %q, %q in %q", cow.Name, cow.Tag, cow.Location.Zone)
+				}
+				if cow.Location.Latitude != 40.7128 || cow.Location.Longitude != -74.006 {
+					t.Errorf("got location %g, %g", cow.Location.Latitude, cow.Location.Longitude)
+				}
+				want := CowSensors{Temperature: 38.5, HeartRate: 65, Activity: "grazing", BatteryLevel: 90}
+				if cow.Sensors != want {
+					t.Errorf("got sensors %+v, want %+v", cow.Sensors, want)
+				}
+				if cow.LifecycleStatus != lifecycleActive || !cow.LastUpdated.Equal(testEpoch) {
+					t.Errorf("got lifecycle %q updated %s, want active updated %s", cow.LifecycleStatus, cow.LastUpdated, testEpoch)
+				}
+			},
+		},
+		{
+			name:   "padded values",
+			record: " Bessie , COW-001 , 40.7128 , -74.006 , Pasture A , 38.5 , 65 , grazing , 90 ",
+			check: func(t *testing.T, cow Cow) {
+				if cow.Name != "Bessie" || cow.Tag != "COW-001" || cow.Sensors.HeartRate != 65 {
+					t.Errorf("values weren't trimmed: %q, %q, %d", cow.Name, cow.Tag, cow.Sensors.HeartRate)
+				}
+			},
+		},
+		{
+			name:       "not a number",
+			record:     "Bessie,COW-001,north,-74.006,Pasture A,hot,65,grazing,90",
+			wantErrors: map[string]string{"location.latitude": "must be a number", "sensors.temperature": "must be a number"},
+		},
+		{
+			name:       "not an integer",
+			record:     "Bessie,COW-001,40.7128,-74.006,Pasture A,38.5,65.5,grazing,",
+			wantErrors: map[string]string{"sensors.heart_rate": "must be an integer value", "sensors.battery_level": "must be an integer value"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			cow := parseCSVCow(strings.Split(tt.record, ","), positions, testEpoch, v)
+
+			want := tt.wantErrors
+			if want == nil {
+				want = map[string]string{}
+			}
+			if !maps.Equal(v.Errors, want) {
+				t.Fatalf("got errors %v, want %v", v.Errors, want)
+			}
+			if tt.check != nil {
+				tt.check(t, cow)
+			}
+		})
+	}
+}
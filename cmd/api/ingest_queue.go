@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// ingestQueue holds incoming readings waiting to be applied by a fixed pool of workers.
+// It's bounded, so that a flood of readings makes it drop them rather than pile up
+// goroutines or memory until the process falls over.
+type ingestQueue struct {
+	tasks   chan func()
+	dropped atomic.Int64
+	// full is set when a reading is dropped and cleared when one is next accepted, so
+	// that an overflow is logged once rather than for every reading dropped.
+	full atomic.Bool
+}
+
+// newIngestQueue returns an empty queue which holds at most size readings.
+func newIngestQueue(size int) *ingestQueue {
+	return &ingestQueue{tasks: make(chan func(), size)}
+}
+
+// Len returns the number of readings waiting in the queue.
+func (q *ingestQueue) Len() int {
+	return len(q.tasks)
+}
+
+// ingest queues a reading from the given source, such as "mqtt", to be applied by an
+// ingestion worker. If the queue is full the reading is dropped and counted, and the
+// first reading dropped since the queue last had room is logged.
+func (app *application) ingest(source string, task func()) {
+	q := app.ingestQueue
+
+	select {
+	case q.tasks <- task:
+		if q.full.CompareAndSwap(true, false) {
+			log.InfoWithProperties("Ingestion queue accepting readings again", map[string]string{
+				"dropped_total": strconv.FormatInt(q.dropped.Load(), 10),
+			})
+		}
+	default:
+		q.dropped.Add(1)
+		app.prom.ingestDropped.WithLabelValues(source).Inc()
+
+		if q.full.CompareAndSwap(false, true) {
+			log.WarnWithProperties("Ingestion queue full, dropping readings", map[string]string{
+				"source":     source,
+				"queue_size": strconv.Itoa(cap(q.tasks)),
+			})
+		}
+	}
+}
+
+// ingestMQTT returns an MQTT message handler which queues messages to be applied by
+// handler, rather than applying them on the MQTT client's goroutine.
+func (app *application) ingestMQTT(handler mqtt.MessageHandler) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		app.ingest("mqtt", func() {
+			handler(client, msg)
+		})
+	}
+}
+
+// runIngestWorker applies queued readings until ctx is cancelled, and then applies any
+// which are still waiting before returning. -ingest-workers of them should be launched
+// with app.background() to be waited on at shutdown.
+func (app *application) runIngestWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case task := <-app.ingestQueue.tasks:
+					app.runIngestTask(task)
+				default:
+					return
+				}
+			}
+		case task := <-app.ingestQueue.tasks:
+			app.runIngestTask(task)
+		}
+	}
+}
+
+// runIngestTask applies a queued reading. A panic is handed to the background panic
+// handler rather than taking the worker down with it.
+func (app *application) runIngestTask(task func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.backgroundPanic(err, debug.Stack())
+		}
+	}()
+
+	task()
+}
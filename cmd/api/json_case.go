@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// The naming conventions responses can use for their keys. Our struct tags are snake_case,
+// so camelCase responses are rewritten by writeJSON.
+const (
+	jsonCaseSnake = "snake"
+	jsonCaseCamel = "camel"
+)
+
+// wantsCamelCase reports whether the response's keys should be camelCase. A client picks
+// the convention with a case parameter on the JSON media type it accepts, e.g.
+// "Accept: application/json; case=camel", and otherwise gets the -json-case default.
+func (app *application) wantsCamelCase(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil || (mediaType != "application/json" && mediaType != "*/*") {
+			continue
+		}
+
+		switch params["case"] {
+		case jsonCaseCamel:
+			return true
+		case jsonCaseSnake:
+			return false
+		}
+	}
+
+	return app.config.jsonCase == jsonCaseCamel
+}
+
+// camelCaseJSON rewrites every object key in a JSON document from snake_case to
+// camelCase, at any depth, leaving the values and the order of the keys as they were.
+func camelCaseJSON(js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	// Each open object or array, innermost last. For objects, key reports whether the
+	// next token is a key rather than a value.
+	type container struct {
+		object bool
+		empty  bool
+		key    bool
+	}
+	var stack []container
+
+	var out bytes.Buffer
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			out.WriteByte(byte(delim))
+			stack = stack[:len(stack)-1]
+		} else {
+			if len(stack) > 0 {
+				top := &stack[len(stack)-1]
+				if top.object && top.key {
+					if !top.empty {
+						out.WriteByte(',')
+					}
+					top.empty, top.key = false, false
+
+					key, err := json.Marshal(snakeToCamel(token.(string)))
+					if err != nil {
+						return nil, err
+					}
+					out.Write(key)
+					out.WriteByte(':')
+					continue
+				}
+				if !top.object {
+					if !top.empty {
+						out.WriteByte(',')
+					}
+					top.empty = false
+				}
+			}
+
+			if delim, ok := token.(json.Delim); ok {
+				out.WriteByte(byte(delim))
+				stack = append(stack, container{object: delim == '{', empty: true, key: delim == '{'})
+				continue
+			}
+
+			value, err := json.Marshal(token)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(value)
+		}
+
+		// A value has been completed, so the object it belongs to expects a key next.
+		if len(stack) > 0 && stack[len(stack)-1].object {
+			stack[len(stack)-1].key = true
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// snakeToCamel converts a snake_case key to camelCase, e.g. heart_rate to heartRate.
+// Validation error keys are converted piece by piece, so sensors.battery_level becomes
+// sensors.batteryLevel. Underscores which don't come before a lower-case letter are kept.
+func snakeToCamel(key string) string {
+	if !strings.Contains(key, "_") {
+		return key
+	}
+
+	var b strings.Builder
+	b.Grow(len(key))
+	for i := 0; i < len(key); i++ {
+		if key[i] == '_' && i > 0 && i+1 < len(key) && key[i+1] >= 'a' && key[i+1] <= 'z' {
+			b.WriteByte(key[i+1] - 'a' + 'A')
+			i++
+			continue
+		}
+		b.WriteByte(key[i])
+	}
+
+	return b.String()
+}
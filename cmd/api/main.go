@@ -1,82 +1,373 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"runtime"
-	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	log "mooveit-backend.mooveit.com/internal/jsonlog"
+	"mooveit-backend.mooveit.com/internal/mailer"
 	"mooveit-backend.mooveit.com/internal/vcs"
 )
 
-var version = vcs.Version()
+// version is the friendly form of the build's version, e.g. "v1.4.2 (abc1234)", used in
+// logs and responses. The raw VCS version is available from vcs.Version().
+var version = vcs.FriendlyVersion()
 
 type appConfig struct {
-	port int
-	env  string
+	port                    int
+	env                     string
+	tlsCert                 string
+	tlsKey                  string
+	readTimeout             time.Duration
+	readHeaderTimeout       time.Duration
+	writeTimeout            time.Duration
+	idleTimeout             time.Duration
+	adminToken              string
+	maxSensorBatch          int
+	batteryWarningThreshold int
+	healthCheckInterval     time.Duration
+	restingAnomalyDuration  time.Duration
+	aqiWarningThreshold     float64
+	aqiCriticalThreshold    float64
+	temperatureHysteresis   float64
+	heartRateHysteresis     float64
+	aqiHysteresis           float64
+	sensorHistorySize       int
+	historyRetention        time.Duration
+	alertHistorySize        int
+	smoothingAlpha          float64
+	staleAfter              time.Duration
+	geofenceRadiusKm        float64
+	maxWindSpeed            float64
+	maxCowBatch             int
+	maxCows                 int
+	maxImportBytes          int64
+	maxBodyBytes            int64
+	uploadAllowedTypes      []string
+	envelopeStyle           string
+	jsonCase                string
+	defaultPageSize         int
+	maxPageSize             int
+	auditLogSize            int
+	idempotencyTTL          time.Duration
+	simulate                bool
+	debugBodies             bool
+	logFile                 string
+	logOutput               string
+	logSampleRate           int
+	slowRequestMs           int
+	simulateInterval        time.Duration
+	mqttBroker              string
+	mqttClientID            string
+	mqttCowTopic            string
+	mqttDroneTopic          string
+	ingestQueueSize         int
+	ingestWorkers           int
+	alertWebhookURLs        []string
+	webhookTimeout          time.Duration
+	webhookRetries          int
+	slackWebhookURL         string
+	notificationCooldown    time.Duration
+	smtpHost                string
+	smtpPort                int
+	smtpUsername            string
+	smtpPassword            string
+	smtpSender              string
+	smtpRecipients          []string
+	trustedProxies          []string
+	farms                   []string
+	corsTrustedOrigins      []string
 }
 
 type application struct {
 	config appConfig
-	wg     sync.WaitGroup // Include a sync.WaitGroup in the application struct. The zero-value for a sync.WaitGroup type is a valid, useable, sync.WaitGroup with a 'counter' value of 0, so we don't need to do anything else to initialize it before we can use it.
+	// farms holds each farm's store and alerts. Requests are scoped to one of them by the
+	// scopeFarm middleware.
+	farms *FarmRegistry
+	// clock tells the time, so that time-dependent behaviour can be tested with a
+	// MockClock. It's shared with the stores.
+	clock Clock
+	// trustedProxies are the -trusted-proxies ranges whose forwarding headers are
+	// believed when resolving the client IP.
+	trustedProxies []netip.Prefix
+	// hysteresis is how far readings must recover before their alerts clear.
+	hysteresis alertHysteresis
+	auditLog   *AuditLog
+	prom       *promMetrics
+	// routeTable records the routes registered by routes(), so that metrics can be
+	// broken down by route.
+	routeTable *routeTable
+	// startedAt and requestCounts feed the metrics summary endpoint.
+	startedAt     time.Time
+	requestCounts requestCounters
+	// maintenanceMode pauses the API for everyone but admins while it's enabled.
+	maintenanceMode maintenanceMode
+	// idempotency caches responses to requests made with an Idempotency-Key header.
+	idempotency *idempotencyStore
+	// ingestQueue holds incoming MQTT readings until an ingestion worker applies them.
+	ingestQueue *ingestQueue
+	// notifier receives each new critical alert, at most once per notification cooldown.
+	// It's nil when no notification channels have been configured.
+	notifier       Notifier
+	notifyThrottle *notificationThrottle
+	wg             sync.WaitGroup // Include a sync.WaitGroup in the application struct. The zero-value for a sync.WaitGroup type is a valid, useable, sync.WaitGroup with a 'counter' value of 0, so we don't need to do anything else to initialize it before we can use it.
+	// panicHandler is given each panic recovered in a background goroutine, with the
+	// goroutine's stack. When it's nil the panic is only logged.
+	panicHandler func(recovered any, stack []byte)
 }
 
 func main() {
-	// Log application startup
-	log.Info("Application starting...")
-	log.InfoWithProperties("Application version", map[string]string{
-		"version": version,
-	})
-
 	// Declare an instance of the appConfig struct.
 	var cfg appConfig
 	parseFlags(&cfg)
 
-	// Log configuration
-	log.InfoWithProperties("Application configuration loaded", map[string]string{
-		"environment": cfg.env,
-		"port":        fmt.Sprintf("%d", cfg.port),
-	})
+	// Redirect the logs now that we know where they should go. Everything before this
+	// point, including any configuration errors, has gone to standard out.
+	if cfg.logFile != "" {
+		logFile, err := openLogFile(cfg.logFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logFile.Close()
+
+		var out io.Writer = logFile
+		if cfg.logOutput == "both" {
+			out = io.MultiWriter(os.Stdout, logFile)
+		}
+		log.SetOutput(out)
+	}
+	log.SetSampleRate(cfg.logSampleRate)
+	log.SetContextProperties(logContextProperties)
+
+	clock := realClock{}
 
 	// Set metrics parameters for the debug/vars endpoint
-	setMetricsParameters()
+	setMetricsParameters(clock)
+
+	// The ranges have already been validated, so this can't fail.
+	trustedProxies, err := parseTrustedProxies(cfg.trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every farm gets a store of its own, seeded with the mock farm data.
+	newStore := func() *FarmStore {
+		return newFarmStore(cfg.sensorHistorySize, cfg.smoothingAlpha, cfg.staleAfter, clock)
+	}
 
 	// Declare an instance of the application struct, containing the appConfig struct and the log.
 	app := &application{
-		config: cfg,
+		config:         cfg,
+		clock:          clock,
+		trustedProxies: trustedProxies,
+		farms:          newFarmRegistry(cfg.farms, newStore, cfg.alertHistorySize),
+		auditLog:       newAuditLog(cfg.auditLogSize),
+		prom:           newPromMetrics(),
+		routeTable:     &routeTable{},
+		startedAt:      clock.Now(),
+
+		hysteresis: alertHysteresis{
+			temperature: cfg.temperatureHysteresis,
+			heartRate:   cfg.heartRateHysteresis,
+			airQuality:  cfg.aqiHysteresis,
+		},
+
+		idempotency: newIdempotencyStore(cfg.idempotencyTTL),
+		ingestQueue: newIngestQueue(cfg.ingestQueueSize),
+
+		notifyThrottle: newNotificationThrottle(cfg.notificationCooldown),
+	}
+	app.prom.observeIngestQueue(app.ingestQueue)
+
+	// Count panics in background tasks as well as logging them.
+	app.panicHandler = app.countBackgroundPanic
+
+	app.maintenanceMode.retryAfter.Store(int64(defaultMaintenanceRetryAfter.Seconds()))
+	app.requestCounts.since.Store(app.startedAt.UnixNano())
+
+	// Register the alert notifiers which have been configured
+	var notifiers MultiNotifier
+	if len(cfg.alertWebhookURLs) > 0 {
+		notifiers = append(notifiers, newWebhookNotifier(cfg.alertWebhookURLs, cfg.webhookTimeout, cfg.webhookRetries))
+	}
+	if cfg.slackWebhookURL != "" {
+		notifiers = append(notifiers, newSlackNotifier(cfg.slackWebhookURL, cfg.webhookTimeout, cfg.webhookRetries))
+	}
+	if cfg.smtpHost != "" {
+		notifiers = append(notifiers, &emailNotifier{
+			mailer:     mailer.New(cfg.smtpHost, cfg.smtpPort, cfg.smtpUsername, cfg.smtpPassword, cfg.smtpSender),
+			recipients: cfg.smtpRecipients,
+		})
+	}
+	if len(notifiers) > 0 {
+		app.notifier = notifiers
+	}
+
+	// Create a context which is cancelled when the process receives a SIGINT or SIGTERM
+	// signal. Background workers watch it so that they stop promptly during a graceful
+	// shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Start the background health monitor
+	app.background(func() {
+		app.monitorHealth(ctx)
+	})
+
+	// Start sweeping expired idempotency keys
+	app.background(func() {
+		app.pruneIdempotencyKeys(ctx)
+	})
+
+	// Start pruning old sensor history, unless it's kept until it's overwritten
+	if cfg.historyRetention > 0 {
+		app.background(func() {
+			app.pruneHistory(ctx)
+		})
+	}
+
+	// Start the MQTT subscriber, if a broker has been configured, along with the workers
+	// which apply the readings it queues
+	if cfg.mqttBroker != "" {
+		for i := 0; i < cfg.ingestWorkers; i++ {
+			app.background(func() {
+				app.runIngestWorker(ctx)
+			})
+		}
+		app.background(func() {
+			app.runMQTT(ctx)
+		})
+	}
+
+	// Start the simulation, if enabled. It only ever runs in development so that it can't
+	// overwrite real sensor data.
+	if cfg.simulate {
+		if cfg.env == "development" {
+			app.background(func() {
+				app.runSimulation(ctx)
+			})
+		} else {
+			log.WarnWithProperties("Simulation is only available in development and has not been started", map[string]string{
+				"environment": cfg.env,
+			})
+		}
 	}
 
 	// Start the server
-	err := app.serve()
+	err = app.serve(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func parseFlags(cfg *appConfig) {
-	// Read the command-line flags into the appConfig struct
+	// Read the command-line flags into the appConfig struct. Values from a config file
+	// and from environment variables are applied after parsing, see loadConfigSources().
 	// Server
-	// Default port is 4000, but check for PORT environment variable first (Railway requirement)
-	defaultPort := 4000
-	if portEnv := os.Getenv("PORT"); portEnv != "" {
-		if port, err := strconv.Atoi(portEnv); err == nil {
-			defaultPort = port
-		}
-	}
-	flag.IntVar(&cfg.port, "port", defaultPort, "API server port")
+	flag.IntVar(&cfg.port, "port", 4000, "API server port")
+	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.tlsCert, "tls-cert", "", "Path to a TLS certificate; serves HTTPS (and HTTP/2) when set with -tls-key")
+	flag.StringVar(&cfg.tlsKey, "tls-key", "", "Path to the TLS certificate's private key")
+	flag.DurationVar(&cfg.readTimeout, "read-timeout", 5*time.Second, "Maximum time to read a whole request, including the body")
+	flag.DurationVar(&cfg.readHeaderTimeout, "read-header-timeout", 2*time.Second, "Maximum time to read a request's headers")
+	flag.DurationVar(&cfg.writeTimeout, "write-timeout", 10*time.Second, "Maximum time to write a response")
+	flag.DurationVar(&cfg.idleTimeout, "idle-timeout", 60*time.Second, "Maximum time to keep an idle keep-alive connection open")
+	flag.StringVar(&cfg.jsonCase, "json-case", jsonCaseSnake, "Default naming of response keys (snake|camel); clients can override it with a case parameter in their Accept header")
+	flag.StringVar(&cfg.envelopeStyle, "envelope", "descriptive", "Response envelope style (descriptive|data): descriptive keys like \"cows\", or a uniform \"data\" key")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated CIDR ranges of proxies whose X-Forwarded-For and X-Real-IP headers are trusted")
+	corsTrustedOrigins := flag.String("cors-trusted-origins", "", "Comma-separated origins, e.g. https://dashboard.mooveit.com, which browsers may call the API from (localhost is always trusted in development)")
+	farms := flag.String("farms", defaultFarmID, "Comma-separated IDs of the farms hosted by this server; requests pick one with X-Farm-ID, and the first is the default")
+	flag.StringVar(&cfg.adminToken, "admin-token", "", "Bearer token which authenticates admin requests; admin endpoints are disabled when empty")
 
-	// Default environment is development, but check for ENV environment variable
-	defaultEnv := "development"
-	if envEnv := os.Getenv("ENV"); envEnv != "" {
-		defaultEnv = envEnv
-	}
-	flag.StringVar(&cfg.env, "env", defaultEnv, "Environment (development|staging|production)")
+	// Pagination
+	flag.IntVar(&cfg.defaultPageSize, "default-page-size", 20, "Page size of paginated lists when page_size isn't given")
+	flag.IntVar(&cfg.maxPageSize, "max-page-size", 100, "Largest page_size accepted by paginated lists")
+
+	// Cows
+	flag.IntVar(&cfg.maxCowBatch, "max-cow-batch", 100, "Maximum number of cows accepted in a single bulk create")
+	flag.IntVar(&cfg.maxCows, "max-cows", 0, "Maximum number of cows in the herd, not counting deleted cows (0 for no limit)")
+	flag.Int64Var(&cfg.maxImportBytes, "max-import-bytes", 5<<20, "Maximum size of a cow CSV import or farm snapshot, in bytes")
+	flag.Int64Var(&cfg.maxBodyBytes, "max-body-bytes", 1<<20, "Maximum size of a JSON request body, in bytes")
+	uploadAllowedTypes := flag.String("upload-allowed-types", "image/jpeg,image/png", "Comma-separated MIME types accepted for image uploads, checked against the sniffed content")
+
+	// Sensor ingestion
+	flag.IntVar(&cfg.maxSensorBatch, "max-sensor-batch", 500, "Maximum number of readings accepted in a single sensor batch")
+	flag.IntVar(&cfg.sensorHistorySize, "sensor-history-size", 1440, "Number of sensor readings kept in each cow's history, and in the drone's")
+	flag.DurationVar(&cfg.historyRetention, "history-retention", 24*time.Hour, "How long sensor readings are kept in the cow and drone histories before they're pruned (0 keeps them until they're overwritten)")
+	flag.IntVar(&cfg.alertHistorySize, "alert-history-size", 1000, "Number of resolved alerts kept in each farm's alert history")
+	flag.Float64Var(&cfg.smoothingAlpha, "smoothing-alpha", 0.3, "Weight of each new reading in the smoothed temperature and heart rate (0-1]; 1 disables smoothing")
+	flag.DurationVar(&cfg.staleAfter, "stale-after", 10*time.Minute, "How long a cow or device can go without an update before it's reported as stale")
+
+	// Battery monitoring
+	flag.IntVar(&cfg.batteryWarningThreshold, "battery-warning-threshold", 20, "Battery level (percentage) below which a device is reported as low")
+
+	// Drone
+	flag.Float64Var(&cfg.maxWindSpeed, "max-wind-speed", 40, "Wind speed in km/h above which the drone refuses to take off or change altitude")
+	flag.Float64Var(&cfg.geofenceRadiusKm, "geofence-radius-km", 5, "Radius of the farm geofence around the farm center, in kilometres")
+
+	// Health monitoring
+	flag.DurationVar(&cfg.healthCheckInterval, "health-check-interval", 30*time.Second, "Interval between background herd health evaluations")
+	flag.DurationVar(&cfg.restingAnomalyDuration, "resting-anomaly-duration", 4*time.Hour, "How long a cow may rest with an elevated heart rate before an inactivity alert is raised")
+	flag.Float64Var(&cfg.aqiWarningThreshold, "aqi-warning-threshold", 150, "Air quality index reported by the drone above which a warning alert is raised")
+	flag.Float64Var(&cfg.aqiCriticalThreshold, "aqi-critical-threshold", 300, "Air quality index reported by the drone above which a critical alert is raised and notified")
+	flag.Float64Var(&cfg.temperatureHysteresis, "temperature-hysteresis", 0.3, "How far in Celsius a temperature must move back past its threshold before a fever or hypothermia alert clears")
+	flag.Float64Var(&cfg.heartRateHysteresis, "heart-rate-hysteresis", 5, "How far in bpm a heart rate must drop below its threshold before a high heart rate alert clears")
+	flag.Float64Var(&cfg.aqiHysteresis, "aqi-hysteresis", 10, "How far the air quality index must drop below its threshold before an air quality alert clears")
+
+	// Simulation
+	flag.BoolVar(&cfg.simulate, "simulate", false, "Continuously simulate changing sensor data (development only)")
+	flag.DurationVar(&cfg.simulateInterval, "simulate-interval", 5*time.Second, "Interval between simulation ticks")
+
+	// Logging
+	flag.StringVar(&cfg.logFile, "log-file", "", "Append logs to this file instead of standard out")
+	flag.StringVar(&cfg.logOutput, "log-output", "file", "Where logs go when -log-file is set (file|both): only the file, or both the file and standard out")
+	flag.IntVar(&cfg.logSampleRate, "log-sample-rate", 1, "Log only one in every N requests (warnings and errors are always logged)")
+	flag.IntVar(&cfg.slowRequestMs, "slow-request-ms", 1000, "Log requests which take at least this many milliseconds at WARN level (0 to disable)")
+
+	// Debugging
+	flag.BoolVar(&cfg.debugBodies, "debug-bodies", false, "Log the bodies of mutating requests, for debugging device payloads (development only)")
+
+	// MQTT ingestion
+	flag.StringVar(&cfg.mqttBroker, "mqtt-broker", "", "MQTT broker URL, e.g. tcp://localhost:1883 (disabled if empty)")
+	flag.StringVar(&cfg.mqttClientID, "mqtt-client-id", "mooveit-backend", "MQTT client ID")
+	flag.StringVar(&cfg.mqttCowTopic, "mqtt-cow-topic", "farm/cows/+/sensors", "MQTT topic for cow sensor readings; + matches the cow ID, and {farm} the farm ID")
+	flag.StringVar(&cfg.mqttDroneTopic, "mqtt-drone-topic", "farm/drone/+/telemetry", "MQTT topic for drone telemetry; + matches the drone ID, and {farm} the farm ID")
+	flag.IntVar(&cfg.ingestQueueSize, "ingest-queue-size", 1000, "Number of incoming readings which can wait to be applied before further readings are dropped")
+	flag.IntVar(&cfg.ingestWorkers, "ingest-workers", 4, "Number of workers applying incoming readings from the ingestion queue")
+
+	// Alert notifications
+	webhookURLs := flag.String("alert-webhook-url", "", "Comma-separated URLs which receive a POST for each new critical alert")
+	flag.DurationVar(&cfg.webhookTimeout, "webhook-timeout", 5*time.Second, "Timeout for each alert webhook request")
+	flag.IntVar(&cfg.webhookRetries, "webhook-retries", 3, "Number of times a failed alert webhook is retried")
+	flag.StringVar(&cfg.slackWebhookURL, "slack-webhook-url", "", "Slack incoming webhook URL which receives each new critical alert")
+	flag.StringVar(&cfg.smtpHost, "smtp-host", "", "SMTP host for alert emails (disabled if empty)")
+	flag.IntVar(&cfg.smtpPort, "smtp-port", 587, "SMTP port")
+	flag.StringVar(&cfg.smtpUsername, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtpPassword, "smtp-password", "", "SMTP password")
+	flag.StringVar(&cfg.smtpSender, "smtp-sender", "Moo-ve-It <alerts@mooveit.com>", "SMTP sender")
+	smtpRecipients := flag.String("smtp-recipients", "", "Comma-separated email addresses which receive critical alerts")
+	flag.DurationVar(&cfg.notificationCooldown, "notification-cooldown", 15*time.Minute, "Minimum time between notifications for the same cow and alert type")
+
+	// Audit log
+	flag.IntVar(&cfg.auditLogSize, "audit-log-size", 10000, "Number of entries kept in the in-memory audit log")
+	flag.DurationVar(&cfg.idempotencyTTL, "idempotency-ttl", 24*time.Hour, "How long responses are kept for replaying to requests with the same Idempotency-Key")
+
+	// Config file
+	configFile := flag.String("config", "", "Path to a YAML or JSON config file")
 
 	// Create a new version boolean flag with the default value of false.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
@@ -90,9 +381,30 @@ func parseFlags(cfg *appConfig) {
 		log.Info("Version:\t%s", version)
 		os.Exit(0)
 	}
+
+	err := loadConfigSources(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg.alertWebhookURLs = splitList(*webhookURLs)
+	cfg.smtpRecipients = splitList(*smtpRecipients)
+	cfg.trustedProxies = splitList(*trustedProxies)
+	cfg.corsTrustedOrigins = splitList(*corsTrustedOrigins)
+	cfg.uploadAllowedTypes = splitList(*uploadAllowedTypes)
+	cfg.farms = splitList(*farms)
+
+	// Normalize the environment name, so that ENV=Production behaves the same as
+	// ENV=production everywhere we branch on it.
+	cfg.env = strings.ToLower(strings.TrimSpace(cfg.env))
+
+	err = validateConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func setMetricsParameters() {
+func setMetricsParameters(clock Clock) {
 	// Publish a new "version" variable in the expvar handler containing our application
 	// version number (currently the constant "1.0.0").
 	expvar.NewString("version").Set(version)
@@ -104,32 +416,105 @@ func setMetricsParameters() {
 
 	// Publish the current Unix timestamp.
 	expvar.Publish("timestamp", expvar.Func(func() any {
-		return time.Now().Unix()
+		return clock.Now().Unix()
 	}))
 }
 
-func (app *application) serve() error {
+// serve starts the HTTP server and blocks until it has been shut down. When ctx is
+// cancelled the server stops accepting new connections, waits for in-flight requests to
+// complete, and then waits for any background goroutines to finish.
+func (app *application) serve(ctx context.Context) error {
+	// Bound every phase of a connection, so that slow or stalled clients (slowloris) can't
+	// tie up connections indefinitely. Errors from the server itself, such as failed TLS
+	// handshakes, are written through our structured logger.
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", app.config.port),
-		Handler: app.routes(),
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		ReadTimeout:       app.config.readTimeout,
+		ReadHeaderTimeout: app.config.readHeaderTimeout,
+		WriteTimeout:      app.config.writeTimeout,
+		IdleTimeout:       app.config.idleTimeout,
+		ErrorLog:          log.StdLogger(),
+	}
+
+	// Serve plain HTTP by default, as we usually run behind Railway's TLS-terminating
+	// proxy. When a certificate is configured we terminate TLS ourselves; net/http then
+	// negotiates HTTP/2 automatically.
+	useTLS := app.config.tlsCert != ""
+	if useTLS {
+		srv.TLSConfig = &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+			// Only forward-secret AEAD suites are offered for TLS 1.2. TLS 1.3 suites
+			// aren't configurable and are all secure.
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			},
+		}
 	}
 
+	// Create a shutdownError channel. We will use this to receive any errors returned
+	// by the graceful Shutdown() function.
+	shutdownError := make(chan error)
+
+	go func() {
+		<-ctx.Done()
+
+		log.Info("Shutting down server")
+
+		// Give in-flight requests up to 30 seconds to complete.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// Shutdown() will return nil if the graceful shutdown was successful, or an error
+		// (which may happen because of a problem closing the listeners, or because the
+		// shutdown didn't complete before the 30-second context deadline is hit).
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		// Wait for the background goroutines to finish what they're doing.
+		log.Info("Completing background tasks")
+		app.wg.Wait()
+		shutdownError <- nil
+	}()
+
 	// Construct server URL based on environment
 	serverURL := app.getServerURL()
 
-	// Log detailed server startup information
-	log.InfoWithProperties("Server starting", map[string]string{
-		"port":        fmt.Sprintf("%d", app.config.port),
-		"address":     fmt.Sprintf("0.0.0.0:%d", app.config.port),
-		"url":         serverURL,
-		"environment": app.config.env,
-	})
+	// Log a single banner with everything needed to tell how this deployment is set up
+	log.InfoWithProperties("Server starting", app.startupBanner(serverURL, useTLS))
 
-	log.Info("Server is ready to accept connections")
-	log.Info("Health check endpoint available at: %s/healthcheck", serverURL)
-	log.Info("Metrics endpoint available at: %s/debug/vars", serverURL)
+	// Calling Shutdown() on our server will cause ListenAndServe() to immediately return
+	// a http.ErrServerClosed error. So if we see this error, it is actually a good thing
+	// and an indication that the graceful shutdown has started.
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS(app.config.tlsCert, app.config.tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 
-	return srv.ListenAndServe()
+	// Otherwise, we wait to receive the return value from Shutdown() on the
+	// shutdownError channel. If the return value is an error, we know that there was a
+	// problem with the graceful shutdown and we return the error.
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	log.Info("Stopped server")
+	return nil
 }
 
 // getServerURL constructs the full server URL based on the deployment environment
@@ -155,7 +540,11 @@ func (app *application) getServerURL() string {
 
 	// Default to localhost for development
 	if app.config.env == "development" {
-		return fmt.Sprintf("http://localhost:%d", app.config.port)
+		scheme := "http"
+		if app.config.tlsCert != "" {
+			scheme = "https"
+		}
+		return fmt.Sprintf("%s://localhost:%d", scheme, app.config.port)
 	}
 
 	// For production without domain info, return generic URL
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// adminActor is the actor recorded for requests authenticated with the admin token.
+const adminActor = "admin"
+
+// defaultMaintenanceRetryAfter is how long clients are told to wait before retrying when
+// maintenance mode is enabled without a retry_after.
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+// maintenanceExemptPaths lists the endpoints which keep working in maintenance mode, so
+// that monitoring doesn't page anyone and the mode can be turned off again.
+var maintenanceExemptPaths = map[string]bool{
+	"/api/healthcheck":     true,
+	"/api/metrics":         true,
+	"/api/metrics/summary": true,
+	"/api/maintenance":     true,
+}
+
+// maintenanceMode holds whether the API is paused for maintenance. It's read on every
+// request, so the fields are atomics rather than being guarded by a mutex.
+type maintenanceMode struct {
+	enabled    atomic.Bool
+	retryAfter atomic.Int64 // seconds
+}
+
+// authenticate middleware checks the Authorization header for the admin token. Requests
+// which present it are recorded with the admin actor; requests without an Authorization
+// header carry on as anonymous, and requests with any other credentials are rejected.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(authorizationHeader, "Bearer ")
+		if !ok || app.config.adminToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(app.config.adminToken)) != 1 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		r = contextSetActor(r, adminActor)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin only lets requests authenticated with the admin token through to next.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contextGetActor(r) != adminActor {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// maintenance middleware rejects requests with 503 Service Unavailable while maintenance
+// mode is enabled. Admin requests and the exempt endpoints are still served.
+func (app *application) maintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.maintenanceMode.enabled.Load() && !maintenanceExemptPaths[r.URL.Path] && contextGetActor(r) != adminActor {
+			app.maintenanceResponse(w, r, app.maintenanceMode.retryAfter.Load())
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenanceState returns the maintenance mode as reported by the API.
+func (app *application) maintenanceState() envelope {
+	return envelope{
+		"enabled":     app.maintenanceMode.enabled.Load(),
+		"retry_after": app.maintenanceMode.retryAfter.Load(),
+	}
+}
+
+// getMaintenanceHandler reports whether maintenance mode is enabled
+func (app *application) getMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeEnvelope(w, r, http.StatusOK, "maintenance", envelope{"maintenance": app.maintenanceState()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMaintenanceHandler turns maintenance mode on or off, e.g. to pause device
+// ingestion during database maintenance without redeploying. retry_after is the number of
+// seconds clients are told to wait before retrying.
+func (app *application) updateMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Enabled    *bool  `json:"enabled"`
+		RetryAfter *int64 `json:"retry_after"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	retryAfter := int64(defaultMaintenanceRetryAfter.Seconds())
+	if input.RetryAfter != nil {
+		retryAfter = *input.RetryAfter
+	}
+
+	v := validator.New()
+	v.Check(input.Enabled != nil, "enabled", "must be provided")
+	v.Check(retryAfter > 0, "retry_after", "must be greater than zero")
+	v.Check(retryAfter <= 86400, "retry_after", "must not be more than 86400 seconds")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Maintenance mode belongs to the server rather than a farm, so a dry run only reports
+	// what it would be.
+	if contextIsDryRun(r) {
+		err = app.writeEnvelope(w, r, http.StatusOK, "maintenance", envelope{"maintenance": envelope{"enabled": *input.Enabled, "retry_after": retryAfter}}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	before := app.maintenanceState()
+	app.maintenanceMode.retryAfter.Store(retryAfter)
+	app.maintenanceMode.enabled.Store(*input.Enabled)
+	after := app.maintenanceState()
+
+	message := "Maintenance mode disabled"
+	if *input.Enabled {
+		message = "Maintenance mode enabled"
+	}
+	log.WarnCtx(r.Context(), message, map[string]string{
+		"actor":       contextGetActor(r),
+		"retry_after": strconv.FormatInt(retryAfter, 10),
+	})
+	app.audit(r, "update", "maintenance", 0, before, after)
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "maintenance", envelope{"maintenance": after}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
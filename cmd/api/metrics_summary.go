@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// requestCounters counts the requests served since startup, for the metrics summary.
+// They're updated on every request, so they're atomics rather than being guarded by a
+// mutex.
+type requestCounters struct {
+	total        atomic.Int64
+	serverErrors atomic.Int64 // responses with a 5xx status
+	since        atomic.Int64 // when counting began, in Unix nanoseconds
+}
+
+// reset zeroes the counters, which then count from now.
+func (c *requestCounters) reset(now time.Time) {
+	c.total.Store(0)
+	c.serverErrors.Store(0)
+	c.since.Store(now.UnixNano())
+}
+
+// MetricsSummary is a compact snapshot of the server and the farm, for the dashboard's
+// system health widget.
+type MetricsSummary struct {
+	UptimeSeconds int64            `json:"uptime_seconds"`
+	CountingSince time.Time        `json:"counting_since"` // when the request counters started, at startup or their last reset
+	TotalRequests int64            `json:"total_requests"`
+	ErrorRate     float64          `json:"error_rate"` // fraction of requests which failed with a 5xx status
+	Goroutines    int              `json:"goroutines"`
+	Farm          FarmSummary      `json:"farm"`
+	Retention     RetentionSummary `json:"retention"`
+}
+
+// FarmSummary holds the farm-domain counts in the metrics summary.
+type FarmSummary struct {
+	TotalCows    int `json:"total_cows"`
+	SickCows     int `json:"sick_cows"`
+	ActiveAlerts int `json:"active_alerts"`
+	Devices      int `json:"devices"`
+}
+
+// RetentionSummary reports how much sensor and alert history is kept, so that operators
+// can check the retention settings of a running server.
+type RetentionSummary struct {
+	HistorySize          int   `json:"history_size"`           // readings kept per cow, and for the drone
+	AlertHistorySize     int   `json:"alert_history_size"`     // resolved alerts kept per farm
+	HistorySeconds       int64 `json:"history_seconds"`        // 0 when readings are kept until they're overwritten
+	PruneIntervalSeconds int64 `json:"prune_interval_seconds"` // 0 when history isn't pruned
+}
+
+// getMetricsSummaryHandler returns a curated snapshot of the metrics, which is easier
+// for a frontend to consume than the full expvar or Prometheus output.
+func (app *application) getMetricsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	total := app.requestCounts.total.Load()
+	serverErrors := app.requestCounts.serverErrors.Load()
+
+	var errorRate float64
+	if total > 0 {
+		errorRate = float64(serverErrors) / float64(total)
+	}
+
+	farm := app.farm(r)
+	state := farm.store.FarmState(false)
+
+	summary := MetricsSummary{
+		UptimeSeconds: int64(app.clock.Now().Sub(app.startedAt).Seconds()),
+		CountingSince: time.Unix(0, app.requestCounts.since.Load()).UTC(),
+		TotalRequests: total,
+		ErrorRate:     errorRate,
+		Goroutines:    runtime.NumGoroutine(),
+		Farm: FarmSummary{
+			TotalCows:    state.TotalCows,
+			SickCows:     state.SickCows,
+			ActiveAlerts: len(farm.alerts.Active()),
+			Devices:      len(farm.store.Devices()),
+		},
+		Retention: RetentionSummary{
+			HistorySize:      app.config.sensorHistorySize,
+			AlertHistorySize: app.config.alertHistorySize,
+			HistorySeconds:   int64(app.config.historyRetention.Seconds()),
+		},
+	}
+	if app.config.historyRetention > 0 {
+		summary.Retention.PruneIntervalSeconds = int64(historyPruneInterval.Seconds())
+	}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "metrics", envelope{"metrics": summary}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetMetricsHandler zeroes the request counters, so that a load test can measure a run
+// without restarting the server. It resets the request totals and error rate in the
+// metrics summary, the request count and latency metrics in the Prometheus output, and the
+// farm state cache hits and misses and per-route latencies in the expvar output. Gauges, the uptime and the
+// runtime metrics describe the server as it is now, so they're left alone. It's
+// admin-only, and isn't available in production.
+func (app *application) resetMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if contextIsDryRun(r) {
+		err := app.writeJSON(w, r, http.StatusOK, envelope{"message": "metrics would be reset"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.requestCounts.reset(app.clock.Now())
+	app.prom.requestsTotal.Reset()
+	app.prom.requestDuration.Reset()
+	app.prom.routeDuration.Reset()
+	farmStateCache.Init()
+	routeLatency.Init()
+
+	app.audit(r, "reset", "metrics", 0, nil, nil)
+	log.InfoCtx(r.Context(), "Metrics reset", nil)
+
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"message": "metrics successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// metricsResponseWriter wraps an http.ResponseWriter to record the status code written
+// by the handler.
+type metricsResponseWriter struct {
+	wrapped       http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+}
+
+func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
+	return &metricsResponseWriter{
+		wrapped:    w,
+		statusCode: http.StatusOK,
+	}
+}
+
+func (mw *metricsResponseWriter) Header() http.Header {
+	return mw.wrapped.Header()
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	mw.wrapped.WriteHeader(statusCode)
+
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+	return mw.wrapped.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController can
+// reach methods like Flush() through the wrapper.
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.wrapped
+}
+
+// metrics middleware records request counts, latencies and in-flight requests for the
+// Prometheus endpoint and the metrics summary, and logs requests which took longer than
+// -slow-request-ms. It also looks up the route the request is for and stores it in the
+// request context, for the latency metrics and the logs. The request is recorded when the
+// handler returns or panics, so that requests which recoverPanic aborts part way through
+// a response are counted too, with the status they'd already been sent.
+func (app *application) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.prom.requestsInFlight.Inc()
+		defer app.prom.requestsInFlight.Dec()
+
+		route := app.routePattern(r)
+		r = contextSetRoute(r, route)
+
+		mw := newMetricsResponseWriter(w)
+		defer app.recordRequest(r, route, mw, start)
+
+		next.ServeHTTP(mw, r)
+	})
+}
+
+// recordRequest records a request which started at start in the request metrics, once its
+// handler has finished.
+func (app *application) recordRequest(r *http.Request, route string, mw *metricsResponseWriter, start time.Time) {
+	duration := time.Since(start)
+
+	app.prom.requestDuration.WithLabelValues(r.Method).Observe(duration.Seconds())
+	app.prom.routeDuration.WithLabelValues(r.Method, route).Observe(duration.Seconds())
+	observeRouteLatency(r.Method, route, duration)
+	app.prom.requestsTotal.WithLabelValues(r.Method, strconv.Itoa(mw.statusCode)).Inc()
+
+	app.requestCounts.total.Add(1)
+	if mw.statusCode >= http.StatusInternalServerError {
+		app.requestCounts.serverErrors.Add(1)
+	}
+
+	// Slow requests are always logged, whatever the sample rate, so that latency
+	// regressions show up in the logs. The request ID and route come from the context.
+	if app.config.slowRequestMs > 0 && duration >= time.Duration(app.config.slowRequestMs)*time.Millisecond {
+		log.WarnCtx(r.Context(), "Slow request", map[string]string{
+			"method":      r.Method,
+			"url":         r.URL.String(),
+			"status":      strconv.Itoa(mw.statusCode),
+			"duration_ms": strconv.FormatInt(duration.Milliseconds(), 10),
+		})
+	}
+}
+
+// requestIDRX matches the request IDs we accept from clients and upstream proxies.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID middleware tags every request with an ID, reusing a well-formed X-Request-ID
+// header from the client or proxy if there is one. The ID is stored in the request
+// context and echoed back in the X-Request-ID response header so that log lines and error
+// responses can be correlated.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
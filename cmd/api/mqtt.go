@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// mqttCowSensorMessage is the payload published by a cow collar. If recorded_at is
+// omitted the reading is treated as having been taken when it was received. It's accepted
+// in the same formats as in a sensor batch.
+type mqttCowSensorMessage struct {
+	CowSensors
+	RecordedAt FlexibleTime `json:"recorded_at"`
+}
+
+// DroneTelemetry is the payload published by the drone.
+type DroneTelemetry struct {
+	Location     Location     `json:"location"`
+	Altitude     float64      `json:"altitude"` // meters
+	Sensors      DroneSensors `json:"sensors"`
+	BatteryLevel int          `json:"battery_level"` // percentage
+	RecordedAt   time.Time    `json:"recorded_at"`
+}
+
+// ValidateDroneTelemetry checks a telemetry message from the drone.
+func ValidateDroneTelemetry(v *validator.Validator, telemetry DroneTelemetry) {
+	ValidateLocation(v.Nested("location"), telemetry.Location)
+	v.Check(telemetry.Altitude >= 0, "altitude", "must not be negative")
+	v.Check(telemetry.BatteryLevel >= 0 && telemetry.BatteryLevel <= 100, "battery_level", "must be between 0 and 100")
+}
+
+// UpdateDroneTelemetry applies a telemetry message to the drone with the given ID,
+// returning the drone as it was before and after the update. As with cow readings,
+// telemetry older than the drone's last update is ignored.
+func (s *FarmStore) UpdateDroneTelemetry(id int, telemetry DroneTelemetry) (Drone, Drone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.drone.ID != id {
+		return Drone{}, Drone{}, ErrRecordNotFound
+	}
+
+	before := s.drone
+	if !telemetry.RecordedAt.Before(s.drone.LastUpdated) {
+		// Telemetry doesn't carry a zone, so keep the drone's current one.
+		zone := s.drone.Location.Zone
+		s.drone.Location = telemetry.Location
+		if s.drone.Location.Zone == "" {
+			s.drone.Location.Zone = zone
+		}
+		s.drone.Altitude = telemetry.Altitude
+		s.drone.Sensors = telemetry.Sensors
+		s.drone.BatteryLevel = telemetry.BatteryLevel
+		s.drone.LastUpdated = telemetry.RecordedAt
+		s.recordDroneHistory()
+		s.touch()
+	}
+
+	return before, s.drone, nil
+}
+
+// mqttFarmPlaceholder stands in for the farm ID in the MQTT topics, e.g.
+// farms/{farm}/cows/+/sensors. The subscriber subscribes to the topic once for each farm,
+// with the placeholder replaced by the farm's ID.
+const mqttFarmPlaceholder = "{farm}"
+
+// topicSegmentCount returns how many levels of topic are exactly segment.
+func topicSegmentCount(topic, segment string) int {
+	count := 0
+	for _, part := range strings.Split(topic, "/") {
+		if part == segment {
+			count++
+		}
+	}
+	return count
+}
+
+// mqttFarms returns the farms the MQTT subscriber delivers readings to. Without the farm
+// placeholder in the topics, every reading goes to the default farm, which is only
+// allowed when it's the only farm.
+func (app *application) mqttFarms() []*Farm {
+	if !strings.Contains(app.config.mqttCowTopic, mqttFarmPlaceholder) {
+		return []*Farm{app.farms.Default()}
+	}
+	return app.farms.All()
+}
+
+// farmTopic returns the topic pattern with the farm placeholder replaced by the farm's ID.
+func farmTopic(pattern string, farm *Farm) string {
+	return strings.Replace(pattern, mqttFarmPlaceholder, farm.ID, 1)
+}
+
+// topicID extracts the device ID from a topic which matched a subscription pattern with a
+// single-level wildcard, e.g. farm/cows/7/sensors against farm/cows/+/sensors.
+func topicID(pattern, topic string) (int, error) {
+	patternParts := strings.Split(pattern, "/")
+	topicParts := strings.Split(topic, "/")
+	if len(patternParts) != len(topicParts) {
+		return 0, fmt.Errorf("topic %q doesn't match %q", topic, pattern)
+	}
+
+	for i, part := range patternParts {
+		if part != "+" {
+			continue
+		}
+
+		id, err := strconv.Atoi(topicParts[i])
+		if err != nil || id < 1 {
+			return 0, fmt.Errorf("topic %q has an invalid device ID", topic)
+		}
+		return id, nil
+	}
+
+	return 0, fmt.Errorf("topic pattern %q has no device ID wildcard", pattern)
+}
+
+// runMQTT subscribes to the sensor topics on the configured broker and queues incoming
+// messages to be applied to the store of the farm in their topic, or the default farm's
+// if the topics don't include one, until ctx is cancelled. The client reconnects and
+// resubscribes automatically if the broker connection is lost. It should be launched with
+// app.background() so that it's waited on at shutdown.
+func (app *application) runMQTT(ctx context.Context) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(app.config.mqttBroker).
+		SetClientID(app.config.mqttClientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute)
+
+	// Subscriptions are made in the OnConnect handler so that they're restored after a
+	// reconnect.
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.InfoWithProperties("MQTT connected", map[string]string{
+			"broker": app.config.mqttBroker,
+		})
+
+		subscriptions := make(map[string]mqtt.MessageHandler)
+		for _, farm := range app.mqttFarms() {
+			cowTopic := farmTopic(app.config.mqttCowTopic, farm)
+			droneTopic := farmTopic(app.config.mqttDroneTopic, farm)
+
+			subscriptions[cowTopic] = app.ingestMQTT(func(client mqtt.Client, msg mqtt.Message) {
+				app.handleCowSensorMessage(farm, cowTopic, msg)
+			})
+			subscriptions[droneTopic] = app.ingestMQTT(func(client mqtt.Client, msg mqtt.Message) {
+				app.handleDroneTelemetryMessage(farm, droneTopic, msg)
+			})
+		}
+		for topic, handler := range subscriptions {
+			token := client.Subscribe(topic, 1, handler)
+			if token.Wait() && token.Error() != nil {
+				log.Error("MQTT subscription to %s failed: %v", topic, token.Error())
+			}
+		}
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.WarnWithProperties("MQTT connection lost, reconnecting", map[string]string{
+			"broker": app.config.mqttBroker,
+			"error":  err.Error(),
+		})
+	})
+
+	client := mqtt.NewClient(opts)
+
+	log.InfoWithProperties("MQTT subscriber started", map[string]string{
+		"broker":      app.config.mqttBroker,
+		"cow_topic":   app.config.mqttCowTopic,
+		"drone_topic": app.config.mqttDroneTopic,
+	})
+
+	// With ConnectRetry set, Connect() keeps trying in the background until the broker is
+	// reachable, so there's no need to wait on the token here.
+	client.Connect()
+
+	<-ctx.Done()
+
+	// Give in-flight messages a moment to be processed before disconnecting.
+	client.Disconnect(250)
+	log.Info("MQTT subscriber stopped")
+}
+
+// handleCowSensorMessage applies a sensor reading published by a cow collar on the given
+// farm, which was received on a topic matching pattern.
+func (app *application) handleCowSensorMessage(farm *Farm, pattern string, msg mqtt.Message) {
+	id, err := topicID(pattern, msg.Topic())
+	if err != nil {
+		app.warnMalformedMessage(msg, err)
+		return
+	}
+
+	var message mqttCowSensorMessage
+	err = json.Unmarshal(msg.Payload(), &message)
+	if err != nil {
+		app.warnMalformedMessage(msg, err)
+		return
+	}
+
+	if message.RecordedAt.IsZero() {
+		message.RecordedAt.Time = app.clock.Now()
+	}
+
+	v := validator.New()
+	v.Check(message.RecordedAt.Valid(), "recorded_at", "must be an RFC 3339 timestamp, or Unix seconds or milliseconds")
+	ValidateCowSensors(v.Nested("sensors"), message.CowSensors)
+	if !v.Valid() {
+		app.warnMalformedMessage(msg, validationError(v))
+		return
+	}
+
+	before, after, err := farm.store.UpdateCowSensors(id, message.CowSensors, message.RecordedAt.Time)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			app.warnMalformedMessage(msg, fmt.Errorf("cow %d not found", id))
+			return
+		}
+		if errors.Is(err, ErrStaleReading) {
+			// Messages can arrive out of order; the cow already has a later reading.
+			return
+		}
+		log.Error("MQTT cow sensor update failed: %v", err)
+		return
+	}
+
+	app.warnOnLowBattery("cow", id, before.Sensors.BatteryLevel, after.Sensors.BatteryLevel)
+}
+
+// handleDroneTelemetryMessage applies a telemetry message published by the drone on the
+// given farm, which was received on a topic matching pattern.
+func (app *application) handleDroneTelemetryMessage(farm *Farm, pattern string, msg mqtt.Message) {
+	id, err := topicID(pattern, msg.Topic())
+	if err != nil {
+		app.warnMalformedMessage(msg, err)
+		return
+	}
+
+	var telemetry DroneTelemetry
+	err = json.Unmarshal(msg.Payload(), &telemetry)
+	if err != nil {
+		app.warnMalformedMessage(msg, err)
+		return
+	}
+
+	if telemetry.RecordedAt.IsZero() {
+		telemetry.RecordedAt = app.clock.Now()
+	}
+
+	v := validator.New()
+	ValidateDroneTelemetry(v, telemetry)
+	if !v.Valid() {
+		app.warnMalformedMessage(msg, validationError(v))
+		return
+	}
+
+	before, after, err := farm.store.UpdateDroneTelemetry(id, telemetry)
+	if err != nil {
+		if errors.Is(err, ErrRecordNotFound) {
+			app.warnMalformedMessage(msg, fmt.Errorf("drone %d not found", id))
+			return
+		}
+		log.Error("MQTT drone telemetry update failed: %v", err)
+		return
+	}
+
+	app.warnOnLowBattery("drone", id, before.BatteryLevel, after.BatteryLevel)
+}
+
+// warnMalformedMessage logs an MQTT message which couldn't be applied. A bad message from
+// one device mustn't stop the subscriber, so it's dropped rather than treated as an error.
+func (app *application) warnMalformedMessage(msg mqtt.Message, err error) {
+	log.WarnWithProperties("Dropped malformed MQTT message", map[string]string{
+		"topic": msg.Topic(),
+		"error": err.Error(),
+	})
+}
+
+// validationError flattens a validator's errors into a single error for logging.
+func validationError(v *validator.Validator) error {
+	problems := make([]string, 0, len(v.Errors))
+	for key, message := range v.Errors {
+		problems = append(problems, key+" "+message)
+	}
+	sort.Strings(problems)
+
+	return errors.New(strings.Join(problems, "; "))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Notifier sends an alert to an external channel, such as a webhook or Slack.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// MultiNotifier fans an alert out to several notifiers. Adding a new channel is a matter
+// of implementing Notifier and registering it in main.
+type MultiNotifier []Notifier
+
+// Notify sends the alert to every notifier concurrently, so that one slow channel doesn't
+// delay the others, and returns the errors from any that failed.
+func (m MultiNotifier) Notify(ctx context.Context, alert Alert) error {
+	errs := make([]error, len(m))
+
+	var wg sync.WaitGroup
+	for i, notifier := range m {
+		wg.Add(1)
+		go func(i int, notifier Notifier) {
+			defer wg.Done()
+			errs[i] = notifier.Notify(ctx, alert)
+		}(i, notifier)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// notificationThrottle suppresses repeat notifications for the same alert condition
+// within a cooldown window. An alert which resolves and is raised again soon afterwards
+// would otherwise notify every time the reading flaps across its threshold.
+type notificationThrottle struct {
+	mu       sync.Mutex
+	cooldown time.Duration
+	lastSent map[string]time.Time // keyed by Alert.key()
+}
+
+// newNotificationThrottle returns a notificationThrottle with the given cooldown window.
+func newNotificationThrottle(cooldown time.Duration) *notificationThrottle {
+	return &notificationThrottle{
+		cooldown: cooldown,
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+// Allow reports whether a notification for the alert may be sent at now, and if so
+// records that it has been.
+func (t *notificationThrottle) Allow(alert Alert, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Forget about notifications which are outside the window, so the map doesn't grow
+	// without bound.
+	for key, sent := range t.lastSent {
+		if now.Sub(sent) >= t.cooldown {
+			delete(t.lastSent, key)
+		}
+	}
+
+	key := alert.key()
+	if _, ok := t.lastSent[key]; ok {
+		return false
+	}
+
+	t.lastSent[key] = now
+	return true
+}
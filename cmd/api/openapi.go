@@ -0,0 +1,321 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The types below model the subset of the OpenAPI 3 specification that we need to
+// describe the API. The document is built by hand from these types, with the component
+// schemas derived from our Go structs via reflection so they can't drift from the
+// responses we actually send.
+
+type openAPISpec struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+// openAPIPathItem maps a lower-case HTTP method to the operation it performs.
+type openAPIPathItem map[string]openAPIOperation
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary"`
+	OperationID string                     `json:"operationId"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"` // path, query
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// openAPISchemaRegistry derives schemas from Go types, registering each named struct as
+// a reusable component.
+type openAPISchemaRegistry struct {
+	schemas map[string]*openAPISchema
+}
+
+// schemaFor returns the schema for the type of value, which should be a zero value of
+// the type being described.
+func (reg *openAPISchemaRegistry) schemaFor(value any) *openAPISchema {
+	return reg.schemaForType(reflect.TypeOf(value))
+}
+
+func (reg *openAPISchemaRegistry) schemaForType(t reflect.Type) *openAPISchema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := reg.schemaForType(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: reg.schemaForType(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: reg.schemaForType(t.Elem())}
+	case reflect.Struct:
+		return reg.structSchema(t)
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// structSchema registers a struct as a component schema, built from its exported fields
+// and their JSON tags, and returns a reference to it.
+func (reg *openAPISchemaRegistry) structSchema(t reflect.Type) *openAPISchema {
+	ref := &openAPISchema{Ref: "#/components/schemas/" + t.Name()}
+	if _, ok := reg.schemas[t.Name()]; ok {
+		return ref
+	}
+
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+
+	// Register the schema before walking its fields, so self-referencing types terminate.
+	reg.schemas[t.Name()] = schema
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// encoding/json promotes the fields of an untagged embedded struct.
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			reg.structSchema(field.Type)
+			for embeddedName, embedded := range reg.schemas[field.Type.Name()].Properties {
+				schema.Properties[embeddedName] = embedded
+			}
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = reg.schemaForType(field.Type)
+	}
+
+	return ref
+}
+
+// objectSchema returns an inline object schema with the given properties, which is how
+// we describe our response envelopes.
+func objectSchema(properties map[string]*openAPISchema) *openAPISchema {
+	return &openAPISchema{Type: "object", Properties: properties}
+}
+
+// jsonResponse describes a JSON response with the given schema.
+func jsonResponse(description string, schema *openAPISchema) openAPIResponse {
+	return openAPIResponse{
+		Description: description,
+		Content:     map[string]openAPIMediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// buildOpenAPISpec assembles the OpenAPI document describing the API.
+func buildOpenAPISpec() openAPISpec {
+	reg := &openAPISchemaRegistry{schemas: map[string]*openAPISchema{}}
+
+	integer := &openAPISchema{Type: "integer"}
+	str := &openAPISchema{Type: "string"}
+	boolean := &openAPISchema{Type: "boolean"}
+
+	errorSchema := objectSchema(map[string]*openAPISchema{"error": reg.schemaFor(APIError{})})
+	notFound := jsonResponse("The requested resource could not be found", errorSchema)
+	serverError := jsonResponse("The server encountered a problem", errorSchema)
+
+	cowID := openAPIParameter{Name: "id", In: "path", Description: "Cow ID", Required: true, Schema: integer}
+
+	paths := map[string]openAPIPathItem{
+		"/api/healthcheck": {
+			"get": {
+				Summary:     "Report server health and system information",
+				OperationID: "getHealthcheck",
+				Tags:        []string{"system"},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Server is available", objectSchema(map[string]*openAPISchema{
+						"status":      str,
+						"system_info": {Type: "object", AdditionalProperties: str},
+					})),
+					"500": serverError,
+				},
+			},
+		},
+		"/api/farm/state": {
+			"get": {
+				Summary:     "Get the overall state of the farm",
+				OperationID: "getFarmState",
+				Tags:        []string{"farm"},
+				Parameters: []openAPIParameter{
+					{Name: "include_inactive", In: "query", Description: "Include quarantined, sold and deceased cows", Schema: boolean},
+				},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Farm state", objectSchema(map[string]*openAPISchema{"farm_state": reg.schemaFor(FarmState{})})),
+					"422": jsonResponse("Invalid parameters", errorSchema),
+					"500": serverError,
+				},
+			},
+		},
+		"/api/cows": {
+			"get": {
+				Summary:     "List cows with their sensor data",
+				OperationID: "listCows",
+				Tags:        []string{"cows"},
+				Parameters: []openAPIParameter{
+					{Name: "status", In: "query", Description: "Only include cows with this health status: healthy, sick or injured", Schema: str},
+					{Name: "zone", In: "query", Description: "Only include cows in this zone", Schema: str},
+					{Name: "battery_min", In: "query", Description: "Minimum collar battery level (0-100)", Schema: integer},
+					{Name: "battery_max", In: "query", Description: "Maximum collar battery level (0-100)", Schema: integer},
+					{Name: "page", In: "query", Description: "Page number, from 1", Schema: integer},
+					{Name: "page_size", In: "query", Description: "Cows per page, up to -max-page-size", Schema: integer},
+				},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Cows matching the filters", objectSchema(map[string]*openAPISchema{
+						"cows":     reg.schemaFor([]Cow{}),
+						"total":    integer,
+						"metadata": reg.schemaFor(CowListMetadata{}),
+					})),
+					"422": jsonResponse("Invalid filters", errorSchema),
+					"500": serverError,
+				},
+			},
+		},
+		"/api/cows/stats": {
+			"get": {
+				Summary:     "Get aggregate herd statistics",
+				OperationID: "getCowStats",
+				Tags:        []string{"cows"},
+				Parameters: []openAPIParameter{
+					{Name: "zone", In: "query", Description: "Only include cows in this zone", Schema: str},
+					{Name: "include_inactive", In: "query", Description: "Include quarantined, sold and deceased cows", Schema: boolean},
+				},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("Herd statistics", objectSchema(map[string]*openAPISchema{"stats": reg.schemaFor(HerdStats{})})),
+					"422": jsonResponse("Invalid parameters", errorSchema),
+					"500": serverError,
+				},
+			},
+		},
+		"/api/cows/{id}": {
+			"get": {
+				Summary:     "Get a cow by ID",
+				OperationID: "getCow",
+				Tags:        []string{"cows"},
+				Parameters:  []openAPIParameter{cowID},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("The cow", objectSchema(map[string]*openAPISchema{"cow": reg.schemaFor(Cow{})})),
+					"404": notFound,
+					"500": serverError,
+				},
+			},
+		},
+		"/api/robodog": {
+			"get": {
+				Summary:     "Get the robo-dog state and sensor data",
+				OperationID: "getRoboDog",
+				Tags:        []string{"devices"},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("The robo-dog", objectSchema(map[string]*openAPISchema{"robodog": reg.schemaFor(RoboDog{})})),
+					"500": serverError,
+				},
+			},
+		},
+		"/api/drone": {
+			"get": {
+				Summary:     "Get the drone state and sensor data",
+				OperationID: "getDrone",
+				Tags:        []string{"devices"},
+				Responses: map[string]openAPIResponse{
+					"200": jsonResponse("The drone", objectSchema(map[string]*openAPISchema{"drone": reg.schemaFor(Drone{})})),
+					"500": serverError,
+				},
+			},
+		},
+	}
+
+	return openAPISpec{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "Moo-ve-It API",
+			Description: "Monitoring and management API for the Moo-ve-It smart farm.",
+			Version:     version,
+		},
+		Paths:      paths,
+		Components: openAPIComponents{Schemas: reg.schemas},
+	}
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument openAPISpec
+)
+
+// openAPIHandler serves the OpenAPI document describing the API. The document only
+// depends on the code, so it's built once on first use.
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		openAPIDocument = buildOpenAPISpec()
+	})
+
+	err := app.writeJSON(w, r, http.StatusOK, openAPIDocument, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// Pagination holds the page and page_size query string parameters accepted by the list
+// endpoints which page their results.
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+// readPagination reads the pagination parameters from the query string, recording any
+// problems in the provided Validator instance. The page size defaults to
+// -default-page-size.
+func (app *application) readPagination(qs url.Values, v *validator.Validator) Pagination {
+	return Pagination{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", app.config.defaultPageSize, v),
+	}
+}
+
+// ValidatePagination checks that the page and page size are within sensible bounds, with
+// pages of at most maxPageSize items.
+func ValidatePagination(v *validator.Validator, p Pagination, maxPageSize int) {
+	v.Check(p.Page > 0, "page", "must be greater than zero")
+	v.Check(p.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(p.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(p.PageSize <= maxPageSize, "page_size", fmt.Sprintf("must be a maximum of %d", maxPageSize))
+}
+
+func (p Pagination) limit() int {
+	return p.PageSize
+}
+
+func (p Pagination) offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// Metadata holds the pagination metadata returned alongside a page of results. Next and
+// Prev link to the neighbouring pages, and are left out at either end.
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records"`
+	Next         string `json:"next,omitempty"`
+	Prev         string `json:"prev,omitempty"`
+}
+
+// calculateMetadata calculates the pagination metadata for a page of results, given the
+// total number of records. If there are no records it returns an empty Metadata struct.
+func calculateMetadata(totalRecords int, p Pagination) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  p.Page,
+		PageSize:     p.PageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(p.PageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+// withLinks returns the metadata with links to the next and previous pages, which are
+// requestURL with the page number changed, so that they keep the request's filters and
+// sort order. A page past the end links back to the last page.
+func (m Metadata) withLinks(requestURL *url.URL) Metadata {
+	if m.TotalRecords == 0 {
+		return m
+	}
+
+	link := func(page int) string {
+		u := *requestURL
+		qs := u.Query()
+		qs.Set("page", strconv.Itoa(page))
+		u.RawQuery = qs.Encode()
+		return u.String()
+	}
+
+	if m.CurrentPage < m.LastPage {
+		m.Next = link(m.CurrentPage + 1)
+	}
+	if m.CurrentPage > m.FirstPage {
+		m.Prev = link(min(m.CurrentPage-1, m.LastPage))
+	}
+
+	return m
+}
+
+// paginate returns the page of items selected by p, which is empty if p is past the end.
+func paginate[T any](items []T, p Pagination) []T {
+	start := min(p.offset(), len(items))
+	end := min(start+p.limit(), len(items))
+
+	return items[start:end]
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// promMetrics holds the Prometheus collectors exposed on /api/metrics. HTTP metrics are
+// updated by the metrics middleware, and the farm gauges by the health monitor.
+type promMetrics struct {
+	registry *prometheus.Registry
+
+	requestsTotal    *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	routeDuration    *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+
+	ingestDropped    *prometheus.CounterVec
+	backgroundPanics prometheus.Counter
+
+	totalCows          prometheus.Gauge
+	sickCows           prometheus.Gauge
+	averageTemperature prometheus.Gauge
+}
+
+// newPromMetrics creates the Prometheus collectors and registers them, along with the
+// standard Go runtime and process collectors, on a dedicated registry.
+func newPromMetrics() *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mooveit_http_requests_total",
+			Help: "Total number of HTTP requests processed, by method and status code.",
+		}, []string{"method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mooveit_http_request_duration_seconds",
+			Help:    "Time taken to process HTTP requests, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		routeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mooveit_http_route_duration_seconds",
+			Help:    "Time taken to process HTTP requests, by method and route pattern.",
+			Buckets: routeLatencySeconds(),
+		}, []string{"method", "route"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mooveit_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being processed.",
+		}),
+
+		ingestDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mooveit_ingest_dropped_total",
+			Help: "Number of incoming readings dropped because the ingestion queue was full, by source.",
+		}, []string{"source"}),
+
+		backgroundPanics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mooveit_background_panics_total",
+			Help: "Number of panics recovered in background tasks, such as the health monitor and notifications.",
+		}),
+
+		totalCows: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mooveit_farm_cows_total",
+			Help: "Number of cows in the herd.",
+		}),
+		sickCows: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mooveit_farm_cows_sick",
+			Help: "Number of cows whose health status is sick.",
+		}),
+		averageTemperature: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mooveit_farm_temperature_average_celsius",
+			Help: "Average body temperature across the herd.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestsTotal,
+		m.requestDuration,
+		m.routeDuration,
+		m.requestsInFlight,
+		m.ingestDropped,
+		m.backgroundPanics,
+		m.totalCows,
+		m.sickCows,
+		m.averageTemperature,
+	)
+
+	return m
+}
+
+// observeIngestQueue reports the number of readings waiting in the ingestion queue.
+func (m *promMetrics) observeIngestQueue(q *ingestQueue) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mooveit_ingest_queue_depth",
+		Help: "Number of incoming readings waiting in the ingestion queue.",
+	}, func() float64 {
+		return float64(q.Len())
+	}))
+}
+
+// routeLatencySeconds returns the per-route latency buckets in seconds, as Prometheus
+// expects.
+func routeLatencySeconds() []float64 {
+	buckets := make([]float64, len(routeLatencyBuckets))
+	for i, bound := range routeLatencyBuckets {
+		buckets[i] = bound.Seconds()
+	}
+	return buckets
+}
+
+// observeHerd updates the farm-domain gauges from the current herd.
+func (m *promMetrics) observeHerd(cows []Cow) {
+	sick := 0
+	var temperatureSum float64
+	for _, cow := range cows {
+		if cow.Health.Status == "sick" {
+			sick++
+		}
+		temperatureSum += cow.Health.Temperature
+	}
+
+	m.totalCows.Set(float64(len(cows)))
+	m.sickCows.Set(float64(sick))
+
+	if len(cows) > 0 {
+		m.averageTemperature.Set(temperatureSum / float64(len(cows)))
+	} else {
+		m.averageTemperature.Set(0)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// historyPruneInterval is how often sensor and alert history older than
+// -history-retention is pruned.
+const historyPruneInterval = 5 * time.Minute
+
+// PruneHistory removes the cow sensor readings and drone telemetry recorded before the
+// given time, and returns how many were removed. Histories are in chronological order, so
+// only their oldest entries are checked. A cow whose readings have all been pruned keeps
+// an empty history, so that it isn't reported as not found.
+func (s *FarmStore) PruneHistory(before time.Time) int {
+	// Pruning doesn't change the farm, so it doesn't call s.touch(), but the cached farm
+	// state includes health trends worked out from the histories.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for _, history := range s.history {
+		removed += history.DropOldest(func(reading CowSensorReading) bool {
+			return reading.RecordedAt.Before(before)
+		})
+	}
+	removed += s.droneHistory.DropOldest(func(telemetry DroneTelemetry) bool {
+		return telemetry.RecordedAt.Before(before)
+	})
+
+	if removed > 0 {
+		s.farmState = nil
+	}
+
+	return removed
+}
+
+// pruneHistory periodically removes sensor history, and alerts resolved, longer ago than
+// -history-retention from every farm, until ctx is cancelled. It should be launched with app.background() to be
+// waited on at shutdown.
+func (app *application) pruneHistory(ctx context.Context) {
+	log.InfoWithProperties("History pruner started", map[string]string{
+		"retention": app.config.historyRetention.String(),
+		"interval":  historyPruneInterval.String(),
+	})
+
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("History pruner stopped")
+			return
+		case <-ticker.C:
+			before := app.clock.Now().Add(-app.config.historyRetention)
+
+			for _, farm := range app.farms.All() {
+				removed := farm.store.PruneHistory(before)
+				if removed > 0 {
+					log.InfoWithProperties("Pruned sensor history", map[string]string{
+						"farm":    farm.ID,
+						"removed": strconv.Itoa(removed),
+						"before":  before.Format(time.RFC3339),
+					})
+				}
+
+				removed = farm.alerts.PruneHistory(before)
+				if removed > 0 {
+					log.InfoWithProperties("Pruned alert history", map[string]string{
+						"farm":    farm.ID,
+						"removed": strconv.Itoa(removed),
+						"before":  before.Format(time.RFC3339),
+					})
+				}
+			}
+		}
+	}
+}
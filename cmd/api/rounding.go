@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// Derived readings, such as averages and smoothed values, are rounded to this many
+// decimal places in responses. That's the precision the collars report at, and it stops
+// float64 artefacts like 39.099999999999994 reaching the dashboard. The values held in
+// the store aren't rounded.
+const derivedPrecision = 1
+
+// round rounds value to the given number of decimal places, halves away from zero.
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
+
+// The MarshalJSON methods below round a type's derived readings at the response
+// boundary. Each converts to a local type without the method, so that json.Marshal()
+// doesn't recurse.
+
+func (h Health) MarshalJSON() ([]byte, error) {
+	type health Health
+	aux := health(h)
+	aux.Temperature = round(aux.Temperature, derivedPrecision)
+	aux.TemperatureSmoothed = round(aux.TemperatureSmoothed, derivedPrecision)
+	aux.HeartRateSmoothed = round(aux.HeartRateSmoothed, derivedPrecision)
+	return json.Marshal(aux)
+}
+
+func (z ZoneState) MarshalJSON() ([]byte, error) {
+	type zoneState ZoneState
+	aux := zoneState(z)
+	aux.AverageTemperature = round(aux.AverageTemperature, derivedPrecision)
+	return json.Marshal(aux)
+}
+
+func (m MetricStats) MarshalJSON() ([]byte, error) {
+	type metricStats MetricStats
+	aux := metricStats(m)
+	aux.Average = round(aux.Average, derivedPrecision)
+	aux.Min = round(aux.Min, derivedPrecision)
+	aux.Max = round(aux.Max, derivedPrecision)
+	return json.Marshal(aux)
+}
+
+func (hs HerdStats) MarshalJSON() ([]byte, error) {
+	type herdStats HerdStats
+	aux := herdStats(hs)
+	aux.AverageBatteryLevel = round(aux.AverageBatteryLevel, derivedPrecision)
+	return json.Marshal(aux)
+}
+
+func (b HistoryBucket) MarshalJSON() ([]byte, error) {
+	type historyBucket HistoryBucket
+	aux := historyBucket(b)
+	aux.AverageTemperature = round(aux.AverageTemperature, derivedPrecision)
+	aux.AverageHeartRate = round(aux.AverageHeartRate, derivedPrecision)
+	return json.Marshal(aux)
+}
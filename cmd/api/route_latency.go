@@ -0,0 +1,54 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// routeLatencyBuckets are the upper bounds of the per-route latency buckets, shared by the
+// Prometheus histogram and the expvar counts.
+var routeLatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+}
+
+// routeLatency publishes, for each route, the number of requests which fell into each
+// latency bucket in the expvar handler. Routes are keyed by method and pattern, e.g.
+// "GET /api/cows/:id", and unlike the Prometheus histogram the buckets aren't cumulative:
+// "lt_50ms" counts the requests which took from 10ms up to 50ms.
+var routeLatency = expvar.NewMap("route_latency")
+
+// routeLatencyMu stops two requests for a route which hasn't been seen before from each
+// creating its counts.
+var routeLatencyMu sync.Mutex
+
+// observeRouteLatency records how long a request for the route took.
+func observeRouteLatency(method, route string, duration time.Duration) {
+	key := method + " " + route
+
+	counts, ok := routeLatency.Get(key).(*expvar.Map)
+	if !ok {
+		routeLatencyMu.Lock()
+		counts, ok = routeLatency.Get(key).(*expvar.Map)
+		if !ok {
+			counts = new(expvar.Map).Init()
+			routeLatency.Set(key, counts)
+		}
+		routeLatencyMu.Unlock()
+	}
+
+	counts.Add(routeLatencyBucket(duration), 1)
+}
+
+// routeLatencyBucket returns the name of the bucket a request duration falls into.
+func routeLatencyBucket(duration time.Duration) string {
+	for _, bound := range routeLatencyBuckets {
+		if duration < bound {
+			return "lt_" + bound.String()
+		}
+	}
+	return "ge_" + routeLatencyBuckets[len(routeLatencyBuckets)-1].String()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// unmatchedRoute stands in for the route pattern of requests which don't match any route,
+// so that requests for made-up paths share a single metric label.
+const unmatchedRoute = "unmatched"
+
+// routeTable records the pattern of every route as it's registered, so that a request can
+// be attributed to its route, e.g. /api/cows/:id, rather than its URL, whose IDs would
+// give metrics and log aggregation unbounded cardinality. httprouter doesn't say which
+// pattern it matched, so the table matches the path against the patterns itself.
+type routeTable struct {
+	mu sync.RWMutex
+	// routers holds the patterns registered on each router, keyed by method, in the order
+	// the routers are consulted.
+	routers []map[string][]string
+}
+
+// patternRouter is an httprouter.Router which records its routes in a routeTable.
+type patternRouter struct {
+	*httprouter.Router
+	patterns map[string][]string
+	table    *routeTable
+}
+
+// newRouter returns a new router whose routes are recorded in the table. Routers must be
+// created in the order they're consulted.
+func (t *routeTable) newRouter() *patternRouter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	patterns := make(map[string][]string)
+	t.routers = append(t.routers, patterns)
+
+	return &patternRouter{Router: httprouter.New(), patterns: patterns, table: t}
+}
+
+// HandlerFunc registers a handler function for the method and pattern.
+func (pr *patternRouter) HandlerFunc(method, pattern string, handler http.HandlerFunc) {
+	pr.Handler(method, pattern, handler)
+}
+
+// Handler registers a handler for the method and pattern.
+func (pr *patternRouter) Handler(method, pattern string, handler http.Handler) {
+	pr.table.mu.Lock()
+	pr.patterns[method] = append(pr.patterns[method], pattern)
+	pr.table.mu.Unlock()
+
+	pr.Router.Handler(method, pattern, handler)
+}
+
+// pattern returns the pattern of the route which serves the request, or unmatchedRoute if
+// there isn't one.
+func (t *routeTable) pattern(r *http.Request) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	method := r.Method
+	for {
+		for _, patterns := range t.routers {
+			for _, pattern := range patterns[method] {
+				if matchRoute(pattern, r.URL.Path) {
+					return pattern
+				}
+			}
+		}
+
+		// httprouter answers HEAD requests with the GET handler when there isn't a HEAD
+		// route.
+		if method != http.MethodHead {
+			return unmatchedRoute
+		}
+		method = http.MethodGet
+	}
+}
+
+// routePattern returns the pattern of the route which serves the request, such as
+// /api/cows/:id, or unmatchedRoute if there isn't one. Metrics and logs should use it
+// rather than the URL, so that their labels and fields take a bounded number of values.
+// The pattern is looked up once, by the metrics middleware, and kept in the request
+// context.
+func (app *application) routePattern(r *http.Request) string {
+	if route := contextGetRoute(r); route != "" {
+		return route
+	}
+	return app.routeTable.pattern(r)
+}
+
+// matchRoute reports whether path matches an httprouter pattern. A :name segment matches
+// any single non-empty segment, and a trailing *name matches the rest of the path. Within
+// a router httprouter doesn't allow patterns which could match the same path, so at most
+// one of a router's patterns matches.
+func matchRoute(pattern, path string) bool {
+	for {
+		patternSegment, patternRest, patternMore := strings.Cut(pattern, "/")
+		pathSegment, pathRest, pathMore := strings.Cut(path, "/")
+
+		switch {
+		case strings.HasPrefix(patternSegment, "*"):
+			return true
+		case strings.HasPrefix(patternSegment, ":"):
+			if pathSegment == "" {
+				return false
+			}
+		case patternSegment != pathSegment:
+			return false
+		}
+
+		if !patternMore || !pathMore {
+			return patternMore == pathMore
+		}
+		pattern, path = patternRest, pathRest
+	}
+}
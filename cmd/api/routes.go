@@ -4,49 +4,159 @@ import (
 	"expvar"
 	"fmt"
 	"net/http"
+	"strconv"
 
-	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	jsonlog "mooveit-backend.mooveit.com/internal/jsonlog"
 )
 
 func (app *application) routes() http.Handler {
-	router := httprouter.New()
+	// httprouter doesn't allow a static segment and a named parameter in the same position
+	// of a path, so collection-level routes such as /api/cows/stats can't live alongside
+	// /api/cows/:id. They're registered on a separate router which is consulted first, and
+	// anything it can't match falls through to the main router. Both routers record their
+	// routes, so that requests can be attributed to the route which served them.
+	collections := app.routeTable.newRouter()
+	router := app.routeTable.newRouter()
+
+	// Convert the notFoundResponse() and methodNotAllowedResponse() helpers to
+	// http.Handler values and use them as the custom error handlers for httprouter, so
+	// that routing errors get the same JSON error envelope as everything else.
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	collections.HandleMethodNotAllowed = false
+	collections.RedirectTrailingSlash = false
+	collections.RedirectFixedPath = false
+	collections.NotFound = router
 
 	// Convert httprouter.Handler to http.Handler
 	router.HandlerFunc(http.MethodGet, "/api/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/api/version", app.versionHandler)
+
+	// Admin endpoints
+	router.HandlerFunc(http.MethodGet, "/api/maintenance", app.getMaintenanceHandler)
+	router.HandlerFunc(http.MethodPost, "/api/maintenance", app.requireAdmin(app.updateMaintenanceHandler))
+	router.HandlerFunc(http.MethodGet, "/api/export", app.requireAdmin(app.exportHandler))
+	router.HandlerFunc(http.MethodPost, "/api/import", app.requireAdmin(app.importHandler))
 
 	// Register the expvar handler for metrics
 	router.Handler(http.MethodGet, "/api/debug/vars", expvar.Handler())
 
+	// Register the Prometheus handler for scraping by our monitoring stack
+	router.Handler(http.MethodGet, "/api/metrics", promhttp.HandlerFor(app.prom.registry, promhttp.HandlerOpts{}))
+
+	// A compact snapshot of the metrics for the dashboard
+	router.HandlerFunc(http.MethodGet, "/api/metrics/summary", app.getMetricsSummaryHandler)
+
+	// Serve the OpenAPI document describing the API
+	router.HandlerFunc(http.MethodGet, "/api/openapi.json", app.openAPIHandler)
+
 	// Farm monitoring endpoints
 	router.HandlerFunc(http.MethodGet, "/api/farm/state", app.getFarmStateHandler)
+	router.HandlerFunc(http.MethodGet, "/api/farm/devices/health", app.listDevicesHealthHandler)
 	router.HandlerFunc(http.MethodGet, "/api/cows", app.listCowsHandler)
+	collections.HandlerFunc(http.MethodGet, "/api/cows/stats", app.getCowStatsHandler)
+	collections.HandlerFunc(http.MethodGet, "/api/cows/alerting", app.listAlertingCowsHandler)
+	collections.HandlerFunc(http.MethodGet, "/api/cows/tag/:tag", app.getCowByTagHandler)
+	collections.HandlerFunc(http.MethodPost, "/api/cows/bulk", app.bulkCreateCowsHandler)
+	collections.HandlerFunc(http.MethodPost, "/api/cows/import", app.importCowsHandler)
 	router.HandlerFunc(http.MethodGet, "/api/cows/:id", app.getCowHandler)
+	router.HandlerFunc(http.MethodHead, "/api/cows/:id", app.getCowHandler)
+	router.HandlerFunc(http.MethodDelete, "/api/cows/:id", app.deleteCowHandler)
+	router.HandlerFunc(http.MethodPost, "/api/cows/:id/restore", app.restoreCowHandler)
+	router.HandlerFunc(http.MethodPost, "/api/cows/:id/lifecycle", app.updateCowLifecycleHandler)
+	router.HandlerFunc(http.MethodGet, "/api/cows/:id/nearest-device", app.getNearestDeviceHandler)
+	router.HandlerFunc(http.MethodGet, "/api/cows/:id/history", app.getCowHistoryHandler)
+	router.HandlerFunc(http.MethodPatch, "/api/cows/:id/sensors", app.patchCowSensorsHandler)
+	router.HandlerFunc(http.MethodGet, "/api/herds", app.listHerdsHandler)
+	router.HandlerFunc(http.MethodPost, "/api/herds", app.createHerdHandler)
+	router.HandlerFunc(http.MethodGet, "/api/herds/:id", app.getHerdHandler)
+	router.HandlerFunc(http.MethodPatch, "/api/herds/:id", app.updateHerdHandler)
+	router.HandlerFunc(http.MethodDelete, "/api/herds/:id", app.deleteHerdHandler)
+	router.HandlerFunc(http.MethodGet, "/api/herds/:id/cows", app.listHerdCowsHandler)
 	router.HandlerFunc(http.MethodGet, "/api/robodog", app.getRoboDogHandler)
+	router.HandlerFunc(http.MethodHead, "/api/robodog", app.getRoboDogHandler)
 	router.HandlerFunc(http.MethodGet, "/api/drone", app.getDroneHandler)
+	router.HandlerFunc(http.MethodHead, "/api/drone", app.getDroneHandler)
+	router.HandlerFunc(http.MethodGet, "/api/drone/history", app.getDroneHistoryHandler)
+	router.HandlerFunc(http.MethodPost, "/api/drone/commands", app.createDroneCommandHandler)
+	router.HandlerFunc(http.MethodGet, "/api/drone/route", app.getDroneRouteHandler)
+	router.HandlerFunc(http.MethodPost, "/api/drone/route", app.createDroneRouteHandler)
+	router.HandlerFunc(http.MethodGet, "/api/devices", app.listDevicesHandler)
+	router.HandlerFunc(http.MethodGet, "/api/battery", app.listLowBatteryHandler)
+	router.HandlerFunc(http.MethodGet, "/api/stale", app.listStaleHandler)
+	router.HandlerFunc(http.MethodGet, "/api/alerts", app.listAlertsHandler)
+	router.HandlerFunc(http.MethodGet, "/api/alerts/history", app.listAlertHistoryHandler)
+	router.HandlerFunc(http.MethodGet, "/api/commands", app.listCommandsHandler)
+	router.HandlerFunc(http.MethodPost, "/api/dispatch", app.createDispatchHandler)
+	router.HandlerFunc(http.MethodGet, "/api/audit", app.listAuditEntriesHandler)
+
+	// Sensor ingestion endpoints
+	collections.HandlerFunc(http.MethodPost, "/api/cows/sensors/batch", app.ingestCowSensorBatchHandler)
 
-	// Create a middleware chain
-	return app.recoverPanic(app.logRequest(router))
+	// Resetting the metrics is for load testing, and would only confuse monitoring in
+	// production.
+	if app.config.env != "production" {
+		router.HandlerFunc(http.MethodPost, "/api/metrics/reset", app.requireAdmin(app.resetMetricsHandler))
+	}
+
+	// Development-only endpoints. They aren't registered at all in other environments, so
+	// requests for them get the usual 404 response.
+	if app.config.env == "development" {
+		router.HandlerFunc(http.MethodPost, "/api/simulate/tick", app.simulateTickHandler)
+		router.HandlerFunc(http.MethodPost, "/api/reset", app.resetHandler)
+	}
+
+	// Create a middleware chain. Request bodies are only logged when debugging in
+	// development.
+	handler := app.authenticate(app.maintenance(app.scopeFarm(app.dryRun(app.idempotent(collections)))))
+	if app.config.debugBodies && app.config.env == "development" {
+		handler = app.debugBodies(handler)
+	}
+
+	return app.requestID(app.clientIP(app.metrics(app.recoverPanic(app.logRequest(app.enableCORS(handler))))))
 }
 
-// recoverPanic middleware recovers from panics and logs the error
+// recoverPanic middleware recovers from panics and logs the error. If the handler hadn't
+// started its response, the client gets a 500 Internal Server Error. Otherwise the status
+// has already been sent and can't be changed, so the panic is only logged and the
+// connection is closed, which tells the client the response is incomplete.
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := newMetricsResponseWriter(w)
+
 		defer func() {
 			if err := recover(); err != nil {
-				w.Header().Set("Connection", "close")
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				if !mw.headerWritten {
+					w.Header().Set("Connection", "close")
+					app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+					return
+				}
+
+				jsonlog.ErrorCtx(r.Context(), fmt.Errorf("%s", err), map[string]string{
+					"request_method": r.Method,
+					"request_url":    r.URL.String(),
+					"status":         strconv.Itoa(mw.statusCode),
+				})
+
+				// Aborting the handler makes the server drop the connection (or reset the
+				// HTTP/2 stream) without writing anything more, and without logging a
+				// second time.
+				panic(http.ErrAbortHandler)
 			}
 		}()
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(mw, r)
 	})
 }
 
-// logRequest middleware logs HTTP requests
+// logRequest middleware logs HTTP requests. The request ID, client IP and the route
+// pattern, so that log lines can be grouped by route, come from the request context. Under
+// load only a sample of them is logged, as set by -log-sample-rate.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		jsonlog.InfoWithProperties("request received", map[string]string{
+		jsonlog.SampledInfoCtx(r.Context(), "request received", map[string]string{
 			"method": r.Method,
 			"url":    r.URL.String(),
 		})
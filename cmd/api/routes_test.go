@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestApplication returns an application with just enough set up to run the
+// middleware.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	return &application{
+		clock:      NewMockClock(testEpoch),
+		prom:       newPromMetrics(),
+		routeTable: &routeTable{},
+	}
+}
+
+// serveRecovering serves the request with h, returning what it panicked with, if
+// anything.
+func serveRecovering(h http.Handler, w http.ResponseWriter, r *http.Request) (recovered any) {
+	defer func() {
+		recovered = recover()
+	}()
+
+	h.ServeHTTP(w, r)
+	return nil
+}
+
+func TestRecoverPanicBeforeWrite(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	rr := httptest.NewRecorder()
+	if recovered := serveRecovering(h, rr, httptest.NewRequest(http.MethodGet, "/api/cows", nil)); recovered != nil {
+		t.Fatalf("recoverPanic let a panic through: %v", recovered)
+	}
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if rr.Header().Get("Connection") != "close" {
+		t.Errorf("got Connection %q, want close", rr.Header().Get("Connection"))
+	}
+}
+
+func TestRecoverPanicAfterPartialWrite(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.metrics(app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"cows":[`))
+		panic("boom")
+	})))
+
+	rr := httptest.NewRecorder()
+	recovered := serveRecovering(h, rr, httptest.NewRequest(http.MethodGet, "/api/cows", nil))
+
+	// The status has already gone, so the handler must be aborted rather than an error
+	// response written after the partial body.
+	if err, ok := recovered.(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
+		t.Fatalf("got panic %v, want http.ErrAbortHandler", recovered)
+	}
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %d, want the %d already sent", rr.Code, http.StatusOK)
+	}
+	if body := rr.Body.String(); body != `{"cows":[` {
+		t.Errorf("got body %q, want only the partial write", body)
+	}
+	if rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got Content-Type %q, want the handler's", rr.Header().Get("Content-Type"))
+	}
+
+	// The aborted request still counts towards the metrics.
+	if total := app.requestCounts.total.Load(); total != 1 {
+		t.Errorf("got %d requests counted, want 1", total)
+	}
+}
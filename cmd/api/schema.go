@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaFiles holds the JSON Schema documents describing request bodies. Keeping them in
+// one place documents each endpoint's contract alongside the code that enforces it.
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// cowCreateSchema describes the body of POST /api/cows/bulk.
+var cowCreateSchema = mustCompileSchema("cow_create.json")
+
+// mustCompileSchema compiles one of the embedded schemas. The schemas are part of the
+// binary, so a schema which doesn't compile is a programming error and panics at startup.
+func mustCompileSchema(name string) *jsonschema.Schema {
+	js, err := schemaFiles.ReadFile("schemas/" + name)
+	if err != nil {
+		panic(fmt.Sprintf("schema %s: %s", name, err))
+	}
+
+	schema, err := jsonschema.CompileString(name, string(js))
+	if err != nil {
+		panic(fmt.Sprintf("schema %s: %s", name, err))
+	}
+
+	return schema
+}
+
+// SchemaError is returned by readJSONWithSchema when a request body is well-formed JSON
+// but doesn't match its schema. Fields holds the failures keyed like the validator's
+// errors, e.g. "[0].location.latitude", so that they fit the usual 422 response.
+type SchemaError struct {
+	Fields map[string]string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("body failed schema validation on %d fields", len(e.Fields))
+}
+
+// readJSONWithSchema reads the request body like readJSON, then validates it against
+// schema before decoding it into destination. Type and range errors are reported as a
+// *SchemaError rather than a generic bad request, before any handler logic runs.
+func (app *application) readJSONWithSchema(w http.ResponseWriter, r *http.Request, schema *jsonschema.Schema, destination any) error {
+	var raw json.RawMessage
+	err := app.readJSON(w, r, &raw)
+	if err != nil {
+		return err
+	}
+
+	// Decode numbers as json.Number, so that the schema checks the values exactly as the
+	// client sent them rather than after a round trip through float64.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var document any
+	err = dec.Decode(&document)
+	if err != nil {
+		return errors.New("body contains badly-formed JSON")
+	}
+
+	err = schema.Validate(document)
+	if err != nil {
+		var validationError *jsonschema.ValidationError
+		if !errors.As(err, &validationError) {
+			return err
+		}
+
+		fields := make(map[string]string)
+		collectSchemaErrors(validationError, fields)
+		return &SchemaError{Fields: fields}
+	}
+
+	// The schema has already rejected unknown keys and mistyped values, so decoding into
+	// the destination can only fail if the schema and the Go type disagree.
+	dec = json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(destination)
+	if err != nil {
+		return fmt.Errorf("body doesn't match its schema: %w", err)
+	}
+
+	return nil
+}
+
+// collectSchemaErrors walks the tree of schema validation errors and adds the leaves to
+// fields. Only the first failure for each field is kept, as with the validator.
+func collectSchemaErrors(err *jsonschema.ValidationError, fields map[string]string) {
+	if len(err.Causes) > 0 {
+		for _, cause := range err.Causes {
+			collectSchemaErrors(cause, fields)
+		}
+		return
+	}
+
+	// A missing required property is reported against its parent object. Report it
+	// against each missing field instead, in the same words the validator uses.
+	if missing, ok := strings.CutPrefix(err.Message, "missing properties: "); ok {
+		for _, name := range strings.Split(missing, ", ") {
+			addSchemaError(fields, schemaFieldKey(err.InstanceLocation, strings.Trim(name, "'")), "must be provided")
+		}
+		return
+	}
+
+	addSchemaError(fields, schemaFieldKey(err.InstanceLocation, ""), err.Message)
+}
+
+func addSchemaError(fields map[string]string, key, message string) {
+	if _, exists := fields[key]; !exists {
+		fields[key] = message
+	}
+}
+
+// schemaFieldKey converts a JSON pointer such as /0/location/latitude into the key style
+// of our field errors, [0].location.latitude, optionally appending a child field. Errors
+// about the body as a whole are keyed "body".
+func schemaFieldKey(pointer, child string) string {
+	var key strings.Builder
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+
+		if _, err := strconv.Atoi(token); err == nil {
+			key.WriteString("[" + token + "]")
+			continue
+		}
+		if key.Len() > 0 {
+			key.WriteByte('.')
+		}
+		key.WriteString(token)
+	}
+
+	if child != "" {
+		if key.Len() > 0 {
+			key.WriteByte('.')
+		}
+		key.WriteString(child)
+	}
+
+	if key.Len() == 0 {
+		return "body"
+	}
+	return key.String()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// CowSensorReading is a single sensor reading reported by a cow's collar
+type CowSensorReading struct {
+	CowID      int        `json:"cow_id"`
+	Sensors    CowSensors `json:"sensors"`
+	RecordedAt time.Time  `json:"recorded_at"`
+}
+
+// CowSensorReadingInput is a reading as it's sent in a sensor batch. Collar firmware
+// doesn't agree on a timestamp format, so recorded_at is decoded as a FlexibleTime.
+type CowSensorReadingInput struct {
+	CowID      int          `json:"cow_id"`
+	Sensors    CowSensors   `json:"sensors"`
+	RecordedAt FlexibleTime `json:"recorded_at"`
+}
+
+// reading returns the input as a CowSensorReading.
+func (in CowSensorReadingInput) reading() CowSensorReading {
+	return CowSensorReading{CowID: in.CowID, Sensors: in.Sensors, RecordedAt: in.RecordedAt.Time}
+}
+
+// rejectedReading describes why a reading in a batch was not applied
+type rejectedReading struct {
+	Index  int               `json:"index"`
+	CowID  int               `json:"cow_id"`
+	Errors map[string]string `json:"errors"`
+}
+
+// ValidateCowSensors checks that every sensor value is within a physically plausible range.
+func ValidateCowSensors(v *validator.Validator, sensors CowSensors) {
+	v.Check(sensors.Temperature >= 30 && sensors.Temperature <= 45, "temperature", "must be between 30 and 45")
+	v.Check(sensors.HeartRate >= 20 && sensors.HeartRate <= 200, "heart_rate", "must be between 20 and 200")
+	v.Check(validator.PermittedValue(sensors.Activity, "grazing", "resting", "moving"), "activity", "must be grazing, resting or moving")
+	v.Check(sensors.BatteryLevel >= 0 && sensors.BatteryLevel <= 100, "battery_level", "must be between 0 and 100")
+}
+
+// CowSensorsPatch holds the metrics reported by a device which only measures some of
+// them. Nil fields are left as they were in the cow's last reading.
+type CowSensorsPatch struct {
+	Temperature  *float64 `json:"temperature"`
+	HeartRate    *int     `json:"heart_rate"`
+	Activity     *string  `json:"activity"`
+	BatteryLevel *int     `json:"battery_level"`
+}
+
+// ValidateCowSensorsPatch checks the metrics which were provided against the same ranges
+// as ValidateCowSensors.
+func ValidateCowSensorsPatch(v *validator.Validator, patch CowSensorsPatch) {
+	v.Check(patch.Temperature != nil || patch.HeartRate != nil || patch.Activity != nil || patch.BatteryLevel != nil, "sensors", "must contain at least one metric")
+
+	sensors := v.Nested("sensors")
+	if patch.Temperature != nil {
+		sensors.Check(*patch.Temperature >= 30 && *patch.Temperature <= 45, "temperature", "must be between 30 and 45")
+	}
+	if patch.HeartRate != nil {
+		sensors.Check(*patch.HeartRate >= 20 && *patch.HeartRate <= 200, "heart_rate", "must be between 20 and 200")
+	}
+	if patch.Activity != nil {
+		sensors.Check(validator.PermittedValue(*patch.Activity, "grazing", "resting", "moving"), "activity", "must be grazing, resting or moving")
+	}
+	if patch.BatteryLevel != nil {
+		sensors.Check(*patch.BatteryLevel >= 0 && *patch.BatteryLevel <= 100, "battery_level", "must be between 0 and 100")
+	}
+}
+
+// apply returns sensors with the provided metrics replaced.
+func (p CowSensorsPatch) apply(sensors CowSensors) CowSensors {
+	if p.Temperature != nil {
+		sensors.Temperature = *p.Temperature
+	}
+	if p.HeartRate != nil {
+		sensors.HeartRate = *p.HeartRate
+	}
+	if p.Activity != nil {
+		sensors.Activity = *p.Activity
+	}
+	if p.BatteryLevel != nil {
+		sensors.BatteryLevel = *p.BatteryLevel
+	}
+	return sensors
+}
+
+// ValidateCowSensorReading checks a single reading from a sensor batch received at now.
+func ValidateCowSensorReading(v *validator.Validator, reading CowSensorReadingInput, now time.Time) {
+	v.Check(reading.CowID > 0, "cow_id", "must be a positive integer")
+	v.Check(reading.RecordedAt.Valid(), "recorded_at", "must be an RFC 3339 timestamp, or Unix seconds or milliseconds")
+	v.Check(!reading.RecordedAt.IsZero(), "recorded_at", "must be provided")
+	// Allow a little clock skew between the collars and the server.
+	v.Check(reading.RecordedAt.Before(now.Add(time.Minute)), "recorded_at", "must not be in the future")
+
+	ValidateCowSensors(v.Nested("sensors"), reading.Sensors)
+}
+
+// ingestCowSensorBatchHandler accepts readings that devices buffered while offline. Each
+// reading is validated on its own so that a single bad record doesn't fail the whole batch.
+func (app *application) ingestCowSensorBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var input []CowSensorReadingInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input) > 0, "readings", "must contain at least one reading")
+	v.Check(len(input) <= app.config.maxSensorBatch, "readings", fmt.Sprintf("must not contain more than %d readings", app.config.maxSensorBatch))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	type indexedReading struct {
+		index int
+		CowSensorReading
+	}
+
+	rejected := []rejectedReading{}
+	accepted := make([]indexedReading, 0, len(input))
+
+	now := app.clock.Now()
+	for i, reading := range input {
+		v := validator.New()
+		ValidateCowSensorReading(v, reading, now)
+		if !v.Valid() {
+			rejected = append(rejected, rejectedReading{Index: i, CowID: reading.CowID, Errors: v.Errors})
+			continue
+		}
+		accepted = append(accepted, indexedReading{index: i, CowSensorReading: reading.reading()})
+	}
+
+	// Apply the readings oldest first so that the most recent reading for each cow wins.
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].RecordedAt.Before(accepted[j].RecordedAt)
+	})
+
+	applied, ignored := 0, 0
+	for _, reading := range accepted {
+		before, after, err := app.storeFor(r).UpdateCowSensors(reading.CowID, reading.Sensors, reading.RecordedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRecordNotFound):
+				rejected = append(rejected, rejectedReading{
+					Index:  reading.index,
+					CowID:  reading.CowID,
+					Errors: map[string]string{"cow_id": "cow not found"},
+				})
+			case errors.Is(err, ErrStaleReading):
+				// The cow already has a later reading, so this one is valid but had no effect.
+				ignored++
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			continue
+		}
+
+		if !contextIsDryRun(r) {
+			app.warnOnLowBattery("cow", after.ID, before.Sensors.BatteryLevel, after.Sensors.BatteryLevel)
+		}
+		applied++
+	}
+
+	// Readings arrive in bulk, so the batch is audited as a whole rather than per reading.
+	if applied > 0 {
+		app.audit(r, "update", "sensor_batch", 0, nil, map[string]any{"accepted": applied, "ignored": ignored, "rejected": len(rejected)})
+	}
+
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].Index < rejected[j].Index
+	})
+
+	env := envelope{
+		"accepted": applied,
+		"ignored":  ignored,
+		"rejected": rejected,
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// patchCowSensorsHandler updates only the metrics a device reported, such as just its
+// battery level, keeping the rest of the cow's last reading, and re-derives its health.
+func (app *application) patchCowSensorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input CowSensorsPatch
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if ValidateCowSensorsPatch(v, input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before, cow, err := app.storeFor(r).PatchCowSensors(int(id), input, app.clock.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			app.cowNotFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !contextIsDryRun(r) {
+		app.warnOnLowBattery("cow", cow.ID, before.Sensors.BatteryLevel, cow.Sensors.BatteryLevel)
+	}
+	app.audit(r, "update", "cow_sensors", cow.ID, before.Sensors, cow.Sensors)
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "cow", envelope{"cow": cow}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+)
+
+// Bounds within which simulated sensor readings drift. They're kept inside the ranges
+// accepted by ValidateCowSensors so that simulated data looks like real collar data.
+const (
+	simMinTemperature   = 37.5 // in Celsius
+	simMaxTemperature   = 41.0 // in Celsius
+	simMinHeartRate     = 40   // beats per minute
+	simMaxHeartRate     = 120  // beats per minute
+	simMaxLocationDrift = 0.0005
+)
+
+// simulationRand is the random source used by the simulator. A rand.Rand isn't safe for
+// concurrent use, so it's guarded by simulationRandMu.
+var (
+	simulationRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	simulationRandMu sync.Mutex
+)
+
+// drift returns value nudged by a random amount in [-maxDelta, maxDelta] and clamped to
+// [min, max].
+func drift(value, maxDelta, min, max float64) float64 {
+	simulationRandMu.Lock()
+	delta := (simulationRand.Float64()*2 - 1) * maxDelta
+	simulationRandMu.Unlock()
+
+	return math.Max(min, math.Min(max, value+delta))
+}
+
+// driftLocation moves a location by a small random distance, keeping its zone.
+func driftLocation(location Location) Location {
+	location.Latitude = drift(location.Latitude, simMaxLocationDrift, -90, 90)
+	location.Longitude = drift(location.Longitude, simMaxLocationDrift, -180, 180)
+	return location
+}
+
+// SimulateTick nudges every cow's sensor readings and every device's location and battery
+// by a small random amount, as if a fresh set of readings had just arrived at now. Cows
+// have their health re-derived and the reading recorded in their history, exactly as for
+// ingested readings.
+func (s *FarmStore) SimulateTick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cows {
+		cow := &s.cows[i]
+		if cow.Deleted() {
+			continue
+		}
+
+		sensors := cow.Sensors
+		sensors.Temperature = math.Round(drift(sensors.Temperature, 0.2, simMinTemperature, simMaxTemperature)*10) / 10
+		sensors.HeartRate = int(math.Round(drift(float64(sensors.HeartRate), 3, simMinHeartRate, simMaxHeartRate)))
+		sensors.BatteryLevel = int(math.Round(drift(float64(sensors.BatteryLevel), 1, 0, 100)))
+
+		cow.Location = driftLocation(cow.Location)
+		cow.applySensors(sensors, now)
+		s.recordHistory(CowSensorReading{CowID: cow.ID, Sensors: sensors, RecordedAt: now})
+		s.touchCow(i)
+	}
+
+	s.roboDog.Location = driftLocation(s.roboDog.Location)
+	s.roboDog.BatteryLevel = int(math.Round(drift(float64(s.roboDog.BatteryLevel), 1, 0, 100)))
+	s.roboDog.LastUpdated = now
+
+	s.drone.Location = driftLocation(s.drone.Location)
+	s.drone.BatteryLevel = int(math.Round(drift(float64(s.drone.BatteryLevel), 1, 0, 100)))
+	s.drone.LastUpdated = now
+	s.recordDroneHistory()
+
+	s.touch()
+}
+
+// simulateTickHandler advances the simulated farm by one tick and returns the new farm
+// state. It's only registered in development, to give the frontend changing data to
+// develop against.
+func (app *application) simulateTickHandler(w http.ResponseWriter, r *http.Request) {
+	app.storeFor(r).SimulateTick(app.clock.Now())
+
+	env := envelope{"farm_state": app.storeFor(r).FarmState(false)}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "farm_state", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetHandler restores the farm to the mock data the server started with, so that the
+// frontend team can get back to a known state between demos without a restart. Like the
+// simulation, it's only registered in development.
+func (app *application) resetHandler(w http.ResponseWriter, r *http.Request) {
+	app.storeFor(r).Reset()
+
+	log.InfoCtx(r.Context(), "Farm data reset to the mock data", nil)
+
+	env := envelope{"farm_state": app.storeFor(r).FarmState(false)}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "farm_state", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runSimulation advances every simulated farm by one tick each simulate interval, so that
+// the data keeps changing without manual requests to /api/simulate/tick. It returns as
+// soon as ctx is cancelled, so it should be launched with app.background().
+func (app *application) runSimulation(ctx context.Context) {
+	log.InfoWithProperties("Simulation started", map[string]string{
+		"interval": app.config.simulateInterval.String(),
+	})
+
+	ticker := time.NewTicker(app.config.simulateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Simulation stopped")
+			return
+		case now := <-ticker.C:
+			for _, farm := range app.farms.All() {
+				farm.store.SimulateTick(now)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Slack attachment colors for each alert severity.
+var slackSeverityColors = map[string]string{
+	"critical": "#d00000",
+	"warning":  "#ffa500",
+}
+
+// slackMessage is the payload accepted by a Slack incoming webhook.
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color    string       `json:"color"`
+	Title    string       `json:"title"`
+	Text     string       `json:"text"`
+	Fields   []slackField `json:"fields"`
+	Fallback string       `json:"fallback"`
+	Ts       int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// slackNotifier posts alerts to a Slack incoming webhook. Delivery, timeouts and retries
+// are handled by the same code as the generic webhooks.
+type slackNotifier struct {
+	webhook *webhookNotifier
+}
+
+// newSlackNotifier returns a slackNotifier which posts to the given incoming webhook URL.
+func newSlackNotifier(url string, timeout time.Duration, retries int) *slackNotifier {
+	return &slackNotifier{webhook: newWebhookNotifier([]string{url}, timeout, retries)}
+}
+
+// Notify posts the alert to Slack as a color-coded attachment.
+func (n *slackNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(slackAlertMessage(alert))
+	if err != nil {
+		return err
+	}
+
+	return n.webhook.send(ctx, body)
+}
+
+// slackAlertMessage formats an alert as a Slack message.
+func slackAlertMessage(alert Alert) slackMessage {
+	summary := fmt.Sprintf("%s alert for %s: %s", alert.Severity, alert.Subject(), alert.Message)
+
+	fields := []slackField{
+		{Title: alert.SubjectKind(), Value: alert.Subject(), Short: true},
+		{Title: "Farm", Value: alert.Farm, Short: true},
+		{Title: "Zone", Value: alert.Zone, Short: true},
+		{Title: "Reading", Value: fmt.Sprintf("%g (threshold %g)", alert.Value, alert.Threshold), Short: true},
+		{Title: "Severity", Value: alert.Severity, Short: true},
+	}
+	if alert.Reason != "" {
+		fields = append(fields, slackField{Title: "Reason", Value: alert.Reason})
+	}
+
+	return slackMessage{
+		Text: summary,
+		Attachments: []slackAttachment{
+			{
+				Color:    slackSeverityColors[alert.Severity],
+				Title:    alert.Message,
+				Text:     fmt.Sprintf("%s alert: %s", alert.Type, alert.Subject()),
+				Fields:   fields,
+				Fallback: summary,
+				Ts:       alert.RaisedAt.Unix(),
+			},
+		},
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+// smoothedSensors holds the exponential moving averages of a cow's temperature and heart
+// rate. Raw readings are noisy, so a single spike moves the averages only by the
+// smoothing factor, alpha.
+type smoothedSensors struct {
+	Temperature float64
+	HeartRate   float64
+}
+
+// update folds a reading into the averages. The first reading seeds them as-is.
+func (s *smoothedSensors) update(sensors CowSensors, alpha float64, first bool) {
+	if first {
+		s.Temperature = sensors.Temperature
+		s.HeartRate = float64(sensors.HeartRate)
+		return
+	}
+
+	s.Temperature = alpha*sensors.Temperature + (1-alpha)*s.Temperature
+	s.HeartRate = alpha*float64(sensors.HeartRate) + (1-alpha)*s.HeartRate
+}
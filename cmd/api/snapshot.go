@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	log "mooveit-backend.mooveit.com/internal/jsonlog"
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// FarmSnapshot is a copy of the whole farm, in the shape exported by GET /api/export for
+// backups and debugging, and restored by POST /api/import. The export is streamed with
+// writeSnapshot rather than built as a FarmSnapshot, so that it needn't fit in memory.
+type FarmSnapshot struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Version    string    `json:"version"`
+	Cows       []Cow     `json:"cows"` // including deleted cows
+	Herds      []Herd    `json:"herds"`
+	RoboDog    RoboDog   `json:"robodog"`
+	Drone      Drone     `json:"drone"`
+	Alerts     []Alert   `json:"alerts"` // active when the snapshot was taken
+}
+
+// writeSnapshot writes every cow (deleted cows included), herd and device in the store to
+// the stream, as the cows, herds, robodog and drone members of the snapshot object being
+// written. It holds the read lock throughout, so that they're consistent with each other
+// without a copy of the farm being made first; changes to the farm wait until the client
+// has received it, which -write-timeout bounds.
+func (s *FarmStore) writeSnapshot(js *jsonStream) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	js.beginArray("cows")
+	for _, cow := range s.cows {
+		js.value("", s.withDerivedHealth(cow))
+	}
+	js.endArray()
+
+	js.beginArray("herds")
+	for _, herd := range s.herds {
+		js.value("", herd)
+	}
+	js.endArray()
+
+	now := s.clock.Now()
+	roboDog, drone := s.roboDog, s.drone
+	roboDog.Stale, roboDog.SecondsSinceUpdate = s.freshness(roboDog.LastUpdated, now)
+	drone.Stale, drone.SecondsSinceUpdate = s.freshness(drone.LastUpdated, now)
+	js.value("robodog", roboDog)
+	js.value("drone", drone)
+}
+
+// Restore replaces the cows, herds and devices in the store with those from a snapshot,
+// all at once, so no request sees a mixture of the two. The snapshot must have been
+// validated with ValidateFarmSnapshot.
+func (s *FarmStore) Restore(snapshot FarmSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.load(snapshot.Cows, snapshot.Herds, snapshot.RoboDog, snapshot.Drone)
+	s.touch()
+}
+
+// ValidateFarmSnapshot checks every cow, herd and device in a snapshot, and that they're
+// consistent with each other: IDs and the tags of cows which haven't been deleted are
+// unique, and each herd and its members agree on the membership. A maxCows of zero means
+// there's no limit on the size of the herd.
+func ValidateFarmSnapshot(v *validator.Validator, snapshot FarmSnapshot, maxCows int) {
+	herds := make(map[int]Herd, len(snapshot.Herds))
+	for i, herd := range snapshot.Herds {
+		hv := v.Nested(fmt.Sprintf("herds[%d]", i))
+		ValidateHerd(hv, herd)
+		hv.Check(herd.ID > 0, "id", "must be a positive integer")
+		if _, ok := herds[herd.ID]; ok {
+			hv.AddError("id", "duplicates the ID of another herd")
+		}
+		herds[herd.ID] = herd
+	}
+
+	cows := make(map[int]Cow, len(snapshot.Cows))
+	tags := make(map[string]bool, len(snapshot.Cows))
+	for i, cow := range snapshot.Cows {
+		cv := v.Nested(fmt.Sprintf("cows[%d]", i))
+		ValidateCow(cv, cow)
+		cv.Check(cow.ID > 0, "id", "must be a positive integer")
+		if _, ok := cows[cow.ID]; ok {
+			cv.AddError("id", "duplicates the ID of another cow")
+		}
+		cows[cow.ID] = cow
+
+		if !cow.Deleted() {
+			cv.Check(!tags[cow.Tag], "tag", "duplicates the tag of another cow")
+			tags[cow.Tag] = true
+		}
+
+		if cow.HerdID != nil {
+			herd, ok := herds[*cow.HerdID]
+			cv.Check(ok && slices.Contains(herd.CowIDs, cow.ID), "herd_id", "must be a herd which lists the cow as a member")
+		}
+	}
+	v.Check(maxCows == 0 || len(tags) <= maxCows, "cows", fmt.Sprintf("must not contain more than %d cows which haven't been deleted", maxCows))
+
+	for i, herd := range snapshot.Herds {
+		hv := v.Nested(fmt.Sprintf("herds[%d]", i))
+		for j, id := range herd.CowIDs {
+			cow, ok := cows[id]
+			hv.Check(ok && cow.HerdID != nil && *cow.HerdID == herd.ID, fmt.Sprintf("cow_ids[%d]", j), fmt.Sprintf("cow %d must exist and belong to the herd", id))
+		}
+	}
+
+	validateSnapshotDevice(v.Nested("robodog"), snapshot.RoboDog.ID, snapshot.RoboDog.Name, snapshot.RoboDog.Status, snapshot.RoboDog.BatteryLevel, snapshot.RoboDog.Location)
+	validateSnapshotDevice(v.Nested("drone"), snapshot.Drone.ID, snapshot.Drone.Name, snapshot.Drone.Status, snapshot.Drone.BatteryLevel, snapshot.Drone.Location)
+}
+
+// validateSnapshotDevice checks the fields common to the robo-dog and drone in a snapshot.
+func validateSnapshotDevice(v *validator.Validator, id int, name, status string, batteryLevel int, location Location) {
+	v.Check(id > 0, "id", "must be a positive integer")
+	v.Check(name != "", "name", "must be provided")
+	v.Check(status != "", "status", "must be provided")
+	v.Check(batteryLevel >= 0 && batteryLevel <= 100, "battery_level", "must be between 0 and 100")
+	ValidateLocation(v.Nested("location"), location)
+}
+
+// exportHandler returns a snapshot of the whole farm, including deleted cows and the
+// active alerts, so that a problematic state can be captured and reproduced elsewhere with
+// POST /api/import. It's admin-only. A big herd makes for a big snapshot, so it's streamed
+// a cow at a time rather than built in memory, in the same shape as a FarmSnapshot.
+func (app *application) exportHandler(w http.ResponseWriter, r *http.Request) {
+	farm := app.farm(r)
+	alerts := farm.alerts.Active()
+
+	js := app.streamJSON(w, r, http.StatusOK, nil)
+	js.beginObject("")
+	js.beginObject(app.envelopeKey("snapshot"))
+	js.value("exported_at", app.clock.Now())
+	js.value("version", version)
+	farm.store.writeSnapshot(js)
+	js.value("alerts", alerts)
+	js.endObject()
+	js.endObject()
+
+	// The client going away part way through the download isn't a problem with the
+	// server, so there's nothing to report.
+	_ = js.close()
+}
+
+// importHandler replaces the whole farm with a snapshot from GET /api/export. The snapshot
+// is validated in full before anything is changed, and is then swapped in at once, along
+// with its alerts. It's admin-only, and limited in size by -max-import-bytes.
+func (app *application) importHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Snapshot *FarmSnapshot `json:"snapshot"`
+	}
+
+	err := app.readJSONWithLimit(w, r, &input, app.config.maxImportBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Snapshot == nil {
+		v.AddError("snapshot", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	snapshot := *input.Snapshot
+	ValidateFarmSnapshot(v, snapshot, app.config.maxCows)
+	for i, alert := range snapshot.Alerts {
+		v.Check(validator.PermittedValue(alert.Source, alertSourceCow, alertSourceDrone), fmt.Sprintf("alerts[%d].source", i), "must be cow or drone")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A snapshot can be imported into a different farm from the one it was exported from,
+	// so its alerts are moved over to this farm.
+	farm := app.farm(r)
+	for i := range snapshot.Alerts {
+		snapshot.Alerts[i].Farm = farm.ID
+	}
+	farm.store.Restore(snapshot)
+	farm.alerts.Restore(snapshot.Alerts)
+
+	summary := map[string]any{
+		"exported_at": snapshot.ExportedAt,
+		"version":     snapshot.Version,
+		"cows":        len(snapshot.Cows),
+		"herds":       len(snapshot.Herds),
+		"alerts":      len(snapshot.Alerts),
+	}
+	app.audit(r, "import", "farm", 0, nil, summary)
+	log.InfoCtx(r.Context(), "Farm snapshot imported", map[string]string{
+		"exported_at": snapshot.ExportedAt.Format(time.RFC3339),
+		"version":     snapshot.Version,
+		"cows":        fmt.Sprintf("%d", len(snapshot.Cows)),
+	})
+
+	env := envelope{"farm_state": farm.store.FarmState(false)}
+
+	err = app.writeEnvelope(w, r, http.StatusOK, "farm_state", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
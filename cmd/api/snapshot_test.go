@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// export serves GET /api/export with the given headers and query string, returning the
+// response body.
+func export(t *testing.T, app *application, query string, headers map[string]string) []byte {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/api/export"+query, nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	rr := httptest.NewRecorder()
+	app.exportHandler(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+	}
+	if rr.Header().Get("Content-Length") != "" || rr.Header().Get("ETag") != "" {
+		t.Errorf("a streamed response has a Content-Length or ETag")
+	}
+	return rr.Body.Bytes()
+}
+
+func TestExportStreamsSnapshot(t *testing.T) {
+	app := newTestApplication(t)
+	store, clock := newTestStore(t)
+	app.farms = newFarmRegistry([]string{defaultFarmID}, func() *FarmStore { return store }, 10)
+
+	// Make sure there's a herd, and more cows than are written between flushes.
+	cows := make([]Cow, streamFlushItems+1)
+	for i := range cows {
+		cows[i] = newTestCow(fmt.Sprintf("COW-%d", 1000+i), clock.Now())
+	}
+	if _, err := store.InsertCows(cows, 0); err != nil {
+		t.Fatalf("inserting cows: %v", err)
+	}
+	if _, err := store.InsertHerd(Herd{Name: "Test", Zone: cows[0].Location.Zone}); err != nil {
+		t.Fatalf("inserting a herd: %v", err)
+	}
+
+	compact := export(t, app, "", nil)
+
+	var input struct {
+		Snapshot FarmSnapshot `json:"snapshot"`
+	}
+	if err := json.Unmarshal(compact, &input); err != nil {
+		t.Fatalf("the export isn't valid JSON: %v", err)
+	}
+	if got, want := len(input.Snapshot.Cows), len(store.AllCows()); got != want {
+		t.Errorf("got %d cows, want %d", got, want)
+	}
+	if len(input.Snapshot.Herds) != 1 {
+		t.Errorf("got %d herds, want 1", len(input.Snapshot.Herds))
+	}
+
+	// The streamed document is exactly what encoding/json makes of the snapshot.
+	want, err := json.Marshal(envelope{"snapshot": input.Snapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.TrimSuffix(compact, []byte("\n")); !bytes.Equal(got, want) {
+		t.Errorf("the export differs from encoding/json's:\n got %s\nwant %s", got, want)
+	}
+
+	// Indenting and camelCasing it match writeJSON's.
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, want, "", "\t"); err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.TrimSuffix(export(t, app, "?pretty=true", nil), []byte("\n")); !bytes.Equal(got, indented.Bytes()) {
+		t.Errorf("the pretty export differs from json.Indent's:\n got %s\nwant %s", got, indented.Bytes())
+	}
+
+	camel, err := camelCaseJSON(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.TrimSuffix(export(t, app, "", map[string]string{"Accept": "application/json; case=camel"}), []byte("\n")); !bytes.Equal(got, camel) {
+		t.Errorf("the camelCase export differs from camelCaseJSON's:\n got %s\nwant %s", got, camel)
+	}
+}
+
+// failingWriter is a ResponseWriter whose client has gone away after the first write.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > 1 {
+		return 0, errors.New("connection reset by peer")
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestExportClientGone(t *testing.T) {
+	app := newTestApplication(t)
+	store, _ := newTestStore(t)
+	app.farms = newFarmRegistry([]string{defaultFarmID}, func() *FarmStore { return store }, 10)
+
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder()}
+	h := app.recoverPanic(http.HandlerFunc(app.exportHandler))
+
+	// A client going away is no reason to panic, or to carry on writing.
+	if recovered := serveRecovering(h, w, httptest.NewRequest(http.MethodGet, "/api/export", nil)); recovered != nil {
+		t.Fatalf("got panic %v, want the export to stop quietly", recovered)
+	}
+	if w.writes != 2 {
+		t.Errorf("got %d writes, want the export to stop after the one which failed", w.writes)
+	}
+}
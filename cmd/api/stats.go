@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"net/http"
+
+	"mooveit-backend.mooveit.com/internal/validator"
+)
+
+// MetricStats summarises a single numeric metric across the herd
+type MetricStats struct {
+	Average float64 `json:"average"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// HerdStats represents aggregate metrics across the herd
+type HerdStats struct {
+	TotalCows           int            `json:"total_cows"`
+	Temperature         MetricStats    `json:"temperature"`
+	HeartRate           MetricStats    `json:"heart_rate"`
+	AverageBatteryLevel float64        `json:"average_battery_level"`
+	ByHealthStatus      map[string]int `json:"by_health_status"`
+	ByZone              map[string]int `json:"by_zone"`
+}
+
+// CowStats computes aggregate metrics for the cows in the given zone, or for the whole
+// herd if zone is empty. Only active cows are included, unless includeInactive is set.
+func (s *FarmStore) CowStats(zone string, includeInactive bool) HerdStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var cows []Cow
+	for _, cow := range s.cows {
+		if cow.Deleted() || (zone != "" && cow.Location.Zone != zone) {
+			continue
+		}
+		if !includeInactive && cow.LifecycleStatus != lifecycleActive {
+			continue
+		}
+		cows = append(cows, cow)
+	}
+
+	return herdStats(cows)
+}
+
+// activeCows returns the cows whose lifecycle status is active.
+func activeCows(cows []Cow) []Cow {
+	active := []Cow{}
+	for _, cow := range cows {
+		if cow.LifecycleStatus == lifecycleActive {
+			active = append(active, cow)
+		}
+	}
+	return active
+}
+
+// herdStats computes aggregate metrics for the given cows.
+func herdStats(cows []Cow) HerdStats {
+	stats := HerdStats{
+		Temperature:    MetricStats{Min: math.Inf(1), Max: math.Inf(-1)},
+		HeartRate:      MetricStats{Min: math.Inf(1), Max: math.Inf(-1)},
+		ByHealthStatus: map[string]int{},
+		ByZone:         map[string]int{},
+	}
+
+	var temperatureSum, heartRateSum, batterySum float64
+	for _, cow := range cows {
+		stats.TotalCows++
+		stats.ByHealthStatus[cow.Health.Status]++
+		stats.ByZone[cow.Location.Zone]++
+
+		temperature := cow.Health.Temperature
+		heartRate := float64(cow.Health.HeartRate)
+
+		temperatureSum += temperature
+		heartRateSum += heartRate
+		batterySum += float64(cow.Sensors.BatteryLevel)
+
+		stats.Temperature.Min = math.Min(stats.Temperature.Min, temperature)
+		stats.Temperature.Max = math.Max(stats.Temperature.Max, temperature)
+		stats.HeartRate.Min = math.Min(stats.HeartRate.Min, heartRate)
+		stats.HeartRate.Max = math.Max(stats.HeartRate.Max, heartRate)
+	}
+
+	// With no matching cows there's nothing to average, and the infinite sentinels can't
+	// be encoded as JSON, so report zeroes instead.
+	if stats.TotalCows == 0 {
+		stats.Temperature = MetricStats{}
+		stats.HeartRate = MetricStats{}
+		return stats
+	}
+
+	count := float64(stats.TotalCows)
+	stats.Temperature.Average = temperatureSum / count
+	stats.HeartRate.Average = heartRateSum / count
+	stats.AverageBatteryLevel = batterySum / count
+
+	return stats
+}
+
+// getCowStatsHandler returns aggregate metrics for the herd, optionally scoped to a zone.
+// Cows which are quarantined, sold or deceased are left out unless ?include_inactive=true.
+func (app *application) getCowStatsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	zone := app.readString(qs, "zone", "")
+
+	v := validator.New()
+	includeInactive := app.readBool(qs, "include_inactive", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"stats": app.storeFor(r).CowStats(zone, includeInactive)}
+
+	err := app.writeEnvelope(w, r, http.StatusOK, "stats", env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"mooveit-backend.mooveit.com/internal/ringbuffer"
+)
+
+var (
+	// ErrRecordNotFound is returned by the store when a lookup doesn't match any record.
+	ErrRecordNotFound = errors.New("record not found")
+
+	// ErrDuplicateTag is returned when a change would leave two current cows sharing a tag.
+	ErrDuplicateTag = errors.New("duplicate tag")
+
+	// ErrStaleReading is returned when a sensor reading is ignored because it was taken
+	// before the cow's last update.
+	ErrStaleReading = errors.New("stale reading")
+)
+
+// Health thresholds used to derive a cow's health status from its sensor readings.
+const (
+	feverTemperature   = 39.5 // in Celsius
+	maxNormalHeartRate = 80   // beats per minute
+)
+
+// FarmStore holds the in-memory state of the farm. All access goes through its methods,
+// which take the embedded RWMutex so handlers and background goroutines can safely share
+// a single instance.
+type FarmStore struct {
+	mu          sync.RWMutex
+	cows        []Cow
+	roboDog     RoboDog
+	drone       Drone
+	herds       []Herd
+	history     map[int]*ringbuffer.Buffer[CowSensorReading] // keyed by cow ID
+	historySize int
+
+	// byID and byTag index s.cows for point lookups, mapping a cow's ID or tag to its
+	// position. Deleted cows keep their place in byID, but their tags are dropped from
+	// byTag since they may be reused. Both are maintained under the write lock by every
+	// method which adds, deletes or restores a cow.
+	byID  map[int]int
+	byTag map[string]int
+
+	// lastCowID and lastHerdID are the most recently assigned IDs. New cows and herds are
+	// numbered on from them rather than from what's in the store, so an ID is never handed
+	// out twice, even after the cow or herd it belonged to has been deleted.
+	lastCowID  int
+	lastHerdID int
+
+	// droneHistory holds the drone's recent telemetry, so that a flight's readings can be
+	// reconstructed after the fact. It's the same size as each cow's history.
+	droneHistory *ringbuffer.Buffer[DroneTelemetry]
+
+	// smoothed holds each cow's moving averages, keyed by cow ID. They're updated as
+	// each reading is recorded, weighting the new reading by smoothingAlpha.
+	smoothed       map[int]*smoothedSensors
+	smoothingAlpha float64
+
+	// staleAfter is how long an entity can go without an update before it's reported as
+	// stale, as it has probably stopped reporting.
+	staleAfter time.Duration
+
+	// clock tells the time when a method isn't given it.
+	clock Clock
+
+	// farmState caches the summary of the active cows returned by FarmState until the next
+	// change to the farm. It's guarded by mu like everything else, and cleared by touch().
+	farmState *FarmState
+
+	// modifiedAt is when the farm last changed, for the farm state's last_updated and its
+	// Last-Modified header. It's set by touch().
+	modifiedAt time.Time
+}
+
+// touch records a change to the farm, invalidating the cached farm state and recording
+// the time of the change. Every method which modifies the store must call it under the
+// write lock once it has made a change, so the cache is never stale, but not when the
+// change is rejected or ignored, so that Last-Modified only moves when the farm does.
+func (s *FarmStore) touch() {
+	s.farmState = nil
+	s.modifiedAt = s.clock.Now()
+}
+
+// touchCow records a change to the cow at position i in s.cows, and so to the farm. The
+// caller must hold the write lock.
+func (s *FarmStore) touchCow(i int) {
+	s.cows[i].modifiedAt = s.clock.Now()
+	s.touch()
+}
+
+// reindex rebuilds the cow indexes from scratch. The caller must hold the write lock.
+func (s *FarmStore) reindex() {
+	s.byID = make(map[int]int, len(s.cows))
+	s.byTag = make(map[string]int, len(s.cows))
+	for i, cow := range s.cows {
+		s.byID[cow.ID] = i
+		if !cow.Deleted() {
+			s.byTag[cow.Tag] = i
+		}
+	}
+}
+
+// cowIndex returns the position of the cow with the given ID in s.cows, including deleted
+// cows, or -1 if there's no such cow. The caller must hold the lock.
+func (s *FarmStore) cowIndex(id int) int {
+	if i, ok := s.byID[id]; ok {
+		return i
+	}
+	return -1
+}
+
+// tagIndex returns the position of the cow which currently has the given tag in s.cows, or
+// -1 if no cow which hasn't been deleted has it. The caller must hold the lock.
+func (s *FarmStore) tagIndex(tag string) int {
+	if i, ok := s.byTag[tag]; ok {
+		return i
+	}
+	return -1
+}
+
+// appendCow adds a new cow to the store and its indexes. The caller must hold the write
+// lock, and have checked that the cow's ID and tag are free.
+func (s *FarmStore) appendCow(cow Cow) {
+	cow.modifiedAt = s.clock.Now()
+	s.cows = append(s.cows, cow)
+	s.byID[cow.ID] = len(s.cows) - 1
+	s.byTag[cow.Tag] = len(s.cows) - 1
+}
+
+// nextCowID assigns the next cow ID in sequence. The caller must hold the write lock.
+func (s *FarmStore) nextCowID() int {
+	s.lastCowID++
+	return s.lastCowID
+}
+
+// nextHerdID assigns the next herd ID in sequence. The caller must hold the write lock.
+func (s *FarmStore) nextHerdID() int {
+	s.lastHerdID++
+	return s.lastHerdID
+}
+
+// newFarmStore returns a FarmStore seeded with a copy of the mock farm data. Each cow keeps
+// a history of its most recent historySize sensor readings, and moving averages of them
+// smoothed by smoothingAlpha. Cows and devices not updated for staleAfter are reported as
+// stale.
+func newFarmStore(historySize int, smoothingAlpha float64, staleAfter time.Duration, clock Clock) *FarmStore {
+	s := &FarmStore{
+		historySize:    historySize,
+		smoothingAlpha: smoothingAlpha,
+		staleAfter:     staleAfter,
+		clock:          clock,
+	}
+	s.seed()
+
+	return s
+}
+
+// seed replaces everything in the store with a copy of the mock farm data, and starts the
+// ID sequences afresh. The caller must hold the write lock, if the store is already
+// shared.
+func (s *FarmStore) seed() {
+	s.lastCowID, s.lastHerdID = 0, 0
+	s.load(mockCows, nil, mockRoboDog, mockDrone)
+}
+
+// load replaces everything in the store with copies of the given cows, herds and devices,
+// and starts each history afresh from the current readings. The ID sequences are moved on
+// past the loaded IDs, if they're not already. The caller must hold the write lock, if the
+// store is already shared.
+func (s *FarmStore) load(cows []Cow, herds []Herd, roboDog RoboDog, drone Drone) {
+	s.cows = append([]Cow(nil), cows...)
+	for i := range s.cows {
+		s.cows[i].modifiedAt = s.clock.Now()
+	}
+	s.reindex()
+	s.roboDog = roboDog
+	s.drone = drone
+	s.herds = make([]Herd, len(herds))
+	for i, herd := range herds {
+		s.herds[i] = copyHerd(herd)
+	}
+	s.history = make(map[int]*ringbuffer.Buffer[CowSensorReading])
+	s.droneHistory = ringbuffer.New[DroneTelemetry](s.historySize)
+	s.smoothed = make(map[int]*smoothedSensors)
+
+	// Seed each cow's history with its current reading.
+	for _, cow := range s.cows {
+		s.recordHistory(CowSensorReading{CowID: cow.ID, Sensors: cow.Sensors, RecordedAt: cow.LastUpdated})
+	}
+	s.recordDroneHistory()
+	s.modifiedAt = s.clock.Now()
+
+	for _, cow := range s.cows {
+		s.lastCowID = max(s.lastCowID, cow.ID)
+	}
+	for _, herd := range s.herds {
+		s.lastHerdID = max(s.lastHerdID, herd.ID)
+	}
+}
+
+// Clone returns a deep copy of the store, including its history and moving averages, which
+// can be changed without affecting the original.
+func (s *FarmStore) Clone() *FarmStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c := &FarmStore{
+		cows:           append([]Cow(nil), s.cows...),
+		roboDog:        s.roboDog,
+		drone:          s.drone,
+		herds:          make([]Herd, len(s.herds)),
+		history:        make(map[int]*ringbuffer.Buffer[CowSensorReading], len(s.history)),
+		historySize:    s.historySize,
+		lastCowID:      s.lastCowID,
+		lastHerdID:     s.lastHerdID,
+		droneHistory:   s.droneHistory.Clone(),
+		smoothed:       make(map[int]*smoothedSensors, len(s.smoothed)),
+		smoothingAlpha: s.smoothingAlpha,
+		staleAfter:     s.staleAfter,
+		clock:          s.clock,
+		modifiedAt:     s.modifiedAt,
+	}
+	c.reindex()
+
+	for i, herd := range s.herds {
+		c.herds[i] = copyHerd(herd)
+	}
+	for id, history := range s.history {
+		c.history[id] = history.Clone()
+	}
+	for id, smoothed := range s.smoothed {
+		copied := *smoothed
+		c.smoothed[id] = &copied
+	}
+
+	return c
+}
+
+// Reset restores the store to the mock farm data it started with, discarding every
+// change since, including new cows, herds and sensor history.
+func (s *FarmStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seed()
+	s.touch()
+}
+
+// Cows returns a copy of every cow in the store which hasn't been deleted.
+func (s *FarmStore) Cows() []Cow {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cows := make([]Cow, 0, len(s.cows))
+	for _, cow := range s.cows {
+		if !cow.Deleted() {
+			cows = append(cows, s.withDerivedHealth(cow))
+		}
+	}
+
+	return cows
+}
+
+// AllCows returns a copy of every cow in the store, including deleted cows.
+func (s *FarmStore) AllCows() []Cow {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cows := make([]Cow, len(s.cows))
+	for i, cow := range s.cows {
+		cows[i] = s.withDerivedHealth(cow)
+	}
+
+	return cows
+}
+
+// Cow returns the cow with the given ID, or ErrRecordNotFound if there's no such cow or
+// it has been deleted.
+func (s *FarmStore) Cow(id int) (Cow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.cowIndex(id)
+	if i == -1 || s.cows[i].Deleted() {
+		return Cow{}, ErrRecordNotFound
+	}
+
+	return s.withDerivedHealth(s.cows[i]), nil
+}
+
+// CowByTag returns the cow with the given ear tag, or ErrRecordNotFound if there's no such
+// cow. Deleted cows are skipped, as their tags may have been reused.
+func (s *FarmStore) CowByTag(tag string) (Cow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.tagIndex(tag)
+	if i == -1 {
+		return Cow{}, ErrRecordNotFound
+	}
+
+	return s.withDerivedHealth(s.cows[i]), nil
+}
+
+// RoboDog returns a copy of the robo-dog state.
+func (s *FarmStore) RoboDog() RoboDog {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roboDog := s.roboDog
+	roboDog.Stale, roboDog.SecondsSinceUpdate = s.freshness(roboDog.LastUpdated, s.clock.Now())
+	return roboDog
+}
+
+// Drone returns a copy of the drone state.
+func (s *FarmStore) Drone() Drone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	drone := s.drone
+	drone.Stale, drone.SecondsSinceUpdate = s.freshness(drone.LastUpdated, s.clock.Now())
+	return drone
+}
+
+// UpdateCowSensors applies a sensor reading taken at recordedAt to the cow with the given
+// ID, returning the cow as it was before and after the update, with its derived health
+// as for PatchCowSensors. Readings older than the cow's last update are ignored, with
+// ErrStaleReading, so that the latest reading always wins regardless of the order in
+// which buffered readings arrive.
+func (s *FarmStore) UpdateCowSensors(id int, sensors CowSensors, recordedAt time.Time) (Cow, Cow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.cowIndex(id)
+	if i == -1 || s.cows[i].Deleted() {
+		return Cow{}, Cow{}, ErrRecordNotFound
+	}
+	if recordedAt.Before(s.cows[i].LastUpdated) {
+		return Cow{}, Cow{}, ErrStaleReading
+	}
+
+	before := s.withDerivedHealth(s.cows[i])
+	s.cows[i].applySensors(sensors, recordedAt)
+	s.recordHistory(CowSensorReading{CowID: id, Sensors: sensors, RecordedAt: recordedAt})
+	s.touchCow(i)
+
+	return before, s.withDerivedHealth(s.cows[i]), nil
+}
+
+// PatchCowSensors merges the provided metrics into the last reading of the cow with the
+// given ID and applies the result as a new reading taken at recordedAt, returning the cow
+// as it was before and after. The merge happens under the lock, so concurrent partial
+// updates can't undo each other.
+func (s *FarmStore) PatchCowSensors(id int, patch CowSensorsPatch, recordedAt time.Time) (Cow, Cow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.cowIndex(id)
+	if i == -1 || s.cows[i].Deleted() {
+		return Cow{}, Cow{}, ErrRecordNotFound
+	}
+
+	before := s.withDerivedHealth(s.cows[i])
+	sensors := patch.apply(s.cows[i].Sensors)
+	if !recordedAt.Before(s.cows[i].LastUpdated) {
+		s.cows[i].applySensors(sensors, recordedAt)
+		s.recordHistory(CowSensorReading{CowID: id, Sensors: sensors, RecordedAt: recordedAt})
+		s.touchCow(i)
+	}
+
+	return before, s.withDerivedHealth(s.cows[i]), nil
+}
+
+// CowHistory returns the recorded sensor readings for the cow with the given ID, oldest
+// first, or ErrRecordNotFound if there is no such cow. A deleted cow's history is kept in
+// case it's restored, but isn't returned.
+func (s *FarmStore) CowHistory(id int) ([]CowSensorReading, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.cowIndex(id); i != -1 && s.cows[i].Deleted() {
+		return nil, ErrRecordNotFound
+	}
+
+	history, ok := s.history[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return history.Items(), nil
+}
+
+// recordHistory appends a reading to its cow's history and updates its moving averages.
+// Only readings which are applied to the cow are recorded, so each history is in
+// chronological order. The caller must hold the write lock.
+func (s *FarmStore) recordHistory(reading CowSensorReading) {
+	history, ok := s.history[reading.CowID]
+	if !ok {
+		history = ringbuffer.New[CowSensorReading](s.historySize)
+		s.history[reading.CowID] = history
+	}
+
+	history.Push(reading)
+
+	smoothed, ok := s.smoothed[reading.CowID]
+	if !ok {
+		smoothed = &smoothedSensors{}
+		s.smoothed[reading.CowID] = smoothed
+	}
+	smoothed.update(reading.Sensors, s.smoothingAlpha, !ok)
+}
+
+// applySensors copies a sensor reading onto the cow and re-derives its health from it.
+func (c *Cow) applySensors(sensors CowSensors, recordedAt time.Time) {
+	c.Sensors = sensors
+	c.Health.Temperature = sensors.Temperature
+	c.Health.HeartRate = sensors.HeartRate
+	c.Health.Activity = sensors.Activity
+
+	// An injury can't be detected by the collar, so only the sensor-derived statuses are
+	// recomputed here.
+	if c.Health.Status != "injured" {
+		if sensors.Temperature >= feverTemperature || sensors.HeartRate > maxNormalHeartRate {
+			c.Health.Status = "sick"
+		} else {
+			c.Health.Status = "healthy"
+		}
+	}
+
+	c.LastUpdated = recordedAt
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testEpoch is the time a test's MockClock starts at.
+var testEpoch = time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+// newTestStore returns a store seeded with the mock farm data, whose clock only moves
+// when the test moves it.
+func newTestStore(t *testing.T) (*FarmStore, *MockClock) {
+	t.Helper()
+
+	clock := NewMockClock(testEpoch)
+	return newFarmStore(10, 0.3, 10*time.Minute, clock), clock
+}
+
+// checkIndexes fails the test unless Cow and CowByTag agree with a linear scan of
+// AllCows: every cow is found by its ID unless it's deleted, every current cow is found by
+// its tag, and a deleted cow's tag only finds the cow it has been given to since.
+func checkIndexes(t *testing.T, s *FarmStore, step string) {
+	t.Helper()
+
+	all := s.AllCows()
+	current := make(map[string]int)
+	for _, cow := range all {
+		if !cow.Deleted() {
+			if id, ok := current[cow.Tag]; ok {
+				t.Fatalf("%s: cows %d and %d share the tag %q", step, id, cow.ID, cow.Tag)
+			}
+			current[cow.Tag] = cow.ID
+		}
+	}
+
+	for _, cow := range all {
+		got, err := s.Cow(cow.ID)
+		switch {
+		case cow.Deleted() && !errors.Is(err, ErrRecordNotFound):
+			t.Errorf("%s: Cow(%d) on a deleted cow: got error %v, want ErrRecordNotFound", step, cow.ID, err)
+		case !cow.Deleted() && err != nil:
+			t.Errorf("%s: Cow(%d): unexpected error %v", step, cow.ID, err)
+		case !cow.Deleted() && got.ID != cow.ID:
+			t.Errorf("%s: Cow(%d) returned cow %d", step, cow.ID, got.ID)
+		}
+
+		got, err = s.CowByTag(cow.Tag)
+		id, ok := current[cow.Tag]
+		switch {
+		case !ok && !errors.Is(err, ErrRecordNotFound):
+			t.Errorf("%s: CowByTag(%q) with no current cow: got error %v, want ErrRecordNotFound", step, cow.Tag, err)
+		case ok && err != nil:
+			t.Errorf("%s: CowByTag(%q): unexpected error %v", step, cow.Tag, err)
+		case ok && got.ID != id:
+			t.Errorf("%s: CowByTag(%q) returned cow %d, want %d", step, cow.Tag, got.ID, id)
+		}
+	}
+}
+
+func newTestCow(tag string, now time.Time) Cow {
+	return Cow{
+		Name:        "Test " + tag,
+		Tag:         tag,
+		Health:      Health{Status: "healthy"},
+		Sensors:     CowSensors{Temperature: 38.6, HeartRate: 65, Activity: "grazing", BatteryLevel: 90},
+		LastUpdated: now,
+	}
+}
+
+func TestFarmStoreIndexes(t *testing.T) {
+	s, clock := newTestStore(t)
+	checkIndexes(t, s, "seed")
+
+	created, err := s.InsertCows([]Cow{newTestCow("COW-100", clock.Now())}, 0)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	checkIndexes(t, s, "create")
+
+	bulk, err := s.InsertCows([]Cow{newTestCow("COW-101", clock.Now()), newTestCow("COW-102", clock.Now())}, 0)
+	if err != nil {
+		t.Fatalf("bulk create: %v", err)
+	}
+	checkIndexes(t, s, "bulk create")
+
+	var duplicates *DuplicateTagsError
+	_, err = s.InsertCows([]Cow{newTestCow("COW-103", clock.Now()), newTestCow("COW-101", clock.Now())}, 0)
+	if !errors.As(err, &duplicates) {
+		t.Fatalf("bulk create with a duplicate tag: got error %v, want *DuplicateTagsError", err)
+	}
+	if _, err := s.CowByTag("COW-103"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("a rejected bulk create indexed COW-103")
+	}
+	checkIndexes(t, s, "rejected bulk create")
+
+	clock.Advance(time.Minute)
+	if _, err := s.DeleteCow(created[0].ID, clock.Now()); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	checkIndexes(t, s, "delete")
+
+	if _, _, err := s.RestoreCow(created[0].ID); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	checkIndexes(t, s, "restore")
+
+	results, err := s.UpsertCows([]Cow{newTestCow("COW-102", clock.Now()), newTestCow("COW-104", clock.Now())}, 0)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if results[0].Inserted || results[0].Cow.ID != bulk[1].ID {
+		t.Errorf("import: COW-102 should have updated cow %d, got %+v", bulk[1].ID, results[0])
+	}
+	if !results[1].Inserted {
+		t.Errorf("import: COW-104 should have been inserted")
+	}
+	checkIndexes(t, s, "import")
+
+	s.Reset()
+	checkIndexes(t, s, "reset")
+	for _, tag := range []string{"COW-100", "COW-101", "COW-102", "COW-104"} {
+		if _, err := s.CowByTag(tag); !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("reset: CowByTag(%q) still finds a cow", tag)
+		}
+	}
+}
+
+func TestFarmStoreIndexesTagReuse(t *testing.T) {
+	s, clock := newTestStore(t)
+
+	original, err := s.InsertCows([]Cow{newTestCow("COW-200", clock.Now())}, 0)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := s.DeleteCow(original[0].ID, clock.Now()); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	checkIndexes(t, s, "delete")
+
+	// The deleted cow's tag is free to be given to a new cow.
+	reused, err := s.InsertCows([]Cow{newTestCow("COW-200", clock.Now())}, 0)
+	if err != nil {
+		t.Fatalf("create with a reused tag: %v", err)
+	}
+	checkIndexes(t, s, "reuse")
+
+	if got, err := s.CowByTag("COW-200"); err != nil || got.ID != reused[0].ID {
+		t.Errorf("CowByTag(COW-200) = cow %d, %v; want cow %d", got.ID, err, reused[0].ID)
+	}
+
+	// The original can't be restored while another cow has its tag.
+	if _, _, err := s.RestoreCow(original[0].ID); !errors.Is(err, ErrDuplicateTag) {
+		t.Errorf("restore with a reused tag: got error %v, want ErrDuplicateTag", err)
+	}
+	checkIndexes(t, s, "rejected restore")
+
+	// Once the new cow is deleted too, the original can be restored and gets its tag back.
+	if _, err := s.DeleteCow(reused[0].ID, clock.Now()); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, _, err := s.RestoreCow(original[0].ID); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	checkIndexes(t, s, "restore")
+
+	if got, err := s.CowByTag("COW-200"); err != nil || got.ID != original[0].ID {
+		t.Errorf("CowByTag(COW-200) = cow %d, %v; want cow %d", got.ID, err, original[0].ID)
+	}
+
+	// Importing a deleted cow's tag creates a new cow rather than updating the deleted one.
+	if _, err := s.DeleteCow(original[0].ID, clock.Now()); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	results, err := s.UpsertCows([]Cow{newTestCow("COW-200", clock.Now())}, 0)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if !results[0].Inserted {
+		t.Errorf("import of a deleted cow's tag updated cow %d instead of inserting", results[0].Cow.ID)
+	}
+	checkIndexes(t, s, "import")
+}
+
+func TestFarmStoreLastUpdated(t *testing.T) {
+	s, clock := newTestStore(t)
+	seeded := s.FarmState(false).LastUpdated
+
+	// Changes which are rejected or ignored leave the farm, and its last update, as it was.
+	clock.Advance(time.Minute)
+	if _, _, err := s.UpdateCowSensors(999, CowSensors{}, clock.Now()); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("update of a missing cow: got error %v, want ErrRecordNotFound", err)
+	}
+	if _, _, err := s.PatchCowSensors(999, CowSensorsPatch{}, clock.Now()); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("patch of a missing cow: got error %v, want ErrRecordNotFound", err)
+	}
+	if _, err := s.DeleteCow(999, clock.Now()); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("delete of a missing cow: got error %v, want ErrRecordNotFound", err)
+	}
+	if _, _, err := s.RestoreCow(999); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("restore of a missing cow: got error %v, want ErrRecordNotFound", err)
+	}
+	if _, err := s.UpsertCows(nil, 0); err != nil {
+		t.Fatalf("empty import: %v", err)
+	}
+	if _, _, err := s.UpdateCowSensors(1, CowSensors{Temperature: 38.6}, testEpoch.Add(-time.Hour)); !errors.Is(err, ErrStaleReading) {
+		t.Fatalf("stale update: got error %v, want ErrStaleReading", err)
+	}
+	if got := s.FarmState(false).LastUpdated; !got.Equal(seeded) {
+		t.Errorf("rejected changes moved the last update from %s to %s", seeded, got)
+	}
+
+	clock.Advance(time.Minute)
+	if _, _, err := s.UpdateCowSensors(1, CowSensors{Temperature: 38.6, HeartRate: 65, Activity: "grazing", BatteryLevel: 80}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got := s.FarmState(false).LastUpdated; !got.Equal(clock.Now()) {
+		t.Errorf("got last update %s after an update, want %s", got, clock.Now())
+	}
+}
+
+func TestFarmStoreCowModifiedAt(t *testing.T) {
+	s, clock := newTestStore(t)
+
+	cow, err := s.Cow(1)
+	if err != nil {
+		t.Fatalf("reading cow 1: %v", err)
+	}
+	lastUpdated := cow.LastUpdated
+
+	// Each change moves the cow's modification time, even though none of them is a
+	// sensor reading, so its last_updated stays where it was.
+	steps := []struct {
+		name   string
+		change func() error
+	}{
+		{"lifecycle", func() error {
+			_, _, err := s.SetCowLifecycle(1, lifecycleQuarantined)
+			return err
+		}},
+		{"herd", func() error {
+			_, err := s.InsertHerd(Herd{Name: "Test", Zone: cow.Location.Zone, CowIDs: []int{1}})
+			return err
+		}},
+		{"delete", func() error {
+			_, err := s.DeleteCow(1, clock.Now())
+			return err
+		}},
+		{"restore", func() error {
+			_, _, err := s.RestoreCow(1)
+			return err
+		}},
+	}
+
+	for _, step := range steps {
+		clock.Advance(time.Minute)
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if step.name == "delete" {
+			continue
+		}
+		got, err := s.Cow(1)
+		if err != nil {
+			t.Fatalf("%s: reading cow 1: %v", step.name, err)
+		}
+		if !got.modifiedAt.Equal(clock.Now()) {
+			t.Errorf("%s: got modification time %s, want %s", step.name, got.modifiedAt, clock.Now())
+		}
+		if !got.LastUpdated.Equal(lastUpdated) {
+			t.Errorf("%s: last update moved from %s to %s", step.name, lastUpdated, got.LastUpdated)
+		}
+	}
+
+	// A rejected change leaves it as it was.
+	modifiedAt := clock.Now()
+	clock.Advance(time.Minute)
+	if _, _, err := s.SetCowLifecycle(1, "unknown"); err == nil {
+		t.Fatalf("moving to an unknown lifecycle status succeeded")
+	}
+	if got, _ := s.Cow(1); !got.modifiedAt.Equal(modifiedAt) {
+		t.Errorf("a rejected change moved the modification time from %s to %s", modifiedAt, got.modifiedAt)
+	}
+}
+
+func TestFarmStateCountsActiveCows(t *testing.T) {
+	s, _ := newTestStore(t)
+	all := s.FarmState(false).TotalCows
+
+	if _, _, err := s.SetCowLifecycle(1, lifecycleSold); err != nil {
+		t.Fatalf("selling cow 1: %v", err)
+	}
+
+	state := s.FarmState(false)
+	if state.TotalCows != all-1 {
+		t.Errorf("got %d cows after selling one, want %d", state.TotalCows, all-1)
+	}
+	if stats := s.CowStats("", false); stats.TotalCows != state.TotalCows || stats.ByHealthStatus["healthy"] != state.HealthyCows || stats.ByHealthStatus["sick"] != state.SickCows {
+		t.Errorf("farm state counts %d cows (%d healthy, %d sick), but the herd statistics %d (%d healthy, %d sick)",
+			state.TotalCows, state.HealthyCows, state.SickCows, stats.TotalCows, stats.ByHealthStatus["healthy"], stats.ByHealthStatus["sick"])
+	}
+
+	zoneTotal := 0
+	for _, zone := range state.Zones {
+		zoneTotal += zone.TotalCows
+	}
+	if zoneTotal != state.TotalCows {
+		t.Errorf("the zones count %d cows, but the farm %d", zoneTotal, state.TotalCows)
+	}
+
+	if got := s.FarmState(true).TotalCows; got != all {
+		t.Errorf("got %d cows including inactive ones, want %d", got, all)
+	}
+}
+
+func TestUpsertCowsKeepsHerdZones(t *testing.T) {
+	s, clock := newTestStore(t)
+
+	member, err := s.Cow(1)
+	if err != nil {
+		t.Fatalf("reading cow 1: %v", err)
+	}
+	herd, err := s.InsertHerd(Herd{Name: "Test", Zone: member.Location.Zone, CowIDs: []int{member.ID}})
+	if err != nil {
+		t.Fatalf("inserting a herd: %v", err)
+	}
+
+	moved := newTestCow(member.Tag, clock.Now())
+	moved.Location = Location{Latitude: 40.7, Longitude: -74.0, Zone: "Elsewhere"}
+	stayed := newTestCow(member.Tag, clock.Now())
+	stayed.Location = Location{Latitude: 40.7, Longitude: -74.0, Zone: herd.Zone}
+	other := newTestCow("COW-300", clock.Now())
+	other.Location.Zone = "Elsewhere"
+
+	results, err := s.UpsertCows([]Cow{moved, other}, 0)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if _, ok := results[0].Errors["location.zone"]; !ok {
+		t.Errorf("moving a herd member out of its herd's zone: got errors %v, want location.zone", results[0].Errors)
+	}
+	if got, _ := s.Cow(1); got.Location.Zone != herd.Zone {
+		t.Errorf("a rejected row moved cow 1 to %q", got.Location.Zone)
+	}
+	if results[1].Errors != nil || !results[1].Inserted {
+		t.Errorf("the rest of the import should still be applied, got %+v", results[1])
+	}
+
+	// A member can still be updated within its herd's zone.
+	results, err = s.UpsertCows([]Cow{stayed}, 0)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if results[0].Errors != nil {
+		t.Errorf("updating a herd member within its herd's zone: got errors %v", results[0].Errors)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// streamFlushItems is how many values a jsonStream writes between flushes.
+const streamFlushItems = 100
+
+// jsonStream writes a JSON response straight to the client a piece at a time, for
+// responses such as the farm export which would take too much memory to build in full
+// like writeJSON does. The caller writes the document's objects and arrays by hand, and
+// each value in them, such as a single cow, is encoded by the stream's json.Encoder. Keys
+// are camelCased and the output indented just as writeJSON would.
+//
+// Once writing to the client fails, e.g. because it has gone away, everything else
+// written to the stream is discarded, and close reports the error.
+type jsonStream struct {
+	w      io.Writer
+	rc     *http.ResponseController
+	enc    *json.Encoder
+	camel  bool   // rewrite keys to camelCase
+	pretty bool   // indent with tabs, like json.Indent
+	empty  []bool // for each open object or array, innermost last, whether it's empty
+	items  int    // values written since the last flush
+	err    error  // the first error writing to the client
+}
+
+// streamJSON sends the status and headers of a streamed JSON response, and returns the
+// stream to write its body with. The body isn't known until it's been sent, so the
+// response has no Content-Length or ETag.
+func (app *application) streamJSON(w http.ResponseWriter, r *http.Request, status int, headers http.Header) *jsonStream {
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+
+	s := &jsonStream{
+		w:      w,
+		rc:     http.NewResponseController(w),
+		camel:  app.wantsCamelCase(r),
+		pretty: app.wantsPrettyJSON(r),
+	}
+	s.enc = json.NewEncoder(s)
+
+	return s
+}
+
+// beginObject opens an object as the member key of the enclosing object, or as the next
+// element of the enclosing array or the whole document if key is empty.
+func (s *jsonStream) beginObject(key string) {
+	s.begin(key, "{")
+}
+
+// beginArray opens an array, like beginObject.
+func (s *jsonStream) beginArray(key string) {
+	s.begin(key, "[")
+}
+
+// endObject closes the innermost open object.
+func (s *jsonStream) endObject() {
+	s.end("}")
+}
+
+// endArray closes the innermost open array.
+func (s *jsonStream) endArray() {
+	s.end("]")
+}
+
+// value encodes v as the member key of the enclosing object, or as the next element of
+// the enclosing array if key is empty. The response is flushed every streamFlushItems
+// values. A value which can't be encoded is a bug, and the status has already been sent,
+// so it panics, and recoverPanic logs the error and cuts the response short.
+func (s *jsonStream) value(key string, v any) {
+	s.next(key)
+	if s.err != nil {
+		return
+	}
+
+	err := s.enc.Encode(v)
+	if err != nil && s.err == nil {
+		panic(fmt.Errorf("streaming response: %w", err))
+	}
+
+	s.items++
+	if s.items >= streamFlushItems {
+		s.flush()
+		s.items = 0
+	}
+}
+
+// close ends the document and flushes it, returning the error writing to the client, if
+// there was one.
+func (s *jsonStream) close() error {
+	s.write("\n")
+	s.flush()
+	return s.err
+}
+
+// Write is how the stream's json.Encoder writes each value. It drops the newline the
+// encoder ends each value with, and camelCases and indents the value if need be.
+func (s *jsonStream) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	js := bytes.TrimSuffix(p, []byte("\n"))
+	if s.camel {
+		var err error
+		js, err = camelCaseJSON(js)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if s.pretty {
+		var indented bytes.Buffer
+		err := json.Indent(&indented, js, strings.Repeat("\t", len(s.empty)), "\t")
+		if err != nil {
+			return 0, err
+		}
+		js = indented.Bytes()
+	}
+
+	_, s.err = s.w.Write(js)
+	if s.err != nil {
+		return 0, s.err
+	}
+	return len(p), nil
+}
+
+func (s *jsonStream) begin(key, delim string) {
+	s.next(key)
+	s.write(delim)
+	s.empty = append(s.empty, true)
+}
+
+func (s *jsonStream) end(delim string) {
+	depth := len(s.empty) - 1
+	if !s.empty[depth] {
+		s.newline(depth)
+	}
+	s.empty = s.empty[:depth]
+	s.write(delim)
+}
+
+// next separates what's about to be written from whatever came before it in the
+// enclosing object or array, and writes its key, if it has one.
+func (s *jsonStream) next(key string) {
+	depth := len(s.empty)
+	if depth > 0 {
+		if !s.empty[depth-1] {
+			s.write(",")
+		}
+		s.empty[depth-1] = false
+		s.newline(depth)
+	}
+
+	if key == "" {
+		return
+	}
+	if s.camel {
+		key = snakeToCamel(key)
+	}
+	js, _ := json.Marshal(key)
+	s.write(string(js) + ":")
+	if s.pretty {
+		s.write(" ")
+	}
+}
+
+// newline starts a new line indented to depth, if the output is pretty.
+func (s *jsonStream) newline(depth int) {
+	if s.pretty {
+		s.write("\n" + strings.Repeat("\t", depth))
+	}
+}
+
+func (s *jsonStream) write(str string) {
+	if s.err == nil {
+		_, s.err = io.WriteString(s.w, str)
+	}
+}
+
+// flush sends what has been written so far to the client, if the ResponseWriter
+// supports it.
+func (s *jsonStream) flush() {
+	if s.err != nil {
+		return
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.err = err
+	}
+}
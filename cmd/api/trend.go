@@ -0,0 +1,103 @@
+package main
+
+import "time"
+
+// Health trends reported for each cow.
+const (
+	trendImproving = "improving"
+	trendStable    = "stable"
+	trendWorsening = "worsening"
+	trendUnknown   = "unknown"
+)
+
+// Trend classification parameters. The trend is fitted over the most recent readings
+// only, so that it reflects how the cow is doing now rather than over the whole history.
+const (
+	trendWindow            = 12  // readings
+	minTrendReadings       = 3   // readings
+	temperatureTrendSlope  = 0.1 // degrees Celsius per hour
+	heartRateTrendSlope    = 2.0 // beats per minute per hour
+	minTrendWindowDuration = time.Minute
+)
+
+// healthTrend classifies the direction of a cow's vital signs from its recent readings,
+// oldest first. The slopes of temperature and heart rate are estimated with a least
+// squares linear regression: if either is rising faster than its threshold the cow is
+// worsening, if neither is rising and at least one is falling faster than its threshold
+// the cow is improving, and otherwise it's stable. Cows without enough readings spread
+// over enough time to fit a line report trendUnknown.
+func healthTrend(readings []CowSensorReading) string {
+	if len(readings) < minTrendReadings {
+		return trendUnknown
+	}
+
+	first := readings[0].RecordedAt
+	if readings[len(readings)-1].RecordedAt.Sub(first) < minTrendWindowDuration {
+		return trendUnknown
+	}
+
+	hours := make([]float64, len(readings))
+	temperatures := make([]float64, len(readings))
+	heartRates := make([]float64, len(readings))
+	for i, reading := range readings {
+		hours[i] = reading.RecordedAt.Sub(first).Hours()
+		temperatures[i] = reading.Sensors.Temperature
+		heartRates[i] = float64(reading.Sensors.HeartRate)
+	}
+
+	temperatureSlope, ok := regressionSlope(hours, temperatures)
+	if !ok {
+		return trendUnknown
+	}
+	heartRateSlope, _ := regressionSlope(hours, heartRates)
+
+	switch {
+	case temperatureSlope >= temperatureTrendSlope || heartRateSlope >= heartRateTrendSlope:
+		return trendWorsening
+	case temperatureSlope <= -temperatureTrendSlope || heartRateSlope <= -heartRateTrendSlope:
+		return trendImproving
+	default:
+		return trendStable
+	}
+}
+
+// regressionSlope returns the slope of the least squares line through the points (x, y).
+// It returns false if the slope is undefined because every x is the same.
+func regressionSlope(x, y []float64) (float64, bool) {
+	n := float64(len(x))
+
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var covariance, variance float64
+	for i := range x {
+		covariance += (x[i] - meanX) * (y[i] - meanY)
+		variance += (x[i] - meanX) * (x[i] - meanX)
+	}
+	if variance == 0 {
+		return 0, false
+	}
+
+	return covariance / variance, true
+}
+
+// withDerivedHealth returns the cow with its health trend and smoothed readings filled in
+// from its history, and its freshness. The caller must hold the read lock.
+func (s *FarmStore) withDerivedHealth(cow Cow) Cow {
+	cow.Stale, cow.SecondsSinceUpdate = s.freshness(cow.LastUpdated, s.clock.Now())
+
+	cow.Health.Trend = trendUnknown
+	if history, ok := s.history[cow.ID]; ok {
+		cow.Health.Trend = healthTrend(history.Last(trendWindow))
+	}
+	if smoothed, ok := s.smoothed[cow.ID]; ok {
+		cow.Health.TemperatureSmoothed = smoothed.Temperature
+		cow.Health.HeartRateSmoothed = smoothed.HeartRate
+	}
+
+	return cow
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+
+	"mooveit-backend.mooveit.com/internal/vcs"
+)
+
+// versionHandler reports which build is running, for deployment verification and for the
+// dashboard to display. It's deliberately unauthenticated as none of it is sensitive.
+func (app *application) versionHandler(w http.ResponseWriter, r *http.Request) {
+	env := envelope{
+		"version":          version,
+		"semantic_version": vcs.SemanticVersion(),
+		"vcs_version":      vcs.Version(),
+		"go_version":       runtime.Version(),
+		"os":               runtime.GOOS,
+		"arch":             runtime.GOARCH,
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
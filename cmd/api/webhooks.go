@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// errRetryable marks a webhook delivery failure which is worth retrying.
+var errRetryable = errors.New("retryable webhook failure")
+
+// webhookNotifier POSTs alerts as JSON to a set of external URLs.
+type webhookNotifier struct {
+	urls    []string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// newWebhookNotifier returns a webhookNotifier which gives each request up to timeout to
+// complete, and retries failed deliveries up to retries times with exponential backoff.
+func newWebhookNotifier(urls []string, timeout time.Duration, retries int) *webhookNotifier {
+	return &webhookNotifier{
+		urls:    urls,
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		backoff: 500 * time.Millisecond,
+	}
+}
+
+// Notify delivers the alert to every webhook URL, returning an error describing any URLs
+// which couldn't be delivered to.
+func (n *webhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(envelope{"alert": alert})
+	if err != nil {
+		return err
+	}
+
+	return n.send(ctx, body)
+}
+
+// send POSTs a JSON body to every webhook URL.
+func (n *webhookNotifier) send(ctx context.Context, body []byte) error {
+	var errs []error
+	for _, url := range n.urls {
+		err := n.deliver(ctx, url, body)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", url, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver POSTs body to url, retrying on timeouts, connection errors and 5xx responses.
+// Other 4xx responses mean the request itself was rejected, so they aren't retried.
+func (n *webhookNotifier) deliver(ctx context.Context, url string, body []byte) error {
+	backoff := n.backoff
+
+	var err error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		err = n.post(ctx, url, body)
+		if err == nil || !errors.Is(err, errRetryable) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", n.retries+1, err)
+}
+
+// post makes a single delivery attempt.
+func (n *webhookNotifier) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRetryable, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 500:
+		return fmt.Errorf("%w: status %d", errRetryable, res.StatusCode)
+	case res.StatusCode >= 300:
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+
+	return nil
+}
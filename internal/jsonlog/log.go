@@ -1,11 +1,14 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	stdlog "log"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,10 +23,20 @@ type Logger struct {
 	out      io.Writer
 	minLevel Level
 	mutex    sync.Mutex
+
+	// sampleRate and sampleCount control the sampled helpers, which write only one in
+	// every sampleRate entries. Both are guarded by the mutex.
+	sampleRate  int
+	sampleCount int
+
+	// contextProperties extracts request-scoped properties from a context for the Ctx
+	// helpers. It's guarded by the mutex.
+	contextProperties func(ctx context.Context) map[string]string
 }
 
 const (
 	LevelInfo Level = iota // Has the value 0
+	LevelWarn
 	LevelInfoError
 	LevelError
 	LevelFatal
@@ -35,6 +48,8 @@ func (l Level) String() string {
 	switch l {
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelInfoError:
 		return "ERROR"
 	case LevelError:
@@ -59,11 +74,79 @@ func init() {
 // level to a specific output destination.
 func New(out io.Writer, minLevel Level) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		out:        out,
+		minLevel:   minLevel,
+		sampleRate: 1,
 	}
 }
 
+// SetOutput changes the destination of the default logger, which writes to standard out
+// until this is called. It's safe to call while other goroutines are logging.
+func SetOutput(out io.Writer) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	log.out = out
+}
+
+// MinLevel returns the minimum severity level written by the default logger.
+func MinLevel() Level {
+	return log.minLevel
+}
+
+// SetSampleRate makes the sampled helpers, such as SampledInfoWithProperties, write only
+// one in every n entries. A rate of 1 (the default) writes them all. Entries written with
+// the other helpers, including every warning and error, are never sampled.
+func SetSampleRate(n int) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	log.sampleRate = max(n, 1)
+	log.sampleCount = 0
+}
+
+// sample reports whether the next sampled entry should be written. The first entry is
+// always written, then every sampleRate'th one after it.
+func sample() bool {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	keep := log.sampleCount%log.sampleRate == 0
+	log.sampleCount++
+	return keep
+}
+
+// SetContextProperties registers the function which the Ctx helpers, such as InfoCtx,
+// use to pull request-scoped properties like the request ID out of a context. This keeps
+// the package free of any knowledge of the application's context keys.
+func SetContextProperties(fn func(ctx context.Context) map[string]string) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	log.contextProperties = fn
+}
+
+// withContext merges the request-scoped properties from ctx into properties. Properties
+// passed explicitly take precedence over those from the context.
+func withContext(ctx context.Context, properties map[string]string) map[string]string {
+	log.mutex.Lock()
+	fn := log.contextProperties
+	log.mutex.Unlock()
+
+	if fn == nil || ctx == nil {
+		return properties
+	}
+
+	merged := fn(ctx)
+	if merged == nil {
+		merged = make(map[string]string, len(properties))
+	}
+	for key, value := range properties {
+		merged[key] = value
+	}
+	return merged
+}
+
 // MARK: - Info
 func Info(format string, args ...interface{}) {
 	var message string
@@ -72,41 +155,88 @@ func Info(format string, args ...interface{}) {
 	} else {
 		message = "💭 " + format
 	}
-	writeLog(LevelInfo, message, nil)
+	log.writeLog(LevelInfo, message, nil)
 }
 
 // Info Declare some helper methods for writing log entries at the different levels. Notice
 // that these all accept a map as the second parameter which can contain any arbitrary
 // 'properties' that you want to appear in the log entry.
 func InfoWithProperties(message string, properties map[string]string) {
-	writeLog(LevelInfo, "💭 "+message, properties)
+	log.writeLog(LevelInfo, "💭 "+message, properties)
+}
+
+// InfoCtx writes an INFO entry with the request-scoped properties from ctx merged into
+// properties, so that it can be correlated with the request that caused it.
+func InfoCtx(ctx context.Context, message string, properties map[string]string) {
+	log.writeLog(LevelInfo, "💭 "+message, withContext(ctx, properties))
+}
+
+// SampledInfoWithProperties writes an INFO entry subject to the sample rate, for
+// high-volume entries such as one per request.
+func SampledInfoWithProperties(message string, properties map[string]string) {
+	if !sample() {
+		return
+	}
+	log.writeLog(LevelInfo, "💭 "+message, properties)
+}
+
+// SampledInfoCtx writes an INFO entry subject to the sample rate, with the request-scoped
+// properties from ctx merged in, for high-volume entries such as one per request.
+func SampledInfoCtx(ctx context.Context, message string, properties map[string]string) {
+	if !sample() {
+		return
+	}
+	log.writeLog(LevelInfo, "💭 "+message, withContext(ctx, properties))
+}
+
+// MARK: - Warn
+func Warn(format string, args ...interface{}) {
+	message := fmt.Sprintf("⚠️ "+format, args...)
+	log.writeLog(LevelWarn, message, nil)
+}
+
+func WarnWithProperties(message string, properties map[string]string) {
+	log.writeLog(LevelWarn, "⚠️ "+message, properties)
+}
+
+// WarnCtx writes a WARN entry with the request-scoped properties from ctx merged in.
+func WarnCtx(ctx context.Context, message string, properties map[string]string) {
+	log.writeLog(LevelWarn, "⚠️ "+message, withContext(ctx, properties))
 }
 
 // MARK: - Error
 func Error(format string, args ...interface{}) {
 	message := fmt.Sprintf("❌ "+format, args...)
-	writeLog(LevelInfoError, message, nil)
+	log.writeLog(LevelInfoError, message, nil)
 }
 
 func ErrorWithProperties(err error, properties map[string]string) {
-	writeLog(LevelError, "❌ "+err.Error(), properties)
+	log.writeLog(LevelError, "❌ "+err.Error(), properties)
+}
+
+// ErrorCtx writes an ERROR entry, with a stack trace, with the request-scoped properties
+// from ctx merged in.
+func ErrorCtx(ctx context.Context, err error, properties map[string]string) {
+	log.writeLog(LevelError, "❌ "+err.Error(), withContext(ctx, properties))
 }
 
 // MARK: - Fatal
 func Fatal(err error) {
-	writeLog(LevelFatal, "🆘 "+err.Error(), nil)
+	log.writeLog(LevelFatal, "🆘 "+err.Error(), nil)
 	os.Exit(1) // For entries at the FATAL level, we also terminate the application.
 }
 
 func FatalWithProperties(err error, properties map[string]string) {
-	writeLog(LevelFatal, "🆘 "+err.Error(), properties)
+	log.writeLog(LevelFatal, "🆘 "+err.Error(), properties)
 	os.Exit(1) // For entries at the FATAL level, we also terminate the application.
 }
 
-func writeLog(level Level, message string, properties map[string]string) (int, error) {
+// writeLog writes an entry to the logger's output, if it's at or above the logger's
+// minimum level.
+func (l *Logger) writeLog(level Level, message string, properties map[string]string) (int, error) {
 	// If the severity level of the log entry is below the minimum severity for the
 	// logger, then return with no further action.
-	if level < log.minLevel {
+	if level < l.minLevel {
 		return 0, nil
 	}
 
@@ -143,16 +273,33 @@ func writeLog(level Level, message string, properties map[string]string) (int, e
 	// Lock the mutex so that no two writes to the output destination can happen
 	// concurrently. If we don't do this, it's possible that the text for two or more
 	// log entries will be intermingled in the output.
-	log.mutex.Lock()
-	defer log.mutex.Unlock()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 
 	// Write the log entry followed by a newline.
-	return log.out.Write(append(line, '\n'))
+	return l.out.Write(append(line, '\n'))
 }
 
 // We also implement a Write() method on our Logger type so that it satisfies the
 // io.Writer interface. This writes a log entry at the ERROR level with no additional
-// properties.
+// properties to the logger's own output, so that a standard library *log.Logger wrapping
+// it (such as the http.Server's ErrorLog) produces structured entries. The trailing newline added by
+// the standard logger is trimmed, and no stack trace is included as it would only show
+// the standard library's logging internals.
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return writeLog(LevelError, string(message), nil)
+	_, err = l.writeLog(LevelInfoError, "❌ "+strings.TrimRight(string(message), "\n"), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// Report the whole message as written, as a *log.Logger treats a short write as
+	// an error.
+	return len(message), nil
+}
+
+// StdLogger returns a standard library *log.Logger which writes through the default
+// logger as ERROR entries, for APIs which need one such as the http.Server's ErrorLog. It
+// follows the default logger's output, including any change made with SetOutput.
+func StdLogger() *stdlog.Logger {
+	return stdlog.New(log, "", 0)
 }
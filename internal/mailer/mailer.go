@@ -0,0 +1,145 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	htmltemplate "html/template"
+	"net"
+	"text/template"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// templateFS holds the email templates, embedded into the binary at build time.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// The mail package only applies the dialer's timeout to the connection once the server's
+// greeting has been read, so a server which accepts connections but never replies would
+// hang a send forever. Apply it from the moment the connection is made instead.
+func init() {
+	mail.NetDialTimeout = func(network, address string, timeout time.Duration) (net.Conn, error) {
+		conn, err := net.DialTimeout(network, address, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if timeout > 0 {
+			conn.SetDeadline(time.Now().Add(timeout))
+		}
+		return conn, nil
+	}
+}
+
+// Mailer sends templated emails through an SMTP server.
+type Mailer struct {
+	dialer *mail.Dialer
+	sender string
+}
+
+// New returns a Mailer which sends from sender through the SMTP server at host:port,
+// authenticating with username and password if they're set.
+func New(host string, port int, username, password, sender string) Mailer {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return Mailer{
+		dialer: dialer,
+		sender: sender,
+	}
+}
+
+// Send renders the named template file with data and emails it to the recipients. The
+// template must define "subject", "plainBody" and "htmlBody" templates. The HTML body is
+// rendered with html/template so that values in data are escaped.
+func (m Mailer) Send(recipients []string, templateFile string, data any) error {
+	return m.SendContext(context.Background(), recipients, templateFile, data)
+}
+
+// SendContext is like Send, but doesn't retry once ctx is done, and cuts the SMTP
+// connection's deadlines short so that a send doesn't run past ctx's deadline.
+func (m Mailer) SendContext(ctx context.Context, recipients []string, templateFile string, data any) error {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	msg := mail.NewMessage()
+	msg.SetHeader("To", recipients...)
+	msg.SetHeader("From", m.sender)
+	msg.SetHeader("Subject", subject.String())
+	msg.SetBody("text/plain", plainBody.String())
+	msg.AddAlternative("text/html", htmlBody.String())
+
+	// Try sending the email up to three times before aborting and returning the final
+	// error. We sleep for 500 milliseconds between each attempt.
+	for i := 1; i <= 3; i++ {
+		if i > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+
+		var dialer *mail.Dialer
+		dialer, err = m.dialerFor(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = dialer.DialAndSend(msg)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// dialerFor returns a copy of the dialer whose timeout doesn't run past ctx's deadline,
+// or ctx's error if it's already done.
+func (m Mailer) dialerFor(ctx context.Context) (*mail.Dialer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dialer := *m.dialer
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		if remaining < dialer.Timeout {
+			dialer.Timeout = remaining
+		}
+	}
+
+	return &dialer, nil
+}
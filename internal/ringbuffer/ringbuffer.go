@@ -0,0 +1,93 @@
+package ringbuffer
+
+// Buffer is a fixed-capacity FIFO buffer. Once it's full, pushing a new item overwrites
+// the oldest one, so memory use stays bounded no matter how many items are pushed.
+type Buffer[T any] struct {
+	items []T
+	start int
+	size  int
+}
+
+// New returns an empty Buffer which holds at most capacity items. A capacity of less
+// than one is treated as one.
+func New[T any](capacity int) *Buffer[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &Buffer[T]{items: make([]T, capacity)}
+}
+
+// Push appends an item to the buffer, overwriting the oldest item if the buffer is full.
+func (b *Buffer[T]) Push(item T) {
+	if b.size < len(b.items) {
+		b.items[(b.start+b.size)%len(b.items)] = item
+		b.size++
+		return
+	}
+
+	b.items[b.start] = item
+	b.start = (b.start + 1) % len(b.items)
+}
+
+// Len returns the number of items currently held in the buffer.
+func (b *Buffer[T]) Len() int {
+	return b.size
+}
+
+// Cap returns the maximum number of items the buffer can hold.
+func (b *Buffer[T]) Cap() int {
+	return len(b.items)
+}
+
+// Items returns a copy of the items in the buffer, oldest first.
+func (b *Buffer[T]) Items() []T {
+	items := make([]T, b.size)
+	for i := range items {
+		items[i] = b.items[(b.start+i)%len(b.items)]
+	}
+
+	return items
+}
+
+// Last returns a copy of the n most recent items in the buffer, oldest first. If the
+// buffer holds fewer than n items, all of them are returned.
+func (b *Buffer[T]) Last(n int) []T {
+	n = max(0, min(n, b.size))
+
+	items := make([]T, n)
+	offset := b.size - n
+	for i := range items {
+		items[i] = b.items[(b.start+offset+i)%len(b.items)]
+	}
+
+	return items
+}
+
+// Clone returns a copy of the buffer, with the same capacity and items, which can be
+// pushed to without affecting the original.
+func (b *Buffer[T]) Clone() *Buffer[T] {
+	return &Buffer[T]{
+		items: append([]T(nil), b.items...),
+		start: b.start,
+		size:  b.size,
+	}
+}
+
+// DropOldest removes items from the front of the buffer, oldest first, for as long as
+// drop returns true for them, and returns the number removed. It's for pruning a buffer
+// whose items are in order, such as readings by time.
+func (b *Buffer[T]) DropOldest(drop func(T) bool) int {
+	var zero T
+
+	removed := 0
+	for b.size > 0 && drop(b.items[b.start]) {
+		// Clear the slot so that it doesn't keep anything the item refers to alive.
+		b.items[b.start] = zero
+		b.start = (b.start + 1) % len(b.items)
+		b.size--
+		removed++
+	}
+
+	return removed
+}
@@ -1,6 +1,9 @@
 package validator
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+)
 
 // EmailRX Declare a regular expression for sanity checking the format of email addresses (we'll
 // use this later in the book). If you're interested, this regular expression pattern is
@@ -14,6 +17,9 @@ var (
 // Validator Define a new Validator type which contains a map of validation errors.
 type Validator struct {
 	Errors map[string]string
+
+	// prefix is prepended to the key of every error added through a nested Validator.
+	prefix string
 }
 
 // New is a helper which creates a new Validator instance with an empty errors map.
@@ -26,9 +32,27 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+// Nested returns a Validator for a nested struct or list element, which adds its errors to
+// the same map with their keys under key. Keys are dotted for fields and bracketed for
+// indexes, so "latitude" under "location" is "location.latitude", and "tag" under "[2]" is
+// "[2].tag". Nested validators can be nested in turn, e.g. "cows[2].location.latitude".
+// As they share the map, Valid() reports on every error, not just the nested ones.
+func (v *Validator) Nested(key string) *Validator {
+	return &Validator{Errors: v.Errors, prefix: joinKey(v.prefix, key)}
+}
+
+// joinKey appends key to prefix, with a dot unless key is an index such as "[2]".
+func joinKey(prefix, key string) string {
+	if prefix == "" || strings.HasPrefix(key, "[") {
+		return prefix + key
+	}
+	return prefix + "." + key
+}
+
 // AddError adds an error message to the map (so long as no entry already exists for
 // the given key).
 func (v *Validator) AddError(key, message string) {
+	key = joinKey(v.prefix, key)
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
@@ -5,34 +5,96 @@ import (
 	"runtime/debug"
 )
 
-func Version() string {
-	var (
-		time     string
-		revision string
-		modified bool
-	)
+// version holds the semantic version of a release build, injected at build time with:
+//
+//	go build -ldflags "-X mooveit-backend.mooveit.com/internal/vcs.version=v1.4.2" ./cmd/api
+var version string
+
+// shortRevisionLength is the number of characters of the commit hash shown in the
+// friendly version, matching git's default abbreviation.
+const shortRevisionLength = 7
+
+// buildInfo holds the VCS settings recorded by the Go toolchain.
+type buildInfo struct {
+	time     string
+	revision string
+	modified bool
+}
+
+// readBuildInfo returns the VCS settings for the running binary. They're empty for builds
+// without VCS stamping, such as go run.
+func readBuildInfo() buildInfo {
+	var info buildInfo
 
-	buildInfo, ok := debug.ReadBuildInfo()
+	bi, ok := debug.ReadBuildInfo()
 	if ok {
-		for _, s := range buildInfo.Settings {
+		for _, s := range bi.Settings {
 			switch s.Key {
 			case "vcs.time":
-				time = s.Value
+				info.time = s.Value
 			case "vcs.revision":
-				revision = s.Value
+				info.revision = s.Value
 			case "vcs.modified":
 				if s.Value == "true" {
-					modified = true
+					info.modified = true
 				}
 			}
 		}
 	}
 
+	return info
+}
+
+// SemanticVersion returns the semantic version injected with -ldflags, or an empty string
+// for builds which aren't releases.
+func SemanticVersion() string {
+	return version
+}
+
+// Version returns the raw VCS version of the running build: the commit time and hash
+// recorded by the Go toolchain. Builds without VCS information report "unknown".
+func Version() string {
+	info := readBuildInfo()
+
+	// Without VCS stamping there's nothing meaningful to report, and "-" is confusing
+	// in the logs.
+	if info.time == "" && info.revision == "" {
+		return "unknown"
+	}
+
 	// If the code is modified, the version will have a "-dirty" suffix
-	if modified {
-		return fmt.Sprintf("%s-%s-dirty", time, revision)
+	if info.modified {
+		return fmt.Sprintf("%s-%s-dirty", info.time, info.revision)
 	}
 
 	// Otherwise we return the time and commit hash as a version #
-	return fmt.Sprintf("%s-%s", time, revision)
+	return fmt.Sprintf("%s-%s", info.time, info.revision)
+}
+
+// FriendlyVersion combines the semantic version with the short commit hash and dirty
+// flag, e.g. "v1.4.2 (abc1234, dirty)". Builds without a semantic version are reported
+// as "dev", and the parenthesised part is left out without VCS information.
+func FriendlyVersion() string {
+	semver := version
+	if semver == "" {
+		semver = "dev"
+	}
+
+	info := readBuildInfo()
+	if info.revision == "" {
+		if version == "" {
+			return "unknown"
+		}
+		return semver
+	}
+
+	revision := info.revision
+	if len(revision) > shortRevisionLength {
+		revision = revision[:shortRevisionLength]
+	}
+
+	if info.modified {
+		return fmt.Sprintf("%s (%s, dirty)", semver, revision)
+	}
+	return fmt.Sprintf("%s (%s)", semver, revision)
 }